@@ -0,0 +1,243 @@
+package crvorm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// 游标分页的默认每页条数，与未提供分页信息时的查询条数保持一致
+const DEFAULT_CURSOR_PAGE_SIZE = 1000
+
+// 获取游标分页使用的排序字段，未指定排序时按照id排序
+// 排序字段中不包含id时在最后追加id，保证排序结果唯一，游标位置不会重复或遗漏数据
+// 排序字段必须包含在查询字段中，用于从最后一行数据中获取下一页的游标
+func GetCursorSorters(sorters *[]Sorter, fields *[]Field) ([]Sorter, error) {
+	cursorSorters := []Sorter{}
+	hasId := false
+	if sorters != nil {
+		for _, sorter := range *sorters {
+			if sorter.Values != nil && len(*sorter.Values) > 0 {
+				slog.Error("GetCursorSorters sorter with values can not be used with cursor", "field", sorter.Field)
+				return nil, &QueryValidationError{Kind: INVALID_KIND_CURSOR, Value: "sorter with values " + sorter.Field}
+			}
+			if sorter.Field == CC_ID {
+				hasId = true
+			}
+			cursorSorters = append(cursorSorters, sorter)
+		}
+	}
+
+	if !hasId {
+		cursorSorters = append(cursorSorters, Sorter{Field: CC_ID, Order: "asc"})
+	}
+
+	for _, sorter := range cursorSorters {
+		if !hasQueryField(fields, sorter.Field) {
+			slog.Error("GetCursorSorters sorter field must be in query fields", "field", sorter.Field)
+			return nil, &QueryValidationError{Kind: INVALID_KIND_CURSOR, Value: "sorter field not in fields " + sorter.Field}
+		}
+	}
+	return cursorSorters, nil
+}
+
+func hasQueryField(fields *[]Field, fieldName string) bool {
+	if fields == nil {
+		return false
+	}
+	for _, field := range *fields {
+		if field.Field == fieldName {
+			return true
+		}
+	}
+	return false
+}
+
+// 游标中时间类型的值需要记录类型，解码后按照时间类型绑定参数，否则数据库会按照字符串比较
+const CURSOR_VALUE_TYPE_TIME = "time"
+
+type cursorTypedValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// 将最后一行数据中排序字段的值编码为游标，游标对调用方是不透明的字符串
+func EncodeCursor(values []interface{}) (string, error) {
+	encoded := make([]interface{}, len(values))
+	for index, value := range values {
+		if timeValue, ok := value.(time.Time); ok {
+			value = cursorTypedValue{Type: CURSOR_VALUE_TYPE_TIME, Value: timeValue.Format(time.RFC3339Nano)}
+		}
+		encoded[index] = value
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		slog.Error("EncodeCursor failed", "error", err)
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// 解码游标，游标中值的个数必须与排序字段的个数一致
+func DecodeCursor(cursor string, count int) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		slog.Error("DecodeCursor failed", "error", err)
+		return nil, &QueryValidationError{Kind: INVALID_KIND_CURSOR, Value: cursor}
+	}
+
+	//数值保持原来的类型，避免大整数转换为float64后丢失精度
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values []interface{}
+	if err := decoder.Decode(&values); err != nil || len(values) != count {
+		slog.Error("DecodeCursor invalid cursor", "cursor", cursor, "error", err)
+		return nil, &QueryValidationError{Kind: INVALID_KIND_CURSOR, Value: cursor}
+	}
+
+	for index, value := range values {
+		switch value := value.(type) {
+		case json.Number:
+			if iVal, err := value.Int64(); err == nil {
+				values[index] = iVal
+			} else if fVal, err := value.Float64(); err == nil {
+				values[index] = fVal
+			}
+		case map[string]interface{}:
+			timeValue, err := decodeCursorTime(value)
+			if err != nil {
+				slog.Error("DecodeCursor invalid cursor value", "cursor", cursor, "value", value, "error", err)
+				return nil, &QueryValidationError{Kind: INVALID_KIND_CURSOR, Value: cursor}
+			}
+			values[index] = timeValue
+		}
+	}
+	return values, nil
+}
+
+func decodeCursorTime(value map[string]interface{}) (time.Time, error) {
+	text, ok := value["value"].(string)
+	if value["type"] != CURSOR_VALUE_TYPE_TIME || !ok {
+		return time.Time{}, errors.New("unsupported cursor value")
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
+
+// 根据游标生成获取下一页数据的过滤条件
+// 空值按照数据库的排序规则处理，游标中的值为空时使用is null和is not null条件，空值排在后面时非空值的条件需要包含空值
+// 游标中没有空值，且可能为空的字段都是空值在前时使用(a,b) > (?,?)的形式，否则展开为a > ? or (a = ? and b > ?)的形式
+// id字段不会为空
+func GetCursorWhere(sorters []Sorter, values []interface{}, dialect Dialect) (string, []interface{}, error) {
+	dialect = getDialectOrDefault(dialect)
+	fields := make([]string, len(sorters))
+	operators := make([]string, len(sorters))
+	nullsAfter := make([]bool, len(sorters))
+	sameOrder := true
+	hasNullValue := false
+	for index, sorter := range sorters {
+		field, err := GetFieldExpression(sorter.Field, dialect)
+		if err != nil {
+			return "", nil, err
+		}
+		order, err := CheckSorterOrder(sorter.Order)
+		if err != nil {
+			return "", nil, err
+		}
+		fields[index] = field
+		operators[index] = ">"
+		if order == "desc" {
+			operators[index] = "<"
+		}
+		if operators[index] != operators[0] {
+			sameOrder = false
+		}
+		nullsAfter[index] = sorter.Field != CC_ID && !dialect.NullsFirst(order == "desc")
+		if nullsAfter[index] {
+			sameOrder = false
+		}
+		if values[index] == nil {
+			hasNullValue = true
+		}
+	}
+
+	if len(sorters) == 1 && !nullsAfter[0] && !hasNullValue {
+		return fields[0] + " " + operators[0] + " ?", values, nil
+	}
+
+	if sameOrder && !hasNullValue {
+		return "(" + strings.Join(fields, ",") + ") " + operators[0] + " (" + strings.TrimSuffix(strings.Repeat("?,", len(values)), ",") + ")", values, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	for index := range sorters {
+		var parts []string
+		var partArgs []interface{}
+		for prev := 0; prev < index; prev++ {
+			if values[prev] == nil {
+				parts = append(parts, fields[prev]+" is null")
+			} else {
+				parts = append(parts, fields[prev]+" = ?")
+				partArgs = append(partArgs, values[prev])
+			}
+		}
+		if values[index] == nil {
+			//空值排在后面时，游标之后没有当前字段更大的数据
+			if nullsAfter[index] || sorters[index].Field == CC_ID {
+				continue
+			}
+			parts = append(parts, fields[index]+" is not null")
+		} else {
+			part := fields[index] + " " + operators[index] + " ?"
+			if nullsAfter[index] {
+				part = part + " or " + fields[index] + " is null"
+				if index > 0 {
+					part = "(" + part + ")"
+				}
+			}
+			parts = append(parts, part)
+			partArgs = append(partArgs, values[index])
+		}
+		conditions = append(conditions, "("+strings.Join(parts, " and ")+")")
+		args = append(args, partArgs...)
+	}
+	if len(conditions) == 0 {
+		return "1=0", nil, nil
+	}
+	return strings.Join(conditions, " or "), args, nil
+}
+
+// 获取游标分页的每页条数
+func getCursorPageSize(pagination *Pagination) int {
+	if pagination == nil {
+		return DEFAULT_CURSOR_PAGE_SIZE
+	}
+	if pagination.PageSize < 0 {
+		slog.Error("getCursorPageSize pageSize must great than 0", "Pagination", pagination)
+		return 0
+	}
+	return pagination.PageSize
+}
+
+// 查询时多取一条数据用于判断是否还有下一页，有下一页时去掉多取的数据并根据当前页最后一行生成下一页的游标
+func getNextCursor(list []map[string]interface{}, sorters []Sorter, pageSize int) ([]map[string]interface{}, *string, error) {
+	if len(list) <= pageSize || pageSize <= 0 {
+		return list, nil, nil
+	}
+
+	list = list[:pageSize]
+	lastRow := list[pageSize-1]
+	values := make([]interface{}, len(sorters))
+	for index, sorter := range sorters {
+		values[index] = lastRow[sorter.Field]
+	}
+	cursor, err := EncodeCursor(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, &cursor, nil
+}
@@ -0,0 +1,343 @@
+package crvorm
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	DIALECT_MYSQL    = "mysql"
+	DIALECT_POSTGRES = "postgres"
+	DIALECT_SQLITE   = "sqlite"
+)
+
+// 不同数据库在标识符引号、分页、排序、参数占位符等方面的差异
+// 生成的SQL语句统一使用?作为参数占位符，执行前由Rebind转换为数据库使用的占位符
+type Dialect interface {
+	Name() string
+	// database/sql中注册的驱动名称，postgres和sqlite需要调用方自行引入对应的驱动
+	DriverName() string
+	QuoteIdentifier(identifier string) string
+	// 获取带库名的表名，appDb为空时仅返回表名
+	GetTableName(appDb string, modelId string) string
+	// 获取分页语句
+	Limit(offset int, count int) string
+	// 按照给定值的顺序排序的表达式，值按照顺序作为参数传入，不在给定值中的记录排在最前面
+	FieldOrder(field string, count int) string
+	Rebind(sql string) string
+	// 是否支持通过sql.Result.LastInsertId获取新建数据的ID，不支持时新建语句通过ReturningId获取ID
+	SupportsLastInsertId() bool
+	ReturningId() string
+	// 按照时间粒度截取日期字段，结果为可排序的字符串，如按月分组时为2024-01
+	DatePeriod(field string, period string) string
+	// 正则表达式匹配的操作符，not为true时返回不匹配的操作符
+	RegexpOperator(not bool) string
+	// 全文检索表达式和参数，字段已经加了引号，tokens为去掉了操作符的检索词，每个词都需要按照前缀匹配
+	// 不支持全文检索时返回空字符串
+	FullTextMatch(fields []string, tokens []string) (string, []interface{})
+	// 获取JSON字段中路径对应的值，字段已经加了引号，路径为检查过的$.a[0]格式
+	// text为true时返回文本值，用于过滤和排序，否则返回JSON值的文本表示，查询结果中统一解码
+	JSONExtract(field string, path string, text bool) string
+	// 排序时空值是否排在非空值之前，desc为true时为降序排序
+	NullsFirst(desc bool) bool
+}
+
+// 分组查询时日期字段支持的时间粒度
+const (
+	DATE_PERIOD_YEAR  = "year"
+	DATE_PERIOD_MONTH = "month"
+	DATE_PERIOD_DAY   = "day"
+)
+
+// 默认使用mysql
+var DefaultDialect Dialect = &MySQLDialect{}
+
+// 根据名称获取Dialect，名称为空时返回默认的Dialect，不支持的名称返回nil
+func GetDialect(name string) Dialect {
+	switch strings.ToLower(name) {
+	case "":
+		return DefaultDialect
+	case DIALECT_MYSQL:
+		return &MySQLDialect{}
+	case DIALECT_POSTGRES, "postgresql", "pgsql":
+		return &PostgresDialect{}
+	case DIALECT_SQLITE, "sqlite3":
+		return &SQLiteDialect{}
+	}
+	return nil
+}
+
+func getDialectOrDefault(dialect Dialect) Dialect {
+	if dialect == nil {
+		return DefaultDialect
+	}
+	return dialect
+}
+
+type MySQLDialect struct{}
+
+func (dialect *MySQLDialect) Name() string {
+	return DIALECT_MYSQL
+}
+
+func (dialect *MySQLDialect) DriverName() string {
+	return "mysql"
+}
+
+func (dialect *MySQLDialect) QuoteIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func (dialect *MySQLDialect) GetTableName(appDb string, modelId string) string {
+	return getQualifiedTableName(dialect, appDb, modelId)
+}
+
+func (dialect *MySQLDialect) Limit(offset int, count int) string {
+	return "limit " + strconv.Itoa(offset) + "," + strconv.Itoa(count)
+}
+
+func (dialect *MySQLDialect) FieldOrder(field string, count int) string {
+	return "FIELD(" + field + strings.Repeat(",?", count) + ")"
+}
+
+func (dialect *MySQLDialect) Rebind(sql string) string {
+	return sql
+}
+
+func (dialect *MySQLDialect) SupportsLastInsertId() bool {
+	return true
+}
+
+func (dialect *MySQLDialect) ReturningId() string {
+	return ""
+}
+
+func (dialect *MySQLDialect) RegexpOperator(not bool) string {
+	return getRegexpOperator(not)
+}
+
+func (dialect *MySQLDialect) DatePeriod(field string, period string) string {
+	return "date_format(" + field + ",'" + getDatePeriodFormat(period, "%Y", "%m", "%d") + "')"
+}
+
+// 布尔模式的全文检索，需要在这些字段上建立FULLTEXT索引
+func (dialect *MySQLDialect) FullTextMatch(fields []string, tokens []string) (string, []interface{}) {
+	return "match (" + strings.Join(fields, ",") + ") against (? in boolean mode)", []interface{}{getBooleanModeQuery(tokens)}
+}
+
+func (dialect *MySQLDialect) JSONExtract(field string, path string, text bool) string {
+	if text {
+		return field + "->>'" + path + "'"
+	}
+	return "cast(json_extract(" + field + ",'" + path + "') as char)"
+}
+
+func (dialect *MySQLDialect) NullsFirst(desc bool) bool {
+	return !desc
+}
+
+// postgres中appDb对应schema
+type PostgresDialect struct{}
+
+func (dialect *PostgresDialect) Name() string {
+	return DIALECT_POSTGRES
+}
+
+func (dialect *PostgresDialect) DriverName() string {
+	return "postgres"
+}
+
+func (dialect *PostgresDialect) QuoteIdentifier(identifier string) string {
+	return quoteIdentifierWithDoubleQuote(identifier)
+}
+
+func (dialect *PostgresDialect) GetTableName(appDb string, modelId string) string {
+	return getQualifiedTableName(dialect, appDb, modelId)
+}
+
+func (dialect *PostgresDialect) Limit(offset int, count int) string {
+	return getLimitWithOffset(offset, count)
+}
+
+func (dialect *PostgresDialect) FieldOrder(field string, count int) string {
+	return getCaseFieldOrder(field, count)
+}
+
+// 将?占位符转换为$1,$2...，引号中的内容不做转换
+func (dialect *PostgresDialect) Rebind(sql string) string {
+	var builder strings.Builder
+	builder.Grow(len(sql) + 8)
+	index := 0
+	var quote byte = 0
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		if quote != 0 {
+			if ch == quote {
+				quote = 0
+			}
+			builder.WriteByte(ch)
+			continue
+		}
+
+		switch ch {
+		case '\'', '"':
+			quote = ch
+			builder.WriteByte(ch)
+		case '?':
+			index++
+			builder.WriteString("$" + strconv.Itoa(index))
+		default:
+			builder.WriteByte(ch)
+		}
+	}
+	return builder.String()
+}
+
+func (dialect *PostgresDialect) SupportsLastInsertId() bool {
+	return false
+}
+
+func (dialect *PostgresDialect) ReturningId() string {
+	return " returning " + dialect.QuoteIdentifier(CC_ID)
+}
+
+func (dialect *PostgresDialect) RegexpOperator(not bool) string {
+	if not {
+		return " !~ "
+	}
+	return " ~ "
+}
+
+func (dialect *PostgresDialect) DatePeriod(field string, period string) string {
+	return "to_char(" + field + ",'" + getDatePeriodFormat(period, "YYYY", "MM", "DD") + "')"
+}
+
+// 使用simple配置的全文检索，不区分大小写，需要在相同的to_tsvector表达式上建立GIN索引
+func (dialect *PostgresDialect) FullTextMatch(fields []string, tokens []string) (string, []interface{}) {
+	documents := make([]string, len(fields))
+	for index, field := range fields {
+		documents[index] = "coalesce(" + field + "::text,'')"
+	}
+	return "to_tsvector('simple'," + strings.Join(documents, " || ' ' || ") + ") @@ to_tsquery('simple',?)", []interface{}{getTSQuery(tokens)}
+}
+
+// JSON字段需要使用jsonb类型
+func (dialect *PostgresDialect) JSONExtract(field string, path string, text bool) string {
+	if text {
+		return field + " #>> '" + getPostgresJSONPath(path) + "'"
+	}
+	return "(" + field + " #> '" + getPostgresJSONPath(path) + "')::text"
+}
+
+// postgres中空值按照最大值排序
+func (dialect *PostgresDialect) NullsFirst(desc bool) bool {
+	return desc
+}
+
+// sqlite中appDb对应attach的数据库名称，默认数据库为main
+type SQLiteDialect struct{}
+
+func (dialect *SQLiteDialect) Name() string {
+	return DIALECT_SQLITE
+}
+
+func (dialect *SQLiteDialect) DriverName() string {
+	return "sqlite3"
+}
+
+func (dialect *SQLiteDialect) QuoteIdentifier(identifier string) string {
+	return quoteIdentifierWithDoubleQuote(identifier)
+}
+
+func (dialect *SQLiteDialect) GetTableName(appDb string, modelId string) string {
+	return getQualifiedTableName(dialect, appDb, modelId)
+}
+
+func (dialect *SQLiteDialect) Limit(offset int, count int) string {
+	return getLimitWithOffset(offset, count)
+}
+
+func (dialect *SQLiteDialect) FieldOrder(field string, count int) string {
+	return getCaseFieldOrder(field, count)
+}
+
+func (dialect *SQLiteDialect) Rebind(sql string) string {
+	return sql
+}
+
+func (dialect *SQLiteDialect) SupportsLastInsertId() bool {
+	return true
+}
+
+func (dialect *SQLiteDialect) ReturningId() string {
+	return ""
+}
+
+// sqlite的regexp操作符需要调用方注册regexp函数
+func (dialect *SQLiteDialect) RegexpOperator(not bool) string {
+	return getRegexpOperator(not)
+}
+
+func (dialect *SQLiteDialect) DatePeriod(field string, period string) string {
+	return "strftime('" + getDatePeriodFormat(period, "%Y", "%m", "%d") + "'," + field + ")"
+}
+
+func (dialect *SQLiteDialect) FullTextMatch(fields []string, tokens []string) (string, []interface{}) {
+	return "", nil
+}
+
+// ->和->>操作符需要sqlite 3.38.0及以上版本
+func (dialect *SQLiteDialect) JSONExtract(field string, path string, text bool) string {
+	if text {
+		return field + " ->> '" + path + "'"
+	}
+	return field + " -> '" + path + "'"
+}
+
+func (dialect *SQLiteDialect) NullsFirst(desc bool) bool {
+	return !desc
+}
+
+func getRegexpOperator(not bool) string {
+	if not {
+		return " not regexp "
+	}
+	return " regexp "
+}
+
+// 根据时间粒度拼接日期格式，时间粒度在生成查询参数时已经做过检查
+func getDatePeriodFormat(period string, year string, month string, day string) string {
+	switch period {
+	case DATE_PERIOD_YEAR:
+		return year
+	case DATE_PERIOD_MONTH:
+		return year + "-" + month
+	}
+	return year + "-" + month + "-" + day
+}
+
+func quoteIdentifierWithDoubleQuote(identifier string) string {
+	return "\"" + strings.ReplaceAll(identifier, "\"", "\"\"") + "\""
+}
+
+func getQualifiedTableName(dialect Dialect, appDb string, modelId string) string {
+	if len(appDb) == 0 {
+		return dialect.QuoteIdentifier(modelId)
+	}
+	return dialect.QuoteIdentifier(appDb) + "." + dialect.QuoteIdentifier(modelId)
+}
+
+func getLimitWithOffset(offset int, count int) string {
+	return "limit " + strconv.Itoa(count) + " offset " + strconv.Itoa(offset)
+}
+
+// 与mysql的FIELD函数保持一致，不在给定值中的记录返回0，其它记录返回值所在的位置
+func getCaseFieldOrder(field string, count int) string {
+	var builder strings.Builder
+	builder.WriteString("case " + field)
+	for i := 1; i <= count; i++ {
+		builder.WriteString(" when ? then " + strconv.Itoa(i))
+	}
+	builder.WriteString(" else 0 end")
+	return builder.String()
+}
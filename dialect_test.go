@@ -0,0 +1,113 @@
+package crvorm
+
+import (
+	"testing"
+)
+
+func TestDialectDataSQL(t *testing.T) {
+	values := []string{"b", "a"}
+	query := &QueryParam{
+		AppDb:   "app",
+		ModelId: "user",
+		Fields:  fields,
+		Filter: &map[string]interface{}{
+			"name": map[string]interface{}{
+				"Op.eq": "x",
+			},
+		},
+		Sorter: &[]Sorter{
+			{Field: "name", Order: "desc", Values: &values},
+		},
+		Pagination: &Pagination{
+			Current:  3,
+			PageSize: 10,
+		},
+	}
+
+	tests := []struct {
+		dialect Dialect
+		sql     string
+	}{
+		{
+			dialect: &MySQLDialect{},
+			sql:     "select `id`,`name` from `app`.`user` where `name` = ? order by FIELD(`name`,?,?) desc limit 20,10",
+		},
+		{
+			dialect: &PostgresDialect{},
+			sql:     `select "id","name" from "app"."user" where "name" = ? order by case "name" when ? then 1 when ? then 2 else 0 end desc limit 10 offset 20`,
+		},
+		{
+			dialect: &SQLiteDialect{},
+			sql:     `select "id","name" from "app"."user" where "name" = ? order by case "name" when ? then 1 when ? then 2 else 0 end desc limit 10 offset 20`,
+		},
+	}
+
+	for _, test := range tests {
+		sqlParam, err := QueryToSQLPARAMWithDialect(query, test.dialect)
+		if err != nil {
+			t.Fatalf("%s: QueryToSQLPARAMWithDialect failed: %v", test.dialect.Name(), err)
+		}
+
+		sql, args := SQLParamToDataSQL(sqlParam)
+		if sql != test.sql {
+			t.Errorf("%s: unexpected sql: %s", test.dialect.Name(), sql)
+		}
+		if len(args) != 3 {
+			t.Errorf("%s: unexpected args: %v", test.dialect.Name(), args)
+		}
+	}
+}
+
+func TestPostgresRebind(t *testing.T) {
+	dialect := &PostgresDialect{}
+	sql := dialect.Rebind(`select "a?" from t where a = ? and b = '?''?' and c in (?,?)`)
+	expected := `select "a?" from t where a = $1 and b = '?''?' and c in ($2,$3)`
+	if sql != expected {
+		t.Errorf("unexpected sql: %s", sql)
+	}
+}
+
+func TestGetDialect(t *testing.T) {
+	if GetDialect("").Name() != DIALECT_MYSQL {
+		t.Errorf("default dialect should be mysql")
+	}
+	if GetDialect("postgresql").Name() != DIALECT_POSTGRES {
+		t.Errorf("postgresql should be postgres dialect")
+	}
+	if GetDialect("sqlite3").Name() != DIALECT_SQLITE {
+		t.Errorf("sqlite3 should be sqlite dialect")
+	}
+	if GetDialect("oracle") != nil {
+		t.Errorf("oracle should not be supported")
+	}
+}
+
+func TestGroupDataSQL(t *testing.T) {
+	query := &QueryParam{
+		AppDb:      "app",
+		ModelId:    "order",
+		GroupBy:    &[]GroupField{{Field: "create_time", Period: DATE_PERIOD_MONTH}},
+		Aggregates: &[]Aggregate{{Field: "total", Summarize: "sum(amount)"}},
+		Having:     &map[string]interface{}{"total": map[string]interface{}{Op_gt: 10}},
+	}
+
+	expected := map[string]string{
+		DIALECT_MYSQL:    "select date_format(`create_time`,'%Y-%m') as `create_time`,sum(`amount`) as `total` from `app`.`order` where 1=1 group by date_format(`create_time`,'%Y-%m') having sum(`amount`) > ? order by `create_time`  limit 0,1000",
+		DIALECT_POSTGRES: `select to_char("create_time",'YYYY-MM') as "create_time",sum("amount") as "total" from "app"."order" where 1=1 group by to_char("create_time",'YYYY-MM') having sum("amount") > ? order by "create_time"  limit 1000 offset 0`,
+	}
+	for name, sql := range expected {
+		sqlParam, err := QueryToSQLPARAMWithDialect(query, GetDialect(name))
+		if err != nil {
+			t.Fatalf("%s: QueryToSQLPARAM failed: %v", name, err)
+		}
+		dataSQL, args := SQLParamToDataSQL(sqlParam)
+		if dataSQL != sql || len(args) != 1 {
+			t.Errorf("%s: unexpected sql %q %v", name, dataSQL, args)
+		}
+	}
+
+	query.GroupBy = &[]GroupField{{Field: "create_time", Period: "week"}}
+	if _, err := QueryToSQLPARAM(query); err == nil {
+		t.Errorf("unsupported period should fail")
+	}
+}
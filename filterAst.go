@@ -0,0 +1,600 @@
+package crvorm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 过滤条件的语法树，由Op.*格式的过滤条件解析得到，可以检查和改写后再生成SQL或者转换回Op.*格式
+type FilterNode interface {
+	filterNode()
+}
+
+// 多个条件同时满足，没有子条件时表示不过滤
+type AndNode struct {
+	Children []FilterNode
+}
+
+// 任意一个条件满足
+type OrNode struct {
+	Children []FilterNode
+}
+
+// 条件不满足
+type NotNode struct {
+	Child FilterNode
+}
+
+// 字段上的比较条件，Op为Op.eq、Op.gt、Op.between、Op.like等单个字段上的操作符
+// Value的类型与Op.*格式中操作符的值相同
+type CompareNode struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// 字段值在列表或子查询中，SubQuery不为nil时使用子查询，Not为true时表示不在列表中
+type InNode struct {
+	Field    string
+	Not      bool
+	Values   []interface{}
+	SubQuery *SubQuery
+}
+
+// 字段值为null，Not为true时表示字段值不为null
+type NullNode struct {
+	Field string
+	Not   bool
+}
+
+// 关联字段对应的关联数据中存在满足Filter的数据，Filter中的字段是关联模型上的字段
+type ExistsNode struct {
+	Field  string
+	Filter FilterNode
+}
+
+// 全文检索条件，检索内容中的每个词都需要在任意一个字段中出现
+type MatchNode struct {
+	Fields []string
+	Query  string
+}
+
+func (node *AndNode) filterNode()     {}
+func (node *OrNode) filterNode()      {}
+func (node *NotNode) filterNode()     {}
+func (node *CompareNode) filterNode() {}
+func (node *InNode) filterNode()      {}
+func (node *NullNode) filterNode()    {}
+func (node *ExistsNode) filterNode()  {}
+func (node *MatchNode) filterNode()   {}
+
+// 解析过滤条件失败时返回的错误
+// Path为出错位置的JSON Pointer，如/Op.and/1/name/Op.between，Offset为JSON文本中语法错误的位置，没有时为-1
+// Err为导致解析失败的错误，如字段名不合法时的QueryValidationError，没有时为nil
+type FilterParseError struct {
+	Path    string
+	Offset  int64
+	Message string
+	Err     error
+}
+
+func (e *FilterParseError) Error() string {
+	if e.Offset >= 0 {
+		return "invalid filter json at offset " + strconv.FormatInt(e.Offset, 10) + ": " + e.Message
+	}
+	return "invalid filter at " + e.Path + ": " + e.Message
+}
+
+func (e *FilterParseError) Unwrap() error {
+	return e.Err
+}
+
+func newFilterParseError(path string, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	slog.Error("parse filter failed", "path", path, "message", message)
+	return &FilterParseError{Path: path, Offset: -1, Message: message}
+}
+
+func getFilterPath(path string, key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	key = strings.ReplaceAll(key, "/", "~1")
+	return path + "/" + key
+}
+
+// 对象的键按照字典序处理，保证相同的过滤条件解析得到相同的语法树
+func getSortedKeys(value map[string]interface{}) []string {
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 解析Op.*格式的过滤条件，filter为nil时返回没有子条件的AndNode
+func ParseFilter(filter *map[string]interface{}) (FilterNode, error) {
+	if filter == nil {
+		return &AndNode{}, nil
+	}
+	return parseFilterObject(*filter, "")
+}
+
+// 解析JSON格式的过滤条件
+func ParseFilterJSON(data []byte) (FilterNode, error) {
+	var filter map[string]interface{}
+	if err := json.Unmarshal(data, &filter); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, &FilterParseError{Path: "", Offset: syntaxErr.Offset, Message: syntaxErr.Error()}
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, &FilterParseError{Path: "/" + strings.ReplaceAll(typeErr.Field, ".", "/"), Offset: typeErr.Offset, Message: typeErr.Error()}
+		}
+		return nil, &FilterParseError{Path: "", Offset: 0, Message: err.Error()}
+	}
+	return ParseFilter(&filter)
+}
+
+// 解析作为过滤条件的值，值必须是Op.*格式的对象
+func ParseFilterValue(value interface{}) (FilterNode, error) {
+	return parseFilterValue(value, "")
+}
+
+func parseFilterValue(value interface{}, path string) (FilterNode, error) {
+	switch value.(type) {
+	case map[string]interface{}:
+		return parseFilterObject(value.(map[string]interface{}), path)
+	case *map[string]interface{}:
+		return parseFilterObject(*value.(*map[string]interface{}), path)
+	}
+	return nil, newFilterParseError(path, "filter must be an object, got %v", reflect.TypeOf(value))
+}
+
+func getNodeOrAnd(nodes []FilterNode) FilterNode {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &AndNode{Children: nodes}
+}
+
+func parseFilterObject(filter map[string]interface{}, path string) (FilterNode, error) {
+	nodes := []FilterNode{}
+	for _, key := range getSortedKeys(filter) {
+		value := filter[key]
+		keyPath := getFilterPath(path, key)
+		var node FilterNode
+		var err error
+		switch key {
+		case Op_and, Op_or:
+			var children []FilterNode
+			children, err = parseFilterArray(value, keyPath, func(item interface{}, itemPath string) (FilterNode, error) {
+				return parseFilterValue(item, itemPath)
+			})
+			if key == Op_and {
+				node = &AndNode{Children: children}
+			} else {
+				node = &OrNode{Children: children}
+			}
+		case Op_not:
+			var child FilterNode
+			child, err = parseFilterValue(value, keyPath)
+			node = &NotNode{Child: child}
+		case Op_match, Op_search:
+			node, err = parseMatchFilter(value, keyPath)
+		default:
+			if !IsJSONPathField(key) && strings.Contains(key, ".") {
+				node, err = parseRelationPathFilter(key, value, keyPath)
+			} else {
+				node, err = parseFieldFilter(key, value, keyPath)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return getNodeOrAnd(nodes), nil
+}
+
+// 关联字段路径转换为逐层嵌套的关联模型上的过滤条件，如a.b.c转换为a上存在b上存在c满足条件的数据
+func parseRelationPathFilter(key string, value interface{}, path string) (FilterNode, error) {
+	segments := strings.Split(key, ".")
+	for _, segment := range segments[:len(segments)-1] {
+		if err := checkFilterField(segment, path); err != nil {
+			return nil, err
+		}
+	}
+
+	node, err := parseFieldFilter(segments[len(segments)-1], value, path)
+	if err != nil {
+		return nil, err
+	}
+	for index := len(segments) - 2; index >= 0; index-- {
+		node = &ExistsNode{Field: segments[index], Filter: node}
+	}
+	return node, nil
+}
+
+func parseFilterArray(value interface{}, path string, parseItem func(item interface{}, itemPath string) (FilterNode, error)) ([]FilterNode, error) {
+	var items []interface{}
+	switch value.(type) {
+	case []interface{}:
+		items = value.([]interface{})
+	case []map[string]interface{}:
+		for _, item := range value.([]map[string]interface{}) {
+			items = append(items, item)
+		}
+	default:
+		return nil, newFilterParseError(path, "value must be an array, got %v", reflect.TypeOf(value))
+	}
+
+	if len(items) == 0 {
+		return nil, newFilterParseError(path, "array must not be empty")
+	}
+
+	nodes := make([]FilterNode, len(items))
+	for index, item := range items {
+		node, err := parseItem(item, path+"/"+strconv.Itoa(index))
+		if err != nil {
+			return nil, err
+		}
+		nodes[index] = node
+	}
+	return nodes, nil
+}
+
+func checkFilterField(field string, path string) error {
+	if err := CheckFieldIdentifier(field); err != nil {
+		parseErr := newFilterParseError(path, "invalid field %s", field).(*FilterParseError)
+		parseErr.Err = err
+		return parseErr
+	}
+	return nil
+}
+
+func isFilterScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, float64, int, int64:
+		return true
+	}
+	return false
+}
+
+func parseFilterValues(value interface{}, path string) ([]interface{}, error) {
+	var values []interface{}
+	switch value.(type) {
+	case []interface{}:
+		values = value.([]interface{})
+	case []string:
+		for _, sVal := range value.([]string) {
+			values = append(values, sVal)
+		}
+	default:
+		return nil, newFilterParseError(path, "value must be an array, got %v", reflect.TypeOf(value))
+	}
+
+	for index, val := range values {
+		if !isFilterScalar(val) {
+			return nil, newFilterParseError(path+"/"+strconv.Itoa(index), "value must be a string or number, got %v", reflect.TypeOf(val))
+		}
+	}
+	return values, nil
+}
+
+// 字段上的过滤条件，与FilterConverter的处理方式一致
+// 字符串相当于Op.like，数值和布尔值相当于Op.eq，数组相当于Op.in，null相当于Op.is
+func parseFieldFilter(field string, value interface{}, path string) (FilterNode, error) {
+	if err := checkFilterField(field, path); err != nil {
+		return nil, err
+	}
+
+	switch value.(type) {
+	case string:
+		return &CompareNode{Field: field, Op: Op_like, Value: value}, nil
+	case float64, int, int64, bool:
+		return &CompareNode{Field: field, Op: Op_eq, Value: value}, nil
+	case nil:
+		return &NullNode{Field: field}, nil
+	case []interface{}, []string:
+		values, err := parseFilterValues(value, path)
+		if err != nil {
+			return nil, err
+		}
+		return &InNode{Field: field, Values: values}, nil
+	case map[string]interface{}:
+		operators := value.(map[string]interface{})
+		if len(operators) == 0 {
+			return nil, newFilterParseError(path, "operator object must not be empty")
+		}
+		nodes := []FilterNode{}
+		for _, op := range getSortedKeys(operators) {
+			node, err := parseFieldOperator(field, op, operators[op], getFilterPath(path, op))
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+		return getNodeOrAnd(nodes), nil
+	}
+	return nil, newFilterParseError(path, "not supported field filter value type %v", reflect.TypeOf(value))
+}
+
+func parseFieldOperator(field string, op string, value interface{}, path string) (FilterNode, error) {
+	switch op {
+	case Op_and, Op_or:
+		children, err := parseFilterArray(value, path, func(item interface{}, itemPath string) (FilterNode, error) {
+			return parseFieldFilter(field, item, itemPath)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if op == Op_and {
+			return &AndNode{Children: children}, nil
+		}
+		return &OrNode{Children: children}, nil
+	case Op_exists:
+		child, err := parseFilterValue(value, path)
+		if err != nil {
+			return nil, err
+		}
+		return &ExistsNode{Field: field, Filter: child}, nil
+	case Op_in, Op_notIn:
+		node := &InNode{Field: field, Not: op == Op_notIn}
+		switch value.(type) {
+		case *SubQuery:
+			node.SubQuery = value.(*SubQuery)
+		case string:
+			node.Values = []interface{}{value}
+		default:
+			values, err := parseFilterValues(value, path)
+			if err != nil {
+				return nil, err
+			}
+			node.Values = values
+		}
+		return node, nil
+	case Op_is, Op_not:
+		switch value.(type) {
+		case nil:
+			return &NullNode{Field: field, Not: op == Op_not}, nil
+		case bool:
+			return &CompareNode{Field: field, Op: op, Value: value}, nil
+		}
+		return nil, newFilterParseError(path, "value must be null or boolean, got %v", reflect.TypeOf(value))
+	case Op_eq, Op_ne, Op_gt, Op_gte, Op_lt, Op_lte:
+		if value == nil && (op == Op_eq || op == Op_ne) {
+			return &NullNode{Field: field, Not: op == Op_ne}, nil
+		}
+		if err := checkCompareOperand(value, path); err != nil {
+			return nil, err
+		}
+	case Op_between, Op_notBetween:
+		values, err := parseFilterValues(value, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != 2 {
+			return nil, newFilterParseError(path, "value must have 2 items, got %d", len(values))
+		}
+		value = values
+	case Op_like, Op_notLike, Op_startsWith, Op_endsWith, Op_substring, Op_regexp, Op_notRegexp, Op_col, Op_match, Op_search:
+		if _, ok := value.(string); !ok {
+			return nil, newFilterParseError(path, "value must be a string, got %v", reflect.TypeOf(value))
+		}
+	case Op_all:
+		if err := checkAllOperand(value, path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, newFilterParseError(path, "not supported operator %s", op)
+	}
+	return &CompareNode{Field: field, Op: op, Value: value}, nil
+}
+
+// 比较操作符的值可以是字符串、数值、布尔值，或者{Op.col:字段}、{Op.all:子查询或数组}
+func checkCompareOperand(value interface{}, path string) error {
+	switch value.(type) {
+	case string, float64, int, int64, bool:
+		return nil
+	case map[string]interface{}:
+		mVal := value.(map[string]interface{})
+		if len(mVal) == 1 {
+			if col, ok := mVal[Op_col]; ok {
+				if _, ok := col.(string); ok {
+					return nil
+				}
+				return newFilterParseError(getFilterPath(path, Op_col), "value must be a string, got %v", reflect.TypeOf(col))
+			}
+			if all, ok := mVal[Op_all]; ok {
+				return checkAllOperand(all, getFilterPath(path, Op_all))
+			}
+		}
+	}
+	return newFilterParseError(path, "not supported operand type %v", reflect.TypeOf(value))
+}
+
+// Op.all的值可以是子查询，JSON格式的过滤条件中使用字符串、数值或布尔值的数组
+func checkAllOperand(value interface{}, path string) error {
+	switch value.(type) {
+	case *SubQuery:
+		return nil
+	case []interface{}:
+		for index, item := range value.([]interface{}) {
+			switch item.(type) {
+			case string, float64, int, int64, bool:
+			default:
+				return newFilterParseError(getFilterPath(path, strconv.Itoa(index)), "not supported operand type %v", reflect.TypeOf(item))
+			}
+		}
+		return nil
+	}
+	return newFilterParseError(path, "value must be a sub query or an array, got %v", reflect.TypeOf(value))
+}
+
+// 将过滤条件语法树转换为SQL的where语句，字段上的条件与FilterToSQLWhere使用相同的转换方式
+func (fc *FilterConverter) CompileFilter(node FilterNode) (string, []interface{}, error) {
+	switch node.(type) {
+	case nil:
+		return "1=1", nil, nil
+	case *AndNode:
+		return fc.compileFilterList(node.(*AndNode).Children, " and ", "1=1")
+	case *OrNode:
+		return fc.compileFilterList(node.(*OrNode).Children, " or ", "1=0")
+	case *NotNode:
+		str, args, err := fc.CompileFilter(node.(*NotNode).Child)
+		if err != nil {
+			return "", nil, err
+		}
+		return "not (" + str + ")", args, nil
+	case *CompareNode:
+		compare := node.(*CompareNode)
+		if err := CheckFieldIdentifier(compare.Field); err != nil {
+			return "", nil, err
+		}
+		return fc.convertFieldOperator(compare.Op, compare.Field, compare.Value)
+	case *InNode:
+		return fc.compileInNode(node.(*InNode))
+	case *NullNode:
+		null := node.(*NullNode)
+		if err := CheckFieldIdentifier(null.Field); err != nil {
+			return "", nil, err
+		}
+		if null.Not {
+			return fc.convertFieldValueNull(" is not ", null.Field), nil, nil
+		}
+		return fc.convertFieldValueNull(" is ", null.Field), nil, nil
+	case *ExistsNode:
+		exists := node.(*ExistsNode)
+		if fc.RelationPathConvert == nil {
+			slog.Error("CompileFilter RelationPathConvert is nil", "field", exists.Field)
+			return "", nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: exists.Field}
+		}
+		localField, subQuery, err := fc.RelationPathConvert.ConvertRelationFilter(exists.Field, exists.Filter)
+		if err != nil {
+			return "", nil, err
+		}
+		str, args := fc.convertOpInSubQuery(" in ", localField, subQuery)
+		return str, args, nil
+	case *MatchNode:
+		match := node.(*MatchNode)
+		return fc.convertMatch(match.Fields, match.Query)
+	}
+	slog.Error("CompileFilter not supported filter node", "type", reflect.TypeOf(node))
+	return "", nil, fmt.Errorf("not supported filter node %v", reflect.TypeOf(node))
+}
+
+func (fc *FilterConverter) compileFilterList(children []FilterNode, logicOp string, empty string) (string, []interface{}, error) {
+	if len(children) == 0 {
+		return empty, nil, nil
+	}
+	if len(children) == 1 {
+		return fc.CompileFilter(children[0])
+	}
+
+	parts := make([]string, len(children))
+	var args []interface{}
+	for index, child := range children {
+		str, strArgs, err := fc.CompileFilter(child)
+		if err != nil {
+			return "", nil, err
+		}
+		parts[index] = "(" + str + ")"
+		args = append(args, strArgs...)
+	}
+	return strings.Join(parts, logicOp), args, nil
+}
+
+func (fc *FilterConverter) compileInNode(node *InNode) (string, []interface{}, error) {
+	if err := CheckFieldIdentifier(node.Field); err != nil {
+		return "", nil, err
+	}
+
+	op := " in "
+	if node.Not {
+		op = " not in "
+	}
+	if node.SubQuery != nil {
+		return fc.convertFieldOpIn(op, node.Field, node.SubQuery)
+	}
+	//空列表不能生成合法的in语句，直接转换为恒假或恒真的条件
+	if len(node.Values) == 0 {
+		if node.Not {
+			return "1=1", nil, nil
+		}
+		return "1=0", nil, nil
+	}
+	return fc.convertFieldOpIn(op, node.Field, node.Values)
+}
+
+// 将过滤条件语法树转换回Op.*格式，转换结果再次解析可以得到相同的语法树
+// 子查询无法通过JSON表示，包含子查询的过滤条件只能在程序中使用
+func FilterToMap(node FilterNode) map[string]interface{} {
+	switch node.(type) {
+	case *AndNode:
+		//没有子条件时转换为空对象，空对象解析后同样是没有子条件的AndNode
+		if len(node.(*AndNode).Children) == 0 {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{Op_and: filterListToMaps(node.(*AndNode).Children)}
+	case *OrNode:
+		return map[string]interface{}{Op_or: filterListToMaps(node.(*OrNode).Children)}
+	case *NotNode:
+		return map[string]interface{}{Op_not: FilterToMap(node.(*NotNode).Child)}
+	case *CompareNode:
+		compare := node.(*CompareNode)
+		return map[string]interface{}{compare.Field: map[string]interface{}{compare.Op: compare.Value}}
+	case *InNode:
+		in := node.(*InNode)
+		op := Op_in
+		if in.Not {
+			op = Op_notIn
+		}
+		var value interface{} = in.SubQuery
+		if in.SubQuery == nil {
+			values := in.Values
+			if values == nil {
+				values = []interface{}{}
+			}
+			value = values
+		}
+		return map[string]interface{}{in.Field: map[string]interface{}{op: value}}
+	case *NullNode:
+		null := node.(*NullNode)
+		op := Op_is
+		if null.Not {
+			op = Op_not
+		}
+		return map[string]interface{}{null.Field: map[string]interface{}{op: nil}}
+	case *ExistsNode:
+		exists := node.(*ExistsNode)
+		return map[string]interface{}{exists.Field: map[string]interface{}{Op_exists: FilterToMap(exists.Filter)}}
+	case *MatchNode:
+		match := node.(*MatchNode)
+		fields := make([]interface{}, len(match.Fields))
+		for index, field := range match.Fields {
+			fields[index] = field
+		}
+		return map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: match.Query, MATCH_FIELDS: fields}}
+	}
+	return map[string]interface{}{}
+}
+
+func filterListToMaps(children []FilterNode) []interface{} {
+	list := make([]interface{}, len(children))
+	for index, child := range children {
+		list[index] = FilterToMap(child)
+	}
+	return list
+}
+
+// 将过滤条件语法树转换为JSON格式的Op.*过滤条件
+func MarshalFilterJSON(node FilterNode) ([]byte, error) {
+	return json.Marshal(FilterToMap(node))
+}
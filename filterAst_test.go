@@ -0,0 +1,115 @@
+package crvorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseAndCompileFilter(t *testing.T) {
+	data := []byte(`{
+		"status": ["a", "b"],
+		"Op.or": [
+			{"amount": {"Op.between": [1, 10]}},
+			{"name": {"Op.startsWith": "x_", "Op.ne": null}}
+		],
+		"Op.not": {"deleted": {"Op.is": true}},
+		"customer.region.name": {"Op.eq": "east"}
+	}`)
+
+	node, err := ParseFilterJSON(data)
+	if err != nil {
+		t.Fatalf("ParseFilterJSON failed: %v", err)
+	}
+
+	expected := &AndNode{Children: []FilterNode{
+		&NotNode{Child: &CompareNode{Field: "deleted", Op: Op_is, Value: true}},
+		&OrNode{Children: []FilterNode{
+			&CompareNode{Field: "amount", Op: Op_between, Value: []interface{}{float64(1), float64(10)}},
+			&AndNode{Children: []FilterNode{
+				&NullNode{Field: "name", Not: true},
+				&CompareNode{Field: "name", Op: Op_startsWith, Value: "x_"},
+			}},
+		}},
+		&ExistsNode{Field: "customer", Filter: &ExistsNode{Field: "region", Filter: &CompareNode{Field: "name", Op: Op_eq, Value: "east"}}},
+		&InNode{Field: "status", Values: []interface{}{"a", "b"}},
+	}}
+	if !reflect.DeepEqual(node, expected) {
+		t.Fatalf("unexpected ast: %s", FilterToMap(node))
+	}
+
+	//序列化后再次解析得到相同的语法树
+	serialized, err := MarshalFilterJSON(node)
+	if err != nil {
+		t.Fatalf("MarshalFilterJSON failed: %v", err)
+	}
+	reparsed, err := ParseFilterJSON(serialized)
+	if err != nil {
+		t.Fatalf("ParseFilterJSON of serialized filter failed: %v", err)
+	}
+	if !reflect.DeepEqual(reparsed, node) {
+		t.Errorf("filter does not round trip: %s", serialized)
+	}
+
+	many2one := FIELDTYPE_MANY2ONE
+	customerModelId := "customer"
+	regionModelId := "region"
+	opc := &DefaultOperInConvert{
+		AppDb:   "app",
+		ModelId: "order",
+		Fields: &[]Field{{
+			Field:          "customer",
+			FieldType:      &many2one,
+			RelatedModelId: &customerModelId,
+			Fields:         &[]Field{{Field: "region", FieldType: &many2one, RelatedModelId: &regionModelId}},
+		}},
+	}
+	fc := &FilterConverter{OperInConvert: opc, RelationPathConvert: opc}
+	where, args, err := fc.CompileFilter(node)
+	if err != nil {
+		t.Fatalf("CompileFilter failed: %v", err)
+	}
+	expectedWhere := "(not (`deleted` is true)) and " +
+		"((`amount` between ? and ?) or ((`name` is not  null ) and (`name` like ? escape '!'))) and " +
+		"(`customer` in (select `id` from `app`.`customer` where `region` in (select `id` from `app`.`region` where `name` = ?) ) ) and " +
+		"(`status` in (?,?))"
+	if where != expectedWhere || fmt.Sprint(args) != "[1 10 x!_% east a b]" {
+		t.Errorf("unexpected where %q %v", where, args)
+	}
+
+	where, _, _ = fc.CompileFilter(&InNode{Field: "id"})
+	if where != "1=0" {
+		t.Errorf("empty in should be false, got %q", where)
+	}
+}
+
+func TestParseFilterErrorPosition(t *testing.T) {
+	tests := []struct {
+		filter string
+		path   string
+		offset int64
+	}{
+		{`{"Op.and": [{"a": 1}, {"b": {"Op.between": [1]}}]}`, "/Op.and/1/b/Op.between", -1},
+		{`{"a": {"Op.or": [{"Op.gt": 1}, {"Op.like": 2}]}}`, "/a/Op.or/1/Op.like", -1},
+		{`{"a": {"Op.in": [1, {"x": 1}]}}`, "/a/Op.in/1", -1},
+		{`{"a": {"Op.unknown": 1}}`, "/a/Op.unknown", -1},
+		{`{"a;drop": 1}`, "/a;drop", -1},
+		{`{"customer.na-me": 1}`, "/customer.na-me", -1},
+		{`{"Op.or": []}`, "/Op.or", -1},
+		{`{"a/b": {"Op.gt": [1]}}`, "/a~1b", -1},
+		{`{"a": 1,}`, "", 9},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFilterJSON([]byte(test.filter))
+		var parseErr *FilterParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%s: expected parse error, got %v", test.filter, err)
+			continue
+		}
+		if parseErr.Path != test.path || parseErr.Offset != test.offset {
+			t.Errorf("%s: expected position %q %d, got %q %d", test.filter, test.path, test.offset, parseErr.Path, parseErr.Offset)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
-	"strconv"
 	"strings"
 )
 
@@ -37,6 +36,7 @@ import (
       // Other operators
 
       [Op.all]: sequelize.literal('SELECT 1'), // > ALL (SELECT 1)
+      [Op.all]: [1, 2],                        // (> 1 AND > 2)，JSON格式的过滤条件使用数组，与数组中的所有值比较
 
       [Op.in]: [1, 2],                         // IN [1, 2]
       [Op.notIn]: [1, 2],                      // NOT IN [1, 2]
@@ -75,305 +75,346 @@ const (
 	Op_between    = "Op.between"
 	Op_notBetween = "Op.notBetween"
 	Op_like       = "Op.like"
+	Op_notLike    = "Op.notLike"
+	Op_startsWith = "Op.startsWith"
+	Op_endsWith   = "Op.endsWith"
+	Op_substring  = "Op.substring"
+	Op_regexp     = "Op.regexp"
+	Op_notRegexp  = "Op.notRegexp"
+	Op_col        = "Op.col"
+	Op_all        = "Op.all"
 	Op_in         = "Op.in"
 	Op_notIn      = "Op.notIn"
+	Op_exists     = "Op.exists"
 )
 
+// like语句中使用的转义字符，各数据库对字符串中反斜杠的处理不同，这里使用!作为转义字符
+const LIKE_ESCAPE_CHAR = "!"
+
+var likeEscapeReplacer = strings.NewReplacer(LIKE_ESCAPE_CHAR, LIKE_ESCAPE_CHAR+LIKE_ESCAPE_CHAR, "%", LIKE_ESCAPE_CHAR+"%", "_", LIKE_ESCAPE_CHAR+"_")
+
+// 转义like语句中的通配符，转义后的值需要配合escape子句使用
+func EscapeLikeValue(value string) string {
+	return likeEscapeReplacer.Replace(value)
+}
+
+
 // 当操作符为In时，允许对过滤的字段和值进行转换处理的接口
+// 如果需要将In操作转换为子查询，Convert返回的value应为*SubQuery类型
 type OperInConvert interface {
 	Convert(op string, field string, value interface{}) (string, interface{}, error)
 }
 
-type FilterConverter struct {
-	OperInConvert OperInConvert
+// 将关联模型上的过滤条件转换为本表字段和子查询的接口，转换后的过滤条件为：本表字段 in (子查询)
+// 关联字段路径（如customer.region.name）和{field:{Op.exists:filter}}都解析为关联模型上的过滤条件
+type RelationPathConvert interface {
+	ConvertRelationFilter(field string, filter FilterNode) (string, *SubQuery, error)
 }
 
-func (fc *FilterConverter) FilterToSQLWhere(filter *map[string]interface{}) (string, error) {
-	var str string
-	var err error
-	var where string
-	if filter != nil {
-		for key, value := range *filter {
-			switch key {
-			case Op_or:
-				mVal, _ := value.([]interface{})
-				slog.Debug("FilterToSQLWhere", "key", key, "value", value)
-				str, err = fc.convertArrayFilter("or", mVal)
-			case Op_and:
-				slog.Debug("FilterToSQLWhere", "key", key, "value", value)
-				mVal, _ := value.([]interface{})
-				str, err = fc.convertArrayFilter("and", mVal)
-			default:
-				slog.Debug("FilterToSQLWhere", "key", key, "value", value)
-				str, err = fc.convertFieldFilter(key, value)
-			}
+// 子查询语句及其参数，语句中的参数使用?占位
+type SubQuery struct {
+	SQL  string
+	Args []interface{}
+}
 
-			if err != nil {
-				return "", err
-			}
+// FieldExpressions用于将过滤条件中的字段名替换为对应的表达式，如分组查询的having条件中将聚合字段替换为聚合表达式
+type FilterConverter struct {
+	OperInConvert       OperInConvert
+	RelationPathConvert RelationPathConvert
+	Dialect             Dialect
+	FieldExpressions    map[string]string
+}
 
-			where = where + " (" + str + ") and"
-		}
+func (fc *FilterConverter) quoteIdentifier(identifier string) string {
+	if expression, ok := fc.FieldExpressions[identifier]; ok {
+		return expression
 	}
+	//JSON字段的路径在转换前已经检查过
+	if IsJSONPathField(identifier) {
+		expression, _ := GetFieldExpression(identifier, fc.Dialect)
+		return expression
+	}
+	return getDialectOrDefault(fc.Dialect).QuoteIdentifier(identifier)
+}
 
-	if len(where) > 0 {
-		where = where[0 : len(where)-3]
-	} else {
-		where = "1=1"
+// 将过滤条件转换为SQL的where语句，语句中的值都使用?占位，对应的参数按照出现的顺序返回
+// 过滤条件先解析为语法树再生成语句，对象中的字段和操作符按照字典序转换，数组中的条件保持原来的顺序
+// 过滤条件格式错误时返回的错误可以通过errors.As转换为QueryValidationError
+func (fc *FilterConverter) FilterToSQLWhere(filter *map[string]interface{}) (string, []interface{}, error) {
+	node, err := ParseFilter(filter)
+	if err != nil {
+		var validationErr *QueryValidationError
+		var parseErr *FilterParseError
+		if !errors.As(err, &validationErr) && errors.As(err, &parseErr) {
+			return "", nil, &QueryValidationError{Kind: INVALID_KIND_FILTER, Value: parseErr.Path + " " + parseErr.Message}
+		}
+		return "", nil, err
 	}
-	return where, nil
+	return fc.CompileFilter(node)
+}
+
+func (fc *FilterConverter) convertFieldValueNull(op string, field string) string {
+	return fc.quoteIdentifier(field) + op + " null "
 }
 
-func (fc *FilterConverter) convertArrayFilter(logicOp string, value []interface{}) (string, error) {
-	if len(value) == 0 {
-		slog.Error("convertArrayFilter error,filter format error", "logicOp", logicOp, "value", value)
-		return "", errors.New("convertArrayFilter error, filter format error")
+func (fc *FilterConverter) convertFieldValue(op string, field string, value interface{}) (string, []interface{}) {
+	return fc.quoteIdentifier(field) + op + "?", []interface{}{value}
+}
+
+func (fc *FilterConverter) convertFieldValueStringArray(op string, field string, sliceVal []string) (string, []interface{}) {
+	values := make([]interface{}, len(sliceVal))
+	for i, sVal := range sliceVal {
+		values[i] = sVal
 	}
+	return fc.convertFieldValueArray(op, field, values)
+}
 
-	var where string = ""
-	var str string
-	var err error
-	for _, v := range value {
-		//每个行应该是一个对象
-		mVal, _ := v.(map[string]interface{})
-		//slog.Debug("convertArrayFilter", "mVal", mVal)
-		str, err = fc.FilterToSQLWhere(&mVal)
-		//slog.Debug("convertArrayFilter", "str", str)
-		if err != nil {
-			return "", err
+func (fc *FilterConverter) convertFieldValueArray(op string, field string, sliceVal []interface{}) (string, []interface{}) {
+	placeholders := ""
+	var args []interface{}
+	for _, val := range sliceVal {
+		slog.Debug("convertFieldValueArray val type", "val type", reflect.TypeOf(val))
+
+		switch val.(type) {
+		case string, float64, int, int64:
+			placeholders = placeholders + "?,"
+			args = append(args, val)
 		}
-		where = where + " (" + str + ") " + logicOp
 	}
 
-	where = where[0 : len(where)-len(logicOp)]
+	if len(placeholders) > 1 {
+		placeholders = placeholders[0 : len(placeholders)-1]
+	}
+	return fc.quoteIdentifier(field) + op + "(" + placeholders + ")", args
+}
 
-	return where, nil
+func invalidOperandError(op string, value interface{}) error {
+	slog.Error("not supported operator with value type", "op", op, "val type", reflect.TypeOf(value))
+	return &QueryValidationError{Kind: INVALID_KIND_OPERAND, Value: fmt.Sprintf("%s %v", strings.TrimSpace(op), reflect.TypeOf(value))}
 }
 
-/*
-字段类型值的过滤，字段值有三种类型的过滤条件
-{fieldname:value}  =>  fieldname=value  //直接给值，相当与Op.like操作符
-{fieldname:[val1,val2]} => fieldname in (val1,val2)  //数据，相当于Op.in操作符？
-{fieldname:{Op.gt,value}} => filename > value  //明确给出操作符，按照操作符来解析
-*/
-func (fc *FilterConverter) convertFieldFilter(field string, value interface{}) (string, error) {
+// 比较操作符，值可以是字符串、数值、布尔值，或者{Op.col:字段}、{Op.all:子查询或数组}
+// eq和ne的值为null时转换为is null和is not null
+func (fc *FilterConverter) convertFieldOpCompare(op string, field string, value interface{}) (string, []interface{}, error) {
 	switch value.(type) {
-	case string:
-		sVal, _ := value.(string)
-		return fc.convertFieldValueString(" like ", field, sVal), nil
-	case float64:
-		fVal, _ := value.(float64)
-		sVal := fmt.Sprintf("%f", fVal)
-		return fc.convertFieldValueString(" = ", field, sVal), nil
-	case int64:
-		iVal, _ := value.(int64)
-		sVal := fmt.Sprintf("%d", iVal)
-		return fc.convertFieldValueString(" = ", field, sVal), nil
-	case map[string]interface{}:
-		mVal, _ := value.(map[string]interface{})
-		return fc.convertFieldValueMap(field, mVal)
+	case string, int, int64, float64, bool:
+		str, args := fc.convertFieldValue(op, field, value)
+		return str, args, nil
 	case nil:
-		return fc.convertFieldValueNull(" is ", field), nil
-	case []interface{}:
-		sliceVal := value.([]interface{})
-		return fc.convertFieldValueArray(" in ", field, sliceVal), nil
-	default:
-		slog.Error("convertFieldFilter not supported field filter type", "type", reflect.TypeOf(value))
-		errorStr := fmt.Sprintf("not supported field filter value type field: %s  value type %v ", field, value)
-		return "", errors.New(errorStr)
+		switch op {
+		case " = ":
+			return fc.convertFieldValueNull(" is ", field), nil, nil
+		case " <> ":
+			return fc.convertFieldValueNull(" is not ", field), nil, nil
+		}
+	case map[string]interface{}:
+		mVal := value.(map[string]interface{})
+		if len(mVal) == 1 {
+			if col, ok := mVal[Op_col]; ok {
+				return fc.convertFieldOpCol(op, field, col)
+			}
+			if subQuery, ok := mVal[Op_all]; ok {
+				return fc.convertFieldOpAll(op, field, subQuery)
+			}
+		}
 	}
+	return "", nil, invalidOperandError(op, value)
 }
 
-func (fc *FilterConverter) convertFieldValueNull(op string, field string) string {
-	return field + op + " null "
+// is和is not操作符只允许null和布尔值，布尔值直接写入语句中
+func (fc *FilterConverter) convertFieldOpIs(op string, field string, value interface{}) (string, []interface{}, error) {
+	switch value.(type) {
+	case nil:
+		return fc.convertFieldValueNull(op, field), nil, nil
+	case bool:
+		if value.(bool) {
+			return fc.quoteIdentifier(field) + op + "true", nil, nil
+		}
+		return fc.quoteIdentifier(field) + op + "false", nil, nil
+	}
+	return "", nil, invalidOperandError(op, value)
 }
 
-func (fc *FilterConverter) convertFieldValueString(op string, field string, value string) string {
-	if op == " like " {
-		value = "%" + value + "%"
+// like操作符，值只能是字符串
+// like和notLike与原有的like保持一致，在值的前后添加%，startsWith、endsWith、substring会转义值中的通配符
+func (fc *FilterConverter) convertFieldOpLike(op string, field string, value interface{}, prefix string, suffix string, escape bool) (string, []interface{}, error) {
+	sVal, ok := value.(string)
+	if !ok {
+		return "", nil, invalidOperandError(op, value)
 	}
-	return field + op + "'" + fc.replaceApostrophe(value) + "'"
-}
 
-func (fc *FilterConverter) convertFieldValueStringArray(op string, field string, sliceVal []string) string {
-	//slog.Debug("convertFieldValueStringArray", "op", op, "field", field, "sliceVal", sliceVal)
-	values := ""
-	for _, sVal := range sliceVal {
-		values = values + "'" + fc.replaceApostrophe(sVal) + "',"
+	if !escape {
+		return fc.quoteIdentifier(field) + op + "?", []interface{}{prefix + sVal + suffix}, nil
 	}
-	values = values[0 : len(values)-1]
-	return field + op + "(" + values + ")"
+	return fc.quoteIdentifier(field) + op + "? escape '" + LIKE_ESCAPE_CHAR + "'", []interface{}{prefix + EscapeLikeValue(sVal) + suffix}, nil
 }
 
-func (fc *FilterConverter) convertFieldValueArray(op string, field string, sliceVal []interface{}) string {
-	values := ""
-	for _, val := range sliceVal {
-		slog.Debug("convertFieldValueArray val type", "val type", reflect.TypeOf(val))
+// between操作符，值必须是包含两个字符串或数值的数组
+func (fc *FilterConverter) convertFieldOpBetween(op string, field string, value interface{}) (string, []interface{}, error) {
+	var values []interface{}
+	switch value.(type) {
+	case []interface{}:
+		values = value.([]interface{})
+	case []string:
+		for _, sVal := range value.([]string) {
+			values = append(values, sVal)
+		}
+	}
 
+	if len(values) != 2 {
+		return "", nil, invalidOperandError(op, value)
+	}
+	for _, val := range values {
 		switch val.(type) {
-		case string:
-			sVal, _ := val.(string)
-			values = values + "'" + fc.replaceApostrophe(sVal) + "',"
-		case float64:
-			f64Val, _ := val.(float64)
-			sVal := strconv.FormatFloat(f64Val, 'f', -1, 64)
-			values = values + sVal + ","
+		case string, int, int64, float64:
+		default:
+			return "", nil, invalidOperandError(op, value)
 		}
 	}
+	return fc.quoteIdentifier(field) + op + "? and ?", values, nil
+}
 
-	if len(values) > 1 {
-		values = values[0 : len(values)-1]
+// 正则表达式匹配，sqlite需要调用方注册regexp函数
+func (fc *FilterConverter) convertFieldOpRegexp(not bool, field string, value interface{}) (string, []interface{}, error) {
+	op := getDialectOrDefault(fc.Dialect).RegexpOperator(not)
+	sVal, ok := value.(string)
+	if !ok {
+		return "", nil, invalidOperandError(op, value)
 	}
-	return field + op + "(" + values + ")"
+	return fc.quoteIdentifier(field) + op + "?", []interface{}{sVal}, nil
 }
 
-func (fc *FilterConverter) convertFieldOpNormal(op string, field string, value interface{}) (string, error) {
+// 与其它字段比较，值为字段名或者表名.字段名
+func (fc *FilterConverter) convertFieldOpCol(op string, field string, value interface{}) (string, []interface{}, error) {
+	sVal, ok := value.(string)
+	if !ok {
+		return "", nil, invalidOperandError(op, value)
+	}
+
+	var quoted []string
+	for _, part := range strings.Split(sVal, ".") {
+		quotedPart, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, part, fc.Dialect)
+		if err != nil {
+			return "", nil, err
+		}
+		quoted = append(quoted, quotedPart)
+	}
+	if len(quoted) > 2 {
+		return "", nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: sVal}
+	}
+	return fc.quoteIdentifier(field) + op + strings.Join(quoted, "."), nil, nil
+}
+
+// 与子查询或数组的所有值比较，不接受SQL字符串
+// 数组转换为与每个值比较的and条件，各数据库都支持，与空数组比较时条件成立
+func (fc *FilterConverter) convertFieldOpAll(op string, field string, value interface{}) (string, []interface{}, error) {
 	switch value.(type) {
-	case string:
-		sVal, _ := value.(string)
-		return fc.convertFieldValueString(op, field, sVal), nil
-	case []string:
-		sliceVal := value.([]string)
-		return fc.convertFieldValueStringArray(op, field, sliceVal), nil
-	case int:
-		iVal, _ := value.(int)
-		sVal := fmt.Sprintf("%d", iVal)
-		return fc.convertFieldValueString(op, field, sVal), nil
-	case int64:
-		iVal, _ := value.(int64)
-		sVal := fmt.Sprintf("%d", iVal)
-		return fc.convertFieldValueString(op, field, sVal), nil
-	case float64:
-		fVal, _ := value.(float64)
-		sVal := fmt.Sprintf("%f", fVal)
-		return fc.convertFieldValueString(op, field, sVal), nil
+	case *SubQuery:
+		subQuery := value.(*SubQuery)
+		return fc.quoteIdentifier(field) + op + "all (" + subQuery.SQL + ")", subQuery.Args, nil
 	case []interface{}:
-		sliceVal := value.([]interface{})
-		return fc.convertFieldValueArray(op, field, sliceVal), nil
-	case nil:
-		return fc.convertFieldValueNull(op, field), nil
-	default:
-		slog.Error("convertFieldOpNormal not supported operator with value type", "op", op, "val type", reflect.TypeOf(value))
-		errorStr := fmt.Sprintf("not supported operator value type,op: %s value type: %v", op, reflect.TypeOf(value))
-		return "", errors.New(errorStr)
+		values := value.([]interface{})
+		if len(values) == 0 {
+			return "1=1", nil, nil
+		}
+		conditions := make([]string, len(values))
+		for index, item := range values {
+			switch item.(type) {
+			case string, int, int64, float64, bool:
+			default:
+				return "", nil, invalidOperandError(op+"all", item)
+			}
+			conditions[index] = fc.quoteIdentifier(field) + op + "?"
+		}
+		return "(" + strings.Join(conditions, " and ") + ")", values, nil
 	}
+	return "", nil, invalidOperandError(op+"all", value)
 }
 
-func (fc *FilterConverter) convertOpInString(op string, field string, value string) string {
-	return field + " in (" + value + ") "
+func (fc *FilterConverter) convertOpInSubQuery(op string, field string, value *SubQuery) (string, []interface{}) {
+	return fc.quoteIdentifier(field) + op + "(" + value.SQL + ") ", value.Args
 }
 
-func (fc *FilterConverter) convertFieldOpIn(op string, field string, value interface{}) (string, error) {
+func (fc *FilterConverter) convertFieldOpIn(op string, field string, value interface{}) (string, []interface{}, error) {
 	//这里考虑对In操作的字段和值进行转换处理
 	if fc.OperInConvert != nil {
 		var err error
 		field, value, err = fc.OperInConvert.Convert(op, field, value)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 	}
 
 	switch value.(type) {
+	case *SubQuery:
+		str, args := fc.convertOpInSubQuery(op, field, value.(*SubQuery))
+		return str, args, nil
 	case string:
 		sVal := value.(string)
-		return fc.convertOpInString(op, field, sVal), nil
+		str, args := fc.convertFieldValueStringArray(op, field, []string{sVal})
+		return str, args, nil
 	case []string:
 		sliceVal := value.([]string)
-		return fc.convertFieldValueStringArray(op, field, sliceVal), nil
+		str, args := fc.convertFieldValueStringArray(op, field, sliceVal)
+		return str, args, nil
 	case []interface{}:
 		sliceVal := value.([]interface{})
-		return fc.convertFieldValueArray(op, field, sliceVal), nil
+		str, args := fc.convertFieldValueArray(op, field, sliceVal)
+		return str, args, nil
 	default:
 		slog.Error("convertFieldOpIn not supported operator with value type", "op", op, "val type", reflect.TypeOf(value))
-		return "", errors.New("not supported operator value type,op: " + op + " value type: " + reflect.TypeOf(value).String())
-	}
-}
-
-func (fc *FilterConverter) convertFieldValueMap(field string, value map[string]interface{}) (string, error) {
-	var where string
-	var str string
-	var err error
-	var index int = 0
-	for key, value := range value {
-		switch key {
-		case Op_eq:
-			str, err = fc.convertFieldOpNormal(" = ", field, value)
-		case Op_ne:
-			str, err = fc.convertFieldOpNormal(" <> ", field, value)
-		case Op_gt:
-			str, err = fc.convertFieldOpNormal(" > ", field, value)
-		case Op_lt:
-			str, err = fc.convertFieldOpNormal(" < ", field, value)
-		case Op_gte:
-			str, err = fc.convertFieldOpNormal(" >= ", field, value)
-		case Op_lte:
-			str, err = fc.convertFieldOpNormal(" <= ", field, value)
-		case Op_in:
-			str, err = fc.convertFieldOpIn(" in ", field, value)
-		case Op_notIn:
-			str, err = fc.convertFieldOpIn(" not in ", field, value)
-		case Op_is:
-			str, err = fc.convertFieldOpNormal(" is ", field, value)
-		case Op_not:
-			str, err = fc.convertFieldOpNormal(" is not ", field, value)
-		case Op_like:
-			str, err = fc.convertFieldOpNormal(" like ", field, value)
-		case Op_or:
-			str, err = fc.convertFieldArrayFilter("or", field, value)
-		case Op_and:
-			str, err = fc.convertFieldArrayFilter("and", field, value)
-		default:
-			//字段
-			slog.Error("convertFieldValueMap not supported operator type", "operator type", key)
-			return "", errors.New("not supported operator type " + key)
-		}
-
-		if err != nil {
-			return "", err
-		}
-
-		if index == 0 {
-			where = str
-		} else {
-			where = where + " and " + str
-		}
-
-		index++
+		return "", nil, fmt.Errorf("not supported operator value type,op: %s value type: %v", op, reflect.TypeOf(value))
 	}
-	return where, nil
 }
 
-func (fc *FilterConverter) convertFieldArrayFilter(logicOp string, field string, value interface{}) (string, error) {
-	valueArray, ok := value.([]interface{})
-	if !ok {
-		slog.Error("convertFieldArrayFilter error,filter format error", "logicOp", logicOp, "value", value)
-		return "", errors.New("convertFieldArrayFilter error, filter format error")
-	}
-
-	if len(valueArray) == 0 {
-		slog.Error("convertFieldArrayFilter error,filter format error", "logicOp", logicOp, "value", value)
-		return "", errors.New("convertFieldArrayFilter error, filter format error")
-	}
-
-	var where string = ""
-	var str string
-	var err error
-	for _, v := range valueArray {
-		//每个行应该是一个对象
-		mVal, _ := v.(map[string]interface{})
-		str, err = fc.convertFieldFilter(field, mVal)
-		if err != nil {
-			return "", err
-		}
-		where = where + " (" + str + ") " + logicOp
+// 单个字段上的操作符，不包含Op.or、Op.and、Op.exists等组合条件
+func (fc *FilterConverter) convertFieldOperator(op string, field string, value interface{}) (string, []interface{}, error) {
+	switch op {
+	case Op_eq:
+		return fc.convertFieldOpCompare(" = ", field, value)
+	case Op_ne:
+		return fc.convertFieldOpCompare(" <> ", field, value)
+	case Op_gt:
+		return fc.convertFieldOpCompare(" > ", field, value)
+	case Op_lt:
+		return fc.convertFieldOpCompare(" < ", field, value)
+	case Op_gte:
+		return fc.convertFieldOpCompare(" >= ", field, value)
+	case Op_lte:
+		return fc.convertFieldOpCompare(" <= ", field, value)
+	case Op_between:
+		return fc.convertFieldOpBetween(" between ", field, value)
+	case Op_notBetween:
+		return fc.convertFieldOpBetween(" not between ", field, value)
+	case Op_in:
+		return fc.convertFieldOpIn(" in ", field, value)
+	case Op_notIn:
+		return fc.convertFieldOpIn(" not in ", field, value)
+	case Op_is:
+		return fc.convertFieldOpIs(" is ", field, value)
+	case Op_not:
+		return fc.convertFieldOpIs(" is not ", field, value)
+	case Op_like:
+		return fc.convertFieldOpLike(" like ", field, value, "%", "%", false)
+	case Op_notLike:
+		return fc.convertFieldOpLike(" not like ", field, value, "%", "%", false)
+	case Op_startsWith:
+		return fc.convertFieldOpLike(" like ", field, value, "", "%", true)
+	case Op_endsWith:
+		return fc.convertFieldOpLike(" like ", field, value, "%", "", true)
+	case Op_substring:
+		return fc.convertFieldOpLike(" like ", field, value, "%", "%", true)
+	case Op_regexp:
+		return fc.convertFieldOpRegexp(false, field, value)
+	case Op_notRegexp:
+		return fc.convertFieldOpRegexp(true, field, value)
+	case Op_col:
+		return fc.convertFieldOpCol(" = ", field, value)
+	case Op_all:
+		return fc.convertFieldOpAll(" > ", field, value)
+	case Op_match, Op_search:
+		return fc.convertFieldOpMatch(field, value)
 	}
-
-	where = where[0 : len(where)-len(logicOp)]
-
-	return where, nil
-}
-
-func (fc *FilterConverter) replaceApostrophe(str string) string {
-	replacedStr := strings.ReplaceAll(str, "'", "''")
-	return replacedStr
+	slog.Error("convertFieldOperator not supported operator type", "operator type", op)
+	return "", nil, errors.New("not supported operator type " + op)
 }
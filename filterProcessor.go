@@ -1,6 +1,7 @@
 package crvorm
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
@@ -16,6 +17,16 @@ type FilterDataItem struct {
 }
 
 func ProcessFilter(
+	filter *map[string]interface{},
+	filterData *[]FilterDataItem,
+	globalFilterData *map[string]interface{},
+	appDb string,
+	repo DataRepository) error {
+	return ProcessFilterContext(context.Background(), filter, filterData, globalFilterData, appDb, repo)
+}
+
+func ProcessFilterContext(
+	ctx context.Context,
 	filter *map[string]interface{},
 	filterData *[]FilterDataItem,
 	globalFilterData *map[string]interface{},
@@ -26,7 +37,7 @@ func ProcessFilter(
 
 	if filterData != nil && len(*filterData) > 0 {
 		var err error
-		filterDataRes, err= GetFilterData(filterData, globalFilterData, appDb, repo)
+		filterDataRes, err= GetFilterDataContext(ctx, filterData, globalFilterData, appDb, repo)
 		if err != nil {
 			slog.Debug("ProcessFilter end with error")
 			return err
@@ -259,6 +270,16 @@ func GetFilterData(
 	appDb string,
 	repo DataRepository,
 	) (*map[string]interface{}, error) {
+	return GetFilterDataContext(context.Background(), filterData, globalFilterData, appDb, repo)
+}
+
+func GetFilterDataContext(
+	ctx context.Context,
+	filterData *[]FilterDataItem,
+	globalFilterData *map[string]interface{},
+	appDb string,
+	repo DataRepository,
+	) (*map[string]interface{}, error) {
 
 	slog.Debug("getFilterData start")
 
@@ -279,9 +300,9 @@ func GetFilterData(
 			AppDb:      appDb,
 			Distinct:   true,
 		}
-		result, err := ExecuteQuery(refQueryParam,repo, false)
+		result, err := ExecuteQueryContext(ctx, refQueryParam, repo, false)
 		if err != nil {
-			slog.Error("GetFilterData error:", err)
+			slog.Error("GetFilterData error", "error", err)
 			return nil, err
 		}
 		res[item.ModelId] = result
@@ -1,6 +1,8 @@
 package crvorm
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"os"
@@ -44,6 +46,11 @@ func TestReplaceFilterVar(t *testing.T) {
 }
 
 func TestProcessFilter(t *testing.T) {
+	//需要连接MySQL数据库，设置环境变量CRVORM_MYSQL_TEST后执行
+	if os.Getenv("CRVORM_MYSQL_TEST") == "" {
+		t.Skip("CRVORM_MYSQL_TEST is not set, skip test that needs a MySQL database")
+	}
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug, AddSource: true}))
 	slog.SetDefault(logger)
 
@@ -107,4 +114,89 @@ func TestProcessFilter(t *testing.T) {
 	}
 
 	fmt.Println("filter:", filter)
-}
\ No newline at end of file
+}
+
+func TestFilterConverterOperators(t *testing.T) {
+	subQuery := &SubQuery{SQL: "select `amount` from `app`.`limit` where `type`=?", Args: []interface{}{"max"}}
+	tests := []struct {
+		name    string
+		dialect Dialect
+		filter  map[string]interface{}
+		where   string
+		args    string
+		invalid bool
+	}{
+		{"bool value", nil, map[string]interface{}{"active": true}, "`active` = ?", "[true]", false},
+		{"empty in", nil, map[string]interface{}{"id": map[string]interface{}{Op_in: []interface{}{}}}, "1=0", "[]", false},
+		{"empty not in", nil, map[string]interface{}{"id": map[string]interface{}{Op_notIn: []interface{}{}}}, "1=1", "[]", false},
+		{"not scalar", nil, map[string]interface{}{Op_not: "a"}, "", "", true},
+		{"between", nil, map[string]interface{}{"age": map[string]interface{}{Op_between: []interface{}{6, 10}}}, "`age` between ? and ?", "[6 10]", false},
+		{"notBetween", nil, map[string]interface{}{"age": map[string]interface{}{Op_notBetween: []interface{}{"a", "c"}}}, "`age` not between ? and ?", "[a c]", false},
+		{"between one value", nil, map[string]interface{}{"age": map[string]interface{}{Op_between: []interface{}{6}}}, "", "", true},
+		{"between object", nil, map[string]interface{}{"age": map[string]interface{}{Op_between: []interface{}{6, map[string]interface{}{}}}}, "", "", true},
+		{"like", nil, map[string]interface{}{"name": map[string]interface{}{Op_like: "a"}}, "`name` like ?", "[%a%]", false},
+		{"notLike", nil, map[string]interface{}{"name": map[string]interface{}{Op_notLike: "a"}}, "`name` not like ?", "[%a%]", false},
+		{"like number", nil, map[string]interface{}{"name": map[string]interface{}{Op_like: 1}}, "", "", true},
+		{"startsWith", nil, map[string]interface{}{"name": map[string]interface{}{Op_startsWith: "50%_a!"}}, "`name` like ? escape '!'", "[50!%!_a!!%]", false},
+		{"endsWith", nil, map[string]interface{}{"name": map[string]interface{}{Op_endsWith: "hat"}}, "`name` like ? escape '!'", "[%hat]", false},
+		{"substring", nil, map[string]interface{}{"name": map[string]interface{}{Op_substring: "h_t"}}, "`name` like ? escape '!'", "[%h!_t%]", false},
+		{"substring array", nil, map[string]interface{}{"name": map[string]interface{}{Op_substring: []interface{}{"a"}}}, "", "", true},
+		{"regexp mysql", nil, map[string]interface{}{"name": map[string]interface{}{Op_regexp: "^[h|a|t]"}}, "`name` regexp ?", "[^[h|a|t]]", false},
+		{"notRegexp mysql", nil, map[string]interface{}{"name": map[string]interface{}{Op_notRegexp: "^h"}}, "`name` not regexp ?", "[^h]", false},
+		{"regexp postgres", &PostgresDialect{}, map[string]interface{}{"name": map[string]interface{}{Op_regexp: "^h"}}, `"name" ~ ?`, "[^h]", false},
+		{"notRegexp postgres", &PostgresDialect{}, map[string]interface{}{"name": map[string]interface{}{Op_notRegexp: "^h"}}, `"name" !~ ?`, "[^h]", false},
+		{"col", nil, map[string]interface{}{"user_id": map[string]interface{}{Op_col: "user.organization_id"}}, "`user_id` = `user`.`organization_id`", "[]", false},
+		{"col injection", nil, map[string]interface{}{"user_id": map[string]interface{}{Op_col: "id;drop table user"}}, "", "", true},
+		{"gt col", nil, map[string]interface{}{"end": map[string]interface{}{Op_gt: map[string]interface{}{Op_col: "start"}}}, "`end` > `start`", "[]", false},
+		{"all", nil, map[string]interface{}{"amount": map[string]interface{}{Op_all: subQuery}}, "`amount` > all (select `amount` from `app`.`limit` where `type`=?)", "[max]", false},
+		{"lte all", nil, map[string]interface{}{"amount": map[string]interface{}{Op_lte: map[string]interface{}{Op_all: subQuery}}}, "`amount` <= all (select `amount` from `app`.`limit` where `type`=?)", "[max]", false},
+		{"all sql string", nil, map[string]interface{}{"amount": map[string]interface{}{Op_all: "select 1"}}, "", "", true},
+		{"all array", nil, map[string]interface{}{"amount": map[string]interface{}{Op_all: []interface{}{10, 20.5}}}, "(`amount` > ? and `amount` > ?)", "[10 20.5]", false},
+		{"lte all array", &PostgresDialect{}, map[string]interface{}{"amount": map[string]interface{}{Op_lte: map[string]interface{}{Op_all: []interface{}{"a"}}}}, `("amount" <= ?)`, "[a]", false},
+		{"all empty array", nil, map[string]interface{}{"amount": map[string]interface{}{Op_all: []interface{}{}}}, "1=1", "[]", false},
+		{"all object item", nil, map[string]interface{}{"amount": map[string]interface{}{Op_all: []interface{}{map[string]interface{}{}}}}, "", "", true},
+		{"gt array", nil, map[string]interface{}{"age": map[string]interface{}{Op_gt: []interface{}{1, 2}}}, "", "", true},
+		{"eq null", nil, map[string]interface{}{"age": map[string]interface{}{Op_eq: nil}}, "`age` is  null ", "[]", false},
+		{"ne bool", nil, map[string]interface{}{"active": map[string]interface{}{Op_ne: true}}, "`active` <> ?", "[true]", false},
+		{"is true", nil, map[string]interface{}{"active": map[string]interface{}{Op_is: true}}, "`active` is true", "[]", false},
+		{"not null", nil, map[string]interface{}{"active": map[string]interface{}{Op_not: nil}}, "`active` is not  null ", "[]", false},
+		{"is string", nil, map[string]interface{}{"active": map[string]interface{}{Op_is: "1"}}, "", "", true},
+		{"match mysql", nil, map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: " red +shoe* ", MATCH_FIELDS: []interface{}{"name", "remark"}}}, "match (`name`,`remark`) against (? in boolean mode)", "[+red* +shoe*]", false},
+		{"search sqlite", &SQLiteDialect{}, map[string]interface{}{Op_search: map[string]interface{}{MATCH_QUERY: "red 50%", MATCH_FIELDS: []string{"name", "remark"}}}, `("name" like ? escape '!' or "remark" like ? escape '!') and ("name" like ? escape '!' or "remark" like ? escape '!')`, "[%red% %red% %50!%% %50!%%]", false},
+		{"match field postgres", &PostgresDialect{}, map[string]interface{}{"name": map[string]interface{}{Op_match: "red"}}, `to_tsvector('simple',coalesce("name"::text,'')) @@ to_tsquery('simple',?)`, "['red':*]", false},
+		{"match postgres", &PostgresDialect{}, map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: `Red it's a\b`, MATCH_FIELDS: []interface{}{"name", "remark"}}}, `to_tsvector('simple',coalesce("name"::text,'') || ' ' || coalesce("remark"::text,'')) @@ to_tsquery('simple',?)`, `['Red':* & 'it''s':* & 'a\\b':*]`, false},
+		{"match empty query", nil, map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: " - ", MATCH_FIELDS: []interface{}{"name"}}}, "1=1", "[]", false},
+		{"match no fields", nil, map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: "red"}}, "", "", true},
+		{"match invalid field", nil, map[string]interface{}{Op_match: map[string]interface{}{MATCH_QUERY: "red", MATCH_FIELDS: []interface{}{"name;drop"}}}, "", "", true},
+		{"match number", nil, map[string]interface{}{"name": map[string]interface{}{Op_match: 1}}, "", "", true},
+	}
+
+	for _, test := range tests {
+		fc := &FilterConverter{Dialect: test.dialect}
+		where, args, err := fc.FilterToSQLWhere(&test.filter)
+		if test.invalid {
+			var validationErr *QueryValidationError
+			if !errors.As(err, &validationErr) {
+				t.Errorf("%s: expected validation error, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: FilterToSQLWhere failed: %v", test.name, err)
+			continue
+		}
+		if where != test.where || fmt.Sprint(args) != test.args {
+			t.Errorf("%s: expected %q %s, got %q %v", test.name, test.where, test.args, where, args)
+		}
+	}
+
+	//客户端通过JSON传递的过滤条件同样可以使用Op.all
+	var filter map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"amount":{"Op.gte":{"Op.all":[10,20]}}}`), &filter); err != nil {
+		t.Fatal(err)
+	}
+	where, args, err := (&FilterConverter{}).FilterToSQLWhere(&filter)
+	if err != nil || where != "(`amount` >= ? and `amount` >= ?)" || fmt.Sprint(args) != "[10 20]" {
+		t.Errorf("unexpected Op.all from JSON %q %v %v", where, args, err)
+	}
+}
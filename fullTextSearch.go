@@ -0,0 +1,132 @@
+package crvorm
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+/*
+全文检索的过滤条件，Op.match和Op.search含义相同
+{Op.match:{query:"red shoe",fields:["name","description"]}}  //在多个字段中检索
+{name:{Op.match:"red shoe"}}  //在单个字段中检索
+检索内容按照空白字符拆分为多个词，每个词都需要在任意一个字段中出现
+mysql转换为match ... against布尔模式，字段上需要建立包含这些字段的FULLTEXT索引
+postgres转换为to_tsvector(...) @@ to_tsquery(...)，每个词按照前缀匹配
+其它数据库转换为每个词在各字段上的like条件
+*/
+
+const (
+	Op_match  = "Op.match"
+	Op_search = "Op.search"
+)
+
+const (
+	MATCH_QUERY  = "query"
+	MATCH_FIELDS = "fields"
+)
+
+// mysql布尔模式中有特殊含义的字符，拆分检索词时作为分隔符处理
+const FULLTEXT_OPERATOR_CHARS = "+-<>()~*\"@"
+
+// 将检索内容拆分为检索词，去掉布尔模式中的操作符
+func GetSearchTokens(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return strings.ContainsRune(FULLTEXT_OPERATOR_CHARS, r) || unicode.IsSpace(r)
+	})
+}
+
+// 布尔模式的检索内容，每个词都必须出现，并且按照前缀匹配
+func getBooleanModeQuery(tokens []string) string {
+	return "+" + strings.Join(tokens, "* +") + "*"
+}
+
+// postgres的检索内容，检索词加引号后按照前缀匹配，引号中的单引号和反斜杠需要转义
+var tsQueryEscapeReplacer = strings.NewReplacer(`\`, `\\`, "'", "''")
+
+func getTSQuery(tokens []string) string {
+	lexemes := make([]string, len(tokens))
+	for index, token := range tokens {
+		lexemes[index] = "'" + tsQueryEscapeReplacer.Replace(token) + "':*"
+	}
+	return strings.Join(lexemes, " & ")
+}
+
+// 字段上的Op.match，值只能是字符串
+func (fc *FilterConverter) convertFieldOpMatch(field string, value interface{}) (string, []interface{}, error) {
+	sVal, ok := value.(string)
+	if !ok {
+		return "", nil, invalidOperandError(Op_match, value)
+	}
+	return fc.convertMatch([]string{field}, sVal)
+}
+
+// 生成全文检索条件，检索内容为空时不做过滤
+func (fc *FilterConverter) convertMatch(fields []string, query string) (string, []interface{}, error) {
+	quotedFields := make([]string, len(fields))
+	for index, field := range fields {
+		if err := CheckFieldIdentifier(field); err != nil {
+			return "", nil, err
+		}
+		quotedFields[index] = fc.quoteIdentifier(field)
+	}
+
+	tokens := GetSearchTokens(query)
+	if len(tokens) == 0 {
+		return "1=1", nil, nil
+	}
+
+	if match, args := getDialectOrDefault(fc.Dialect).FullTextMatch(quotedFields, tokens); len(match) > 0 {
+		return match, args, nil
+	}
+
+	slog.Debug("convertMatch dialect not support full text search, use like instead", "fields", fields)
+	var where []string
+	var args []interface{}
+	for _, token := range tokens {
+		var conditions []string
+		for _, field := range quotedFields {
+			conditions = append(conditions, field+" like ? escape '"+LIKE_ESCAPE_CHAR+"'")
+			args = append(args, "%"+EscapeLikeValue(token)+"%")
+		}
+		where = append(where, "("+strings.Join(conditions, " or ")+")")
+	}
+	return strings.Join(where, " and "), args, nil
+}
+
+// 解析{query:"",fields:[]}格式的全文检索条件为语法树节点
+func parseMatchFilter(value interface{}, path string) (FilterNode, error) {
+	mVal, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, newFilterParseError(path, "value must be an object, got %v", reflect.TypeOf(value))
+	}
+	query, ok := mVal[MATCH_QUERY].(string)
+	if !ok {
+		return nil, newFilterParseError(getFilterPath(path, MATCH_QUERY), "value must be a string, got %v", reflect.TypeOf(mVal[MATCH_QUERY]))
+	}
+
+	fieldsPath := getFilterPath(path, MATCH_FIELDS)
+	var fields []string
+	switch mVal[MATCH_FIELDS].(type) {
+	case []string:
+		fields = append(fields, mVal[MATCH_FIELDS].([]string)...)
+	case []interface{}:
+		for _, field := range mVal[MATCH_FIELDS].([]interface{}) {
+			sVal, ok := field.(string)
+			if !ok {
+				return nil, newFilterParseError(fieldsPath, "fields must be strings, got %v", reflect.TypeOf(field))
+			}
+			fields = append(fields, sVal)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, newFilterParseError(fieldsPath, "fields must not be empty")
+	}
+	for _, field := range fields {
+		if err := checkFilterField(field, fieldsPath); err != nil {
+			return nil, err
+		}
+	}
+	return &MatchNode{Fields: fields, Query: query}, nil
+}
@@ -2,6 +2,10 @@ module github.com/wzscq/crvorm
 
 go 1.22.1
 
-require github.com/go-sql-driver/mysql v1.8.1
+require (
+	github.com/go-sql-driver/mysql v1.8.1
+	github.com/mattn/go-sqlite3 v1.14.22
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require filippo.io/edwards25519 v1.1.0 // indirect
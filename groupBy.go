@@ -0,0 +1,142 @@
+package crvorm
+
+import (
+	"log/slog"
+)
+
+// 分组字段，Period不为空时按照时间粒度对日期字段分组，结果中的字段名仍为Field
+type GroupField struct {
+	Field  string `json:"field"`
+	Period string `json:"period,omitempty"`
+}
+
+// 聚合字段，Summarize的格式与Field.Summarize相同，如sum(amount)、count(*)
+// Field为结果中聚合值对应的字段名
+type Aggregate struct {
+	Field     string `json:"field"`
+	Summarize string `json:"summarize"`
+}
+
+func IsGroupQuery(query *QueryParam) bool {
+	return (query.GroupBy != nil && len(*query.GroupBy) > 0) ||
+		(query.Aggregates != nil && len(*query.Aggregates) > 0)
+}
+
+func isGroupSQLParam(sqlParam *SQLParam) bool {
+	return sqlParam.Group || len(sqlParam.GroupBy) > 0 || len(sqlParam.Having) > 0
+}
+
+// 获取分组字段的表达式
+func GetGroupFieldExpression(groupField GroupField, dialect Dialect) (string, error) {
+	field, err := GetFieldExpression(groupField.Field, dialect)
+	if err != nil {
+		return "", err
+	}
+
+	switch groupField.Period {
+	case "":
+		return field, nil
+	case DATE_PERIOD_YEAR, DATE_PERIOD_MONTH, DATE_PERIOD_DAY:
+		return dialect.DatePeriod(field, groupField.Period), nil
+	}
+	slog.Error("GetGroupFieldExpression not supported period", "field", groupField.Field, "period", groupField.Period)
+	return "", &QueryValidationError{Kind: INVALID_KIND_GROUP, Value: "period " + groupField.Period}
+}
+
+// 生成分组查询的查询字段、分组字段、having条件和排序
+// 未指定排序时按照分组字段排序，排序字段可以是分组字段或聚合字段
+func setGroupParam(sqlParam *SQLParam, query *QueryParam, dialect Dialect) error {
+	if query.Cursor != nil {
+		slog.Error("setGroupParam cursor can not be used with group query")
+		return &QueryValidationError{Kind: INVALID_KIND_GROUP, Value: "cursor with group query"}
+	}
+
+	var fieldsStr, groupByStr string
+	expressions := map[string]string{}
+	defaultSorters := []Sorter{}
+	if query.GroupBy != nil {
+		for _, groupField := range *query.GroupBy {
+			expression, err := GetGroupFieldExpression(groupField, dialect)
+			if err != nil {
+				return err
+			}
+			fieldsStr = fieldsStr + expression + " as " + dialect.QuoteIdentifier(groupField.Field) + ","
+			groupByStr = groupByStr + expression + ","
+			expressions[groupField.Field] = expression
+			defaultSorters = append(defaultSorters, Sorter{Field: groupField.Field})
+		}
+	}
+
+	if query.Aggregates != nil {
+		for _, aggregate := range *query.Aggregates {
+			alias, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, aggregate.Field, dialect)
+			if err != nil {
+				return err
+			}
+			summarize, err := CheckSummarize(aggregate.Summarize, dialect)
+			if err != nil {
+				return err
+			}
+			fieldsStr = fieldsStr + summarize + " as " + alias + ","
+			expressions[aggregate.Field] = summarize
+			if len(defaultSorters) == 0 {
+				defaultSorters = append(defaultSorters, Sorter{Field: aggregate.Field})
+			}
+		}
+	}
+
+	sqlParam.Group = true
+	sqlParam.Fields = fieldsStr[0 : len(fieldsStr)-1]
+	if len(groupByStr) > 0 {
+		sqlParam.GroupBy = groupByStr[0 : len(groupByStr)-1]
+	}
+
+	//having条件中的字段替换为分组或聚合表达式，postgres不支持在having中使用字段别名
+	if query.Having != nil && len(*query.Having) > 0 {
+		fc := &FilterConverter{
+			Dialect:          dialect,
+			FieldExpressions: expressions,
+		}
+		var err error
+		sqlParam.Having, sqlParam.HavingArgs, err = fc.FilterToSQLWhere(query.Having)
+		if err != nil {
+			return err
+		}
+	}
+
+	sorters := query.Sorter
+	if sorters == nil || len(*sorters) == 0 {
+		sorters = &defaultSorters
+	}
+	var err error
+	sqlParam.Sorter, sqlParam.SorterArgs, err = GetQuerySorter(sorters, dialect)
+	if err != nil {
+		return err
+	}
+	sqlParam.Limit = GetQueryLimit(query.Pagination, dialect)
+	return nil
+}
+
+func getGroupByClause(sqlParam *SQLParam) string {
+	var clause string
+	if len(sqlParam.GroupBy) > 0 {
+		clause = " group by " + sqlParam.GroupBy
+	}
+	if len(sqlParam.Having) > 0 {
+		clause = clause + " having " + sqlParam.Having
+	}
+	return clause
+}
+
+// 分组查询的总数为分组的个数，子查询使用聚合函数，没有分组字段时整个表为一个分组
+func SQLParamToGroupCountSQL(sqlParam *SQLParam) (string, []interface{}) {
+	sql := "select count(*) as __count from (select count(*) as __group" +
+		" from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, sqlParam.Dialect) +
+		" where " + sqlParam.Where +
+		getGroupByClause(sqlParam) +
+		") __group_count"
+	args := make([]interface{}, 0, len(sqlParam.WhereArgs)+len(sqlParam.HavingArgs))
+	args = append(args, sqlParam.WhereArgs...)
+	args = append(args, sqlParam.HavingArgs...)
+	return sql, args
+}
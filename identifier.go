@@ -0,0 +1,97 @@
+package crvorm
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// 查询参数校验失败时的错误类型
+const (
+	INVALID_KIND_APPDB     = "appDb"
+	INVALID_KIND_MODELID   = "modelId"
+	INVALID_KIND_FIELD     = "field"
+	INVALID_KIND_SORTER    = "sorter order"
+	INVALID_KIND_SUMMARIZE = "summarize"
+	INVALID_KIND_CURSOR    = "cursor"
+	INVALID_KIND_GROUP     = "group"
+	INVALID_KIND_OPERAND   = "operand"
+	INVALID_KIND_FILTER    = "filter"
+)
+
+// 标识符最大长度，与mysql的表名和字段名的长度限制保持一致
+const MAX_IDENTIFIER_LENGTH = 64
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 汇总表达式仅允许使用以下聚合函数，参数只能是单个字段或*
+var summarizeRegexp = regexp.MustCompile(`(?i)^\s*(count|sum|avg|min|max)\s*\(\s*(distinct\s+)?(\*|[A-Za-z_][A-Za-z0-9_]*)\s*\)\s*$`)
+
+// 查询参数中的标识符、排序方式、汇总表达式等不合法时返回的错误
+// 可以通过errors.As判断错误类型，通过Kind区分具体不合法的内容
+type QueryValidationError struct {
+	Kind  string
+	Value string
+}
+
+func (e *QueryValidationError) Error() string {
+	return "invalid " + e.Kind + ": " + e.Value
+}
+
+// 检查标识符（库名、表名、字段名）是否合法
+func CheckIdentifier(kind string, identifier string) error {
+	if len(identifier) > MAX_IDENTIFIER_LENGTH || !identifierRegexp.MatchString(identifier) {
+		slog.Error("CheckIdentifier invalid identifier", "kind", kind, "identifier", identifier)
+		return &QueryValidationError{Kind: kind, Value: identifier}
+	}
+	return nil
+}
+
+// 使用默认的Dialect对标识符加引号，标识符中的引号会被转义
+func QuoteIdentifier(identifier string) string {
+	return DefaultDialect.QuoteIdentifier(identifier)
+}
+
+// 检查并对标识符加引号
+func CheckAndQuoteIdentifier(kind string, identifier string, dialect Dialect) (string, error) {
+	if err := CheckIdentifier(kind, identifier); err != nil {
+		return "", err
+	}
+	return getDialectOrDefault(dialect).QuoteIdentifier(identifier), nil
+}
+
+// 检查排序方式，仅允许asc和desc，不区分大小写，为空时使用数据库默认的排序方式
+func CheckSorterOrder(order string) (string, error) {
+	lowerOrder := strings.ToLower(strings.TrimSpace(order))
+	if lowerOrder != "" && lowerOrder != "asc" && lowerOrder != "desc" {
+		slog.Error("CheckSorterOrder invalid sorter order", "order", order)
+		return "", &QueryValidationError{Kind: INVALID_KIND_SORTER, Value: order}
+	}
+	return lowerOrder, nil
+}
+
+// 检查汇总表达式并返回规范化后的表达式，如sum(amount)返回sum(`amount`)
+func CheckSummarize(summarize string, dialect Dialect) (string, error) {
+	matches := summarizeRegexp.FindStringSubmatch(summarize)
+	if matches == nil {
+		slog.Error("CheckSummarize invalid summarize expression", "summarize", summarize)
+		return "", &QueryValidationError{Kind: INVALID_KIND_SUMMARIZE, Value: summarize}
+	}
+
+	function := strings.ToLower(matches[1])
+	argument := matches[3]
+	if argument == "*" {
+		//只有count函数允许使用*
+		if function != "count" || matches[2] != "" {
+			slog.Error("CheckSummarize invalid summarize expression", "summarize", summarize)
+			return "", &QueryValidationError{Kind: INVALID_KIND_SUMMARIZE, Value: summarize}
+		}
+		return function + "(*)", nil
+	}
+
+	distinct := ""
+	if matches[2] != "" {
+		distinct = "distinct "
+	}
+	return function + "(" + distinct + getDialectOrDefault(dialect).QuoteIdentifier(argument) + ")", nil
+}
@@ -0,0 +1,108 @@
+package crvorm
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+/*
+JSON字段的路径，格式为：字段名->JSON路径，如attrs->$.color、attrs->$.sizes[0]
+可以用于查询字段、过滤条件和排序字段
+查询字段中的JSON路径返回解码后的JSON值，查询结果中的键为完整的路径
+过滤条件和排序中的JSON路径按照文本值比较，mysql转换为->>，postgres转换为#>>
+*/
+
+const JSON_PATH_SEPARATOR = "->"
+
+// JSON路径仅允许$开头，后跟.key和[index]的组合，路径会直接写入语句中
+var jsonPathRegexp = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])*$`)
+
+var jsonPathSegmentRegexp = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)|\[([0-9]+)\]`)
+
+// 字段是否为JSON字段的路径
+func IsJSONPathField(field string) bool {
+	return strings.Contains(field, JSON_PATH_SEPARATOR)
+}
+
+// 拆分并检查JSON字段的路径，返回字段名和JSON路径
+func ParseJSONPathField(field string) (string, string, error) {
+	column, path, _ := strings.Cut(field, JSON_PATH_SEPARATOR)
+	if err := CheckIdentifier(INVALID_KIND_FIELD, column); err != nil {
+		return "", "", err
+	}
+	if !jsonPathRegexp.MatchString(path) {
+		slog.Error("ParseJSONPathField invalid json path", "field", field)
+		return "", "", &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: field}
+	}
+	return column, path, nil
+}
+
+// 检查字段名，字段名可以是普通的标识符或者JSON字段的路径
+func CheckFieldIdentifier(field string) error {
+	if IsJSONPathField(field) {
+		_, _, err := ParseJSONPathField(field)
+		return err
+	}
+	return CheckIdentifier(INVALID_KIND_FIELD, field)
+}
+
+// 获取字段在过滤条件和排序中使用的表达式，JSON字段的路径转换为取文本值的表达式
+func GetFieldExpression(field string, dialect Dialect) (string, error) {
+	dialect = getDialectOrDefault(dialect)
+	if IsJSONPathField(field) {
+		return getJSONPathExpression(field, true, dialect)
+	}
+	return CheckAndQuoteIdentifier(INVALID_KIND_FIELD, field, dialect)
+}
+
+func getJSONPathExpression(field string, text bool, dialect Dialect) (string, error) {
+	column, path, err := ParseJSONPathField(field)
+	if err != nil {
+		return "", err
+	}
+	return dialect.JSONExtract(dialect.QuoteIdentifier(column), path, text), nil
+}
+
+// 将$.a.b[0]格式的路径转换为postgres的{a,b,0}格式
+func getPostgresJSONPath(path string) string {
+	var segments []string
+	for _, match := range jsonPathSegmentRegexp.FindAllStringSubmatch(path, -1) {
+		if len(match[1]) > 0 {
+			segments = append(segments, match[1])
+		} else {
+			segments = append(segments, match[2])
+		}
+	}
+	return "{" + strings.Join(segments, ",") + "}"
+}
+
+// 查询结果中JSON字段路径的值是JSON文本，解码为对应的JSON值
+func decodeJSONFields(fields *[]Field, list []map[string]interface{}) {
+	if fields == nil {
+		return
+	}
+	for _, field := range *fields {
+		if !IsJSONPathField(field.Field) {
+			continue
+		}
+		for _, row := range list {
+			var data []byte
+			switch row[field.Field].(type) {
+			case string:
+				data = []byte(row[field.Field].(string))
+			case []byte:
+				data = row[field.Field].([]byte)
+			default:
+				continue
+			}
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				slog.Debug("decodeJSONFields value is not json, keep the original value", "field", field.Field, "error", err)
+				continue
+			}
+			row[field.Field] = value
+		}
+	}
+}
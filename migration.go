@@ -0,0 +1,438 @@
+package crvorm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+根据模型定义生成和执行数据库迁移
+生成迁移时将模型定义与数据库中现有的表结构比较，缺少的表生成create table语句，缺少的字段生成alter table语句
+多对多字段的中间表和文件字段使用的core_file表也会一起生成
+为了避免误删数据，数据库中多出的表和字段不做处理，字段类型的差异也不做处理
+迁移保存为版本号_名称.sql格式的文件，执行过的迁移记录在迁移历史表中，每个迁移只会执行一次
+*/
+
+// 保存已执行迁移的默认表名
+const MIGRATION_HISTORY_TABLE = "core_migration"
+
+// 迁移文件的扩展名，文件名格式为版本号_名称.sql，版本号按照字典序执行
+const MIGRATION_FILE_EXT = ".sql"
+
+// 生成迁移时使用的版本号格式
+const MIGRATION_VERSION_FORMAT = "20060102150405"
+
+// 没有指定数据类型的字段使用的类型
+const MIGRATION_DEFAULT_DATATYPE = "varchar(255)"
+
+// 文件字段关联的文件表
+const FILE_MODEL_ID = "core_file"
+
+type Migration struct {
+	Version    string
+	Name       string
+	Statements []string
+}
+
+// 文件字段使用的core_file表的模型定义，字段与QueryFile查询的字段一致
+func GetFileModelDefinition() *ModelDefinition {
+	return &ModelDefinition{
+		ModelId: FILE_MODEL_ID,
+		Fields: []ModelField{
+			{Field: "id"},
+			{Field: "model_id", DataType: "varchar(64)"},
+			{Field: "field_id", DataType: "varchar(64)"},
+			{Field: "row_id", DataType: "varchar(64)"},
+			{Field: "path", DataType: "varchar(1024)", Nullable: true},
+			{Field: "name", DataType: "varchar(255)", Nullable: true},
+			{Field: "ext", DataType: "varchar(32)", Nullable: true},
+			{Field: "create_time", DataType: "datetime", Nullable: true},
+			{Field: "create_user", DataType: "varchar(64)", Nullable: true},
+			{Field: "update_time", DataType: "datetime", Nullable: true},
+			{Field: "update_user", DataType: "varchar(64)", Nullable: true},
+			{Field: "version", DataType: "int", Nullable: true},
+		},
+	}
+}
+
+type Migrator struct {
+	Repo  DataRepository
+	AppDb string
+	//迁移历史表的表名，为空时使用MIGRATION_HISTORY_TABLE
+	HistoryTable string
+}
+
+func (migrator *Migrator) getHistoryTable() string {
+	if len(migrator.HistoryTable) == 0 {
+		return MIGRATION_HISTORY_TABLE
+	}
+	return migrator.HistoryTable
+}
+
+// 比较模型定义和数据库中的表结构，生成迁移，没有差异时返回nil
+func (migrator *Migrator) Generate(ctx context.Context, name string, models []*ModelDefinition) (*Migration, error) {
+	if err := CheckIdentifier(INVALID_KIND_FIELD, name); err != nil {
+		return nil, err
+	}
+	schema, err := ReadSchema(ctx, migrator.Repo, migrator.AppDb)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := getMigrationTables(models)
+	if err != nil {
+		return nil, err
+	}
+
+	ddl := getMigrationDDL(migrator.Repo.GetDialect())
+	var statements []string
+	for _, table := range tables {
+		existing := schema.GetTable(table.model.ModelId)
+		if existing == nil {
+			statements = append(statements, ddl.createTable(migrator.AppDb, table))
+			continue
+		}
+		for _, column := range table.columns {
+			if existing.GetColumn(column.field.Field) != nil {
+				continue
+			}
+			if column.field.Field == table.model.GetPrimaryKey() {
+				slog.Warn("Migrator.Generate can not add primary key to existing table", "table", table.model.ModelId, "field", column.field.Field)
+				continue
+			}
+			statements = append(statements, ddl.addColumn(migrator.AppDb, table.model.ModelId, column))
+		}
+	}
+
+	if len(statements) == 0 {
+		return nil, nil
+	}
+	return &Migration{
+		Version:    time.Now().UTC().Format(MIGRATION_VERSION_FORMAT),
+		Name:       name,
+		Statements: statements,
+	}, nil
+}
+
+// 创建迁移历史表
+func (migrator *Migrator) ensureHistoryTable(ctx context.Context) error {
+	if err := CheckIdentifier(INVALID_KIND_MODELID, migrator.getHistoryTable()); err != nil {
+		return err
+	}
+	dialect := migrator.Repo.GetDialect()
+	sql := "create table if not exists " + dialect.GetTableName(migrator.AppDb, migrator.getHistoryTable()) + " (" +
+		dialect.QuoteIdentifier("version") + " varchar(64) not null primary key," +
+		dialect.QuoteIdentifier("name") + " varchar(255)," +
+		dialect.QuoteIdentifier("applied_time") + " varchar(32))"
+	return migrator.exec(ctx, []string{sql}, nil)
+}
+
+// 获取已经执行过的迁移版本
+func (migrator *Migrator) AppliedVersions(ctx context.Context) (map[string]bool, error) {
+	if err := migrator.ensureHistoryTable(ctx); err != nil {
+		return nil, err
+	}
+	dialect := migrator.Repo.GetDialect()
+	rows, err := migrator.Repo.QueryContext(ctx, "select "+dialect.QuoteIdentifier("version")+
+		" from "+dialect.GetTableName(migrator.AppDb, migrator.getHistoryTable()))
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]bool{}
+	for _, row := range rows {
+		versions[getSchemaString(row["version"])] = true
+	}
+	return versions, nil
+}
+
+// 按照版本号顺序执行没有执行过的迁移，返回本次执行的版本号
+// 每个迁移的语句和迁移历史记录在同一个事务中执行，mysql的DDL语句会隐式提交事务，执行失败时需要手动处理已经执行的语句
+func (migrator *Migrator) Apply(ctx context.Context, migrations []*Migration) ([]string, error) {
+	applied, err := migrator.AppliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	dialect := migrator.Repo.GetDialect()
+	historySQL := "insert into " + dialect.GetTableName(migrator.AppDb, migrator.getHistoryTable()) + " (" +
+		dialect.QuoteIdentifier("version") + "," + dialect.QuoteIdentifier("name") + "," + dialect.QuoteIdentifier("applied_time") + ") values (?,?,?)"
+	versions := []string{}
+	for _, migration := range sorted {
+		if applied[migration.Version] {
+			continue
+		}
+		slog.Info("Migrator.Apply apply migration", "version", migration.Version, "name", migration.Name)
+		history := []interface{}{migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339)}
+		statements := append(append([]string{}, migration.Statements...), historySQL)
+		if err := migrator.exec(ctx, statements, history); err != nil {
+			slog.Error("Migrator.Apply apply migration failed", "version", migration.Version, "name", migration.Name, "error", err)
+			return versions, fmt.Errorf("apply migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		applied[migration.Version] = true
+		versions = append(versions, migration.Version)
+	}
+	return versions, nil
+}
+
+// 在同一个事务中执行语句，lastArgs为最后一个语句的参数
+func (migrator *Migrator) exec(ctx context.Context, statements []string, lastArgs []interface{}) error {
+	tx, err := migrator.Repo.BeginContext(ctx)
+	if err != nil {
+		return err
+	}
+	for index, statement := range statements {
+		var args []interface{}
+		if index == len(statements)-1 {
+			args = lastArgs
+		}
+		if _, _, err := migrator.Repo.ExecWithTxContext(ctx, statement, tx, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 将迁移写入目录下的版本号_名称.sql文件，返回文件路径
+func WriteMigrationFile(dir string, migration *Migration) (string, error) {
+	var builder strings.Builder
+	builder.WriteString("-- " + migration.Version + " " + migration.Name + "\n")
+	for _, statement := range migration.Statements {
+		builder.WriteString(statement + ";\n")
+	}
+	path := filepath.Join(dir, migration.Version+"_"+migration.Name+MIGRATION_FILE_EXT)
+	if err := os.WriteFile(path, []byte(builder.String()), 0644); err != nil {
+		slog.Error("WriteMigrationFile write file failed", "path", path, "error", err)
+		return "", err
+	}
+	return path, nil
+}
+
+// 加载目录下的迁移文件，按照版本号排序
+// 每个语句以行尾的分号结束，以--开头的行为注释
+func LoadMigrationFiles(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("LoadMigrationFiles read dir failed", "dir", dir, "error", err)
+		return nil, err
+	}
+
+	migrations := []*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), MIGRATION_FILE_EXT) {
+			continue
+		}
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), MIGRATION_FILE_EXT), "_")
+		if !ok || len(version) == 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			slog.Error("LoadMigrationFiles read file failed", "file", entry.Name(), "error", err)
+			return nil, err
+		}
+		migrations = append(migrations, &Migration{
+			Version:    version,
+			Name:       name,
+			Statements: splitMigrationStatements(string(data)),
+		})
+	}
+	sort.SliceStable(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func splitMigrationStatements(content string) []string {
+	statements := []string{}
+	var builder strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasPrefix(strings.TrimSpace(line), "--") || len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(line)
+		if strings.HasSuffix(line, ";") {
+			statements = append(statements, strings.TrimSuffix(builder.String(), ";"))
+			builder.Reset()
+		}
+	}
+	if len(strings.TrimSpace(builder.String())) > 0 {
+		statements = append(statements, builder.String())
+	}
+	return statements
+}
+
+// 迁移中的一个表，由模型定义或者多对多字段的中间表生成
+type migrationTable struct {
+	model   *ModelDefinition
+	columns []migrationColumn
+}
+
+type migrationColumn struct {
+	field ModelField
+	//没有指定数据类型的主键使用自增ID
+	autoIncrement bool
+	//没有指定数据类型的多对一字段和中间表字段引用自增ID时使用整数类型
+	referenceId bool
+}
+
+// 获取模型定义对应的表，按照模型ID排序，多对多字段生成中间表，存在文件字段时生成core_file表
+func getMigrationTables(models []*ModelDefinition) ([]*migrationTable, error) {
+	declared := map[string]*ModelDefinition{}
+	for _, model := range models {
+		if err := model.Validate(); err != nil {
+			return nil, err
+		}
+		declared[model.ModelId] = model
+	}
+
+	hasFile := false
+	associations := map[string]*ModelDefinition{}
+	for _, model := range models {
+		for _, field := range model.Fields {
+			switch field.FieldType {
+			case FIELDTYPE_FILE:
+				hasFile = true
+			case FIELDTYPE_MANY2MANY:
+				var associationModelId *string
+				if len(field.AssociationModelId) > 0 {
+					associationModelId = &field.AssociationModelId
+				}
+				modelId := GetRelatedModelId(model.ModelId, field.RelatedModelId, associationModelId)
+				if _, ok := declared[modelId]; ok {
+					continue
+				}
+				associations[modelId] = &ModelDefinition{
+					ModelId: modelId,
+					Fields: []ModelField{
+						{Field: CC_ID},
+						{Field: model.ModelId + "_id", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: model.ModelId},
+						{Field: field.RelatedModelId + "_id", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: field.RelatedModelId},
+					},
+				}
+			}
+		}
+	}
+	if _, ok := declared[FILE_MODEL_ID]; hasFile && !ok {
+		associations[FILE_MODEL_ID] = GetFileModelDefinition()
+	}
+	for modelId, model := range associations {
+		declared[modelId] = model
+	}
+
+	modelIds := make([]string, 0, len(declared))
+	for modelId := range declared {
+		modelIds = append(modelIds, modelId)
+	}
+	sort.Strings(modelIds)
+
+	tables := make([]*migrationTable, 0, len(modelIds))
+	for _, modelId := range modelIds {
+		model := declared[modelId]
+		table := &migrationTable{model: model}
+		for _, field := range model.Fields {
+			if field.FieldType == FIELDTYPE_ONE2MANY || field.FieldType == FIELDTYPE_MANY2MANY || field.FieldType == FIELDTYPE_FILE {
+				continue
+			}
+			column := migrationColumn{field: field}
+			if len(field.DataType) == 0 {
+				if field.Field == model.GetPrimaryKey() {
+					column.autoIncrement = true
+				} else if field.FieldType == FIELDTYPE_MANY2ONE {
+					column.field.DataType, column.referenceId = getReferenceDataType(declared[field.RelatedModelId])
+				}
+			}
+			table.columns = append(table.columns, column)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// 获取引用关联模型主键的字段类型，关联模型没有声明时使用默认类型
+func getReferenceDataType(related *ModelDefinition) (string, bool) {
+	if related == nil {
+		return MIGRATION_DEFAULT_DATATYPE, false
+	}
+	primaryKey := related.GetField(related.GetPrimaryKey())
+	if primaryKey == nil || len(primaryKey.DataType) == 0 {
+		return "", true
+	}
+	return primaryKey.DataType, false
+}
+
+// 不同数据库中自增主键和数据类型的差异
+type migrationDDL struct {
+	dialect       Dialect
+	autoIncrement string
+	referenceId   string
+	//需要转换的数据类型，按照小写的类型名称匹配
+	dataTypes map[string]string
+}
+
+func getMigrationDDL(dialect Dialect) *migrationDDL {
+	switch dialect.Name() {
+	case DIALECT_POSTGRES:
+		return &migrationDDL{dialect: dialect, autoIncrement: "bigserial primary key", referenceId: "bigint",
+			dataTypes: map[string]string{"datetime": "timestamp", "tinyint": "smallint", "double": "double precision", "json": "jsonb"}}
+	case DIALECT_SQLITE:
+		return &migrationDDL{dialect: dialect, autoIncrement: "integer primary key autoincrement", referenceId: "integer"}
+	}
+	return &migrationDDL{dialect: dialect, autoIncrement: "bigint not null auto_increment primary key", referenceId: "bigint"}
+}
+
+func (ddl *migrationDDL) getDataType(column migrationColumn) string {
+	if column.referenceId {
+		return ddl.referenceId
+	}
+	dataType := column.field.DataType
+	if len(dataType) == 0 {
+		return MIGRATION_DEFAULT_DATATYPE
+	}
+	if mapped, ok := ddl.dataTypes[strings.ToLower(dataType)]; ok {
+		return mapped
+	}
+	return dataType
+}
+
+func (ddl *migrationDDL) getColumnDefinition(column migrationColumn, nullable bool) string {
+	definition := ddl.dialect.QuoteIdentifier(column.field.Field) + " "
+	if column.autoIncrement {
+		return definition + ddl.autoIncrement
+	}
+	definition += ddl.getDataType(column)
+	if !nullable {
+		definition += " not null"
+	}
+	return definition
+}
+
+func (ddl *migrationDDL) createTable(appDb string, table *migrationTable) string {
+	definitions := make([]string, 0, len(table.columns)+1)
+	hasAutoIncrement := false
+	for _, column := range table.columns {
+		hasAutoIncrement = hasAutoIncrement || column.autoIncrement
+		nullable := column.field.Nullable && column.field.Field != table.model.GetPrimaryKey()
+		definitions = append(definitions, ddl.getColumnDefinition(column, nullable))
+	}
+	if !hasAutoIncrement {
+		definitions = append(definitions, "primary key ("+ddl.dialect.QuoteIdentifier(table.model.GetPrimaryKey())+")")
+	}
+	return "create table " + ddl.dialect.GetTableName(appDb, table.model.ModelId) + " (" + strings.Join(definitions, ",") + ")"
+}
+
+// 已有表中的数据没有新增字段的值，新增的字段都允许为空
+func (ddl *migrationDDL) addColumn(appDb string, modelId string, column migrationColumn) string {
+	return "alter table " + ddl.dialect.GetTableName(appDb, modelId) + " add column " + ddl.getColumnDefinition(column, true)
+}
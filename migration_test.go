@@ -0,0 +1,104 @@
+package crvorm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteMigration(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	if _, err := repo.DB.Exec(`drop table core_file`); err != nil {
+		t.Fatal(err)
+	}
+	models := []*ModelDefinition{
+		{ModelId: "order", Fields: []ModelField{{Field: "id", DataType: "text"}, {Field: "name", DataType: "text"}, {Field: "remark", Nullable: true}}},
+		{ModelId: "invoice", Fields: []ModelField{
+			{Field: "id"},
+			{Field: "title", DataType: "varchar(64)"},
+			{Field: "order_id", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "order", Nullable: true},
+			{Field: "customer", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "customer", Nullable: true},
+			{Field: "currency", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "currency", Nullable: true},
+			{Field: "tags", FieldType: FIELDTYPE_MANY2MANY, RelatedModelId: "tag"},
+			{Field: "files", FieldType: FIELDTYPE_FILE},
+		}},
+		{ModelId: "currency", PrimaryKey: "code", Fields: []ModelField{{Field: "code", DataType: "varchar(8)"}, {Field: "name", DataType: "text", Nullable: true}}},
+		{ModelId: "invoice_line", Fields: []ModelField{{Field: "id"}, {Field: "invoice_id", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "invoice"}}},
+	}
+
+	migrator := &Migrator{Repo: repo, AppDb: "main"}
+	migration, err := migrator.Generate(context.Background(), "init", models)
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	expected := []string{
+		`create table "main"."core_file" ("id" integer primary key autoincrement,"model_id" varchar(64) not null,"field_id" varchar(64) not null,"row_id" varchar(64) not null,"path" varchar(1024),"name" varchar(255),"ext" varchar(32),"create_time" datetime,"create_user" varchar(64),"update_time" datetime,"update_user" varchar(64),"version" int)`,
+		`create table "main"."currency" ("code" varchar(8) not null,"name" text,primary key ("code"))`,
+		`create table "main"."invoice" ("id" integer primary key autoincrement,"title" varchar(64) not null,"order_id" text,"customer" varchar(255),"currency" varchar(8))`,
+		`create table "main"."invoice_line" ("id" integer primary key autoincrement,"invoice_id" integer not null)`,
+		`create table "main"."invoice_tag" ("id" integer primary key autoincrement,"invoice_id" integer not null,"tag_id" varchar(255) not null)`,
+		`alter table "main"."order" add column "remark" varchar(255)`,
+	}
+	if migration == nil || !reflect.DeepEqual(migration.Statements, expected) {
+		t.Fatalf("unexpected migration %#v", migration)
+	}
+
+	dir := t.TempDir()
+	path, err := WriteMigrationFile(dir, migration)
+	if err != nil {
+		t.Fatalf("WriteMigrationFile failed: %v", err)
+	}
+	if filepath.Base(path) != migration.Version+"_init.sql" {
+		t.Errorf("unexpected migration file %s", path)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000_seed.sql"), []byte("-- seed\ncreate table seed (\n  id integer primary key\n);\ninsert into seed (id) values (1);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := LoadMigrationFiles(dir)
+	if err != nil {
+		t.Fatalf("LoadMigrationFiles failed: %v", err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "seed" || len(migrations[0].Statements) != 2 || !reflect.DeepEqual(migrations[1].Statements, expected) {
+		t.Fatalf("unexpected migrations %#v", migrations)
+	}
+
+	applied, err := migrator.Apply(context.Background(), migrations)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if !reflect.DeepEqual(applied, []string{"00000000000000", migration.Version}) {
+		t.Errorf("unexpected applied versions %v", applied)
+	}
+	applied, err = migrator.Apply(context.Background(), migrations)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("migrations should be applied only once, got %v %v", applied, err)
+	}
+	if migration, err := migrator.Generate(context.Background(), "noop", models); err != nil || migration != nil {
+		t.Errorf("expected no changes after migration, got %#v %v", migration, err)
+	}
+
+	//执行失败的迁移整体回滚，不记录到迁移历史
+	_, err = migrator.Apply(context.Background(), []*Migration{{Version: "99999999999999", Name: "bad", Statements: []string{`create table bad (id integer)`, `alter table missing add column x text`}}})
+	if err == nil {
+		t.Fatalf("expected error for invalid migration")
+	}
+	versions, err := migrator.AppliedVersions(context.Background())
+	if err != nil || versions["99999999999999"] || len(versions) != 2 {
+		t.Errorf("unexpected applied versions %v %v", versions, err)
+	}
+
+	_, err = ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "invoice",
+		Fields:  &[]Field{{Field: "tags", FieldType: &models[1].Fields[5].FieldType, RelatedModelId: &models[1].Fields[5].RelatedModelId}},
+		List: []map[string]interface{}{{
+			SAVE_TYPE_COLUMN: SAVE_CREATE, "title": "invoice1",
+			"tags": map[string]interface{}{"list": []interface{}{map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t1"}}},
+		}},
+	}, repo)
+	if err != nil {
+		t.Fatalf("save invoice failed: %v", err)
+	}
+}
@@ -0,0 +1,554 @@
+package crvorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+模型定义的注册表，模型定义包含字段、数据类型、关联关系和主键
+查询时可以根据模型定义补全查询字段上的关联信息并检查字段是否存在，客户端只需要给出字段名和子字段
+模型定义可以从JSON或YAML文件加载，文件内容可以是单个模型定义或模型定义的数组
+也可以从数据库中的模型定义表加载，表中每行的definition字段保存一个JSON格式的模型定义
+*/
+
+// 数据库中保存模型定义的默认表名，表中包含model_id和definition两个字段
+const MODEL_DEFINITION_TABLE = "core_model"
+
+// 模型字段的定义，关联字段需要给出FieldType和RelatedModelId，一对多字段还需要给出RelatedField
+type ModelField struct {
+	Field              string `json:"field" yaml:"field"`
+	DataType           string `json:"dataType,omitempty" yaml:"dataType,omitempty"`
+	FieldType          string `json:"fieldType,omitempty" yaml:"fieldType,omitempty"`
+	RelatedModelId     string `json:"relatedModelId,omitempty" yaml:"relatedModelId,omitempty"`
+	RelatedField       string `json:"relatedField,omitempty" yaml:"relatedField,omitempty"`
+	AssociationModelId string `json:"associationModelId,omitempty" yaml:"associationModelId,omitempty"`
+	Nullable           bool   `json:"nullable,omitempty" yaml:"nullable,omitempty"`
+}
+
+// 模型定义，PrimaryKey为空时使用id
+type ModelDefinition struct {
+	ModelId    string       `json:"modelId" yaml:"modelId"`
+	PrimaryKey string       `json:"primaryKey,omitempty" yaml:"primaryKey,omitempty"`
+	Fields     []ModelField `json:"fields" yaml:"fields"`
+}
+
+// 模型定义不合法时返回的错误
+type ModelDefinitionError struct {
+	ModelId string
+	Field   string
+	Message string
+}
+
+func (e *ModelDefinitionError) Error() string {
+	if len(e.Field) > 0 {
+		return "invalid model definition " + e.ModelId + "." + e.Field + ": " + e.Message
+	}
+	return "invalid model definition " + e.ModelId + ": " + e.Message
+}
+
+func newModelDefinitionError(modelId string, field string, message string) error {
+	slog.Error("invalid model definition", "modelId", modelId, "field", field, "message", message)
+	return &ModelDefinitionError{ModelId: modelId, Field: field, Message: message}
+}
+
+// 获取模型的主键字段名
+func (model *ModelDefinition) GetPrimaryKey() string {
+	if len(model.PrimaryKey) == 0 {
+		return CC_ID
+	}
+	return model.PrimaryKey
+}
+
+// 根据字段名获取字段定义
+func (model *ModelDefinition) GetField(fieldName string) *ModelField {
+	for index := range model.Fields {
+		if model.Fields[index].Field == fieldName {
+			return &model.Fields[index]
+		}
+	}
+	return nil
+}
+
+// 检查模型定义本身是否合法，不检查关联模型是否存在
+func (model *ModelDefinition) Validate() error {
+	if err := CheckIdentifier(INVALID_KIND_MODELID, model.ModelId); err != nil {
+		return newModelDefinitionError(model.ModelId, "", "invalid model id")
+	}
+
+	names := map[string]bool{}
+	for _, field := range model.Fields {
+		if err := CheckIdentifier(INVALID_KIND_FIELD, field.Field); err != nil {
+			return newModelDefinitionError(model.ModelId, field.Field, "invalid field name")
+		}
+		if names[field.Field] {
+			return newModelDefinitionError(model.ModelId, field.Field, "duplicate field")
+		}
+		names[field.Field] = true
+
+		switch field.FieldType {
+		case "", FIELDTYPE_FILE:
+		case FIELDTYPE_MANY2ONE, FIELDTYPE_MANY2MANY, FIELDTYPE_ONE2MANY:
+			if err := CheckIdentifier(INVALID_KIND_MODELID, field.RelatedModelId); err != nil {
+				return newModelDefinitionError(model.ModelId, field.Field, "relation field must have a valid relatedModelId")
+			}
+			if field.FieldType == FIELDTYPE_ONE2MANY {
+				if err := CheckIdentifier(INVALID_KIND_FIELD, field.RelatedField); err != nil {
+					return newModelDefinitionError(model.ModelId, field.Field, "one2many field must have a valid relatedField")
+				}
+			}
+			if len(field.AssociationModelId) > 0 {
+				if err := CheckIdentifier(INVALID_KIND_MODELID, field.AssociationModelId); err != nil {
+					return newModelDefinitionError(model.ModelId, field.Field, "invalid associationModelId")
+				}
+			}
+		default:
+			return newModelDefinitionError(model.ModelId, field.Field, "not supported field type "+field.FieldType)
+		}
+	}
+
+	if !names[model.GetPrimaryKey()] {
+		return newModelDefinitionError(model.ModelId, model.GetPrimaryKey(), "primary key is not defined in fields")
+	}
+	return nil
+}
+
+type ModelRegistry struct {
+	mutex  sync.RWMutex
+	models map[string]*ModelDefinition
+}
+
+func NewModelRegistry() *ModelRegistry {
+	return &ModelRegistry{models: map[string]*ModelDefinition{}}
+}
+
+// 注册模型定义，相同模型ID的定义会被替换
+func (registry *ModelRegistry) Register(models ...*ModelDefinition) error {
+	for _, model := range models {
+		if err := model.Validate(); err != nil {
+			return err
+		}
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	if registry.models == nil {
+		registry.models = map[string]*ModelDefinition{}
+	}
+	for _, model := range models {
+		registry.models[model.ModelId] = model
+	}
+	return nil
+}
+
+func (registry *ModelRegistry) GetModel(modelId string) (*ModelDefinition, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	model, ok := registry.models[modelId]
+	return model, ok
+}
+
+// 获取全部模型定义，按照模型ID排序
+func (registry *ModelRegistry) Models() []*ModelDefinition {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+	models := make([]*ModelDefinition, 0, len(registry.models))
+	for _, model := range registry.models {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ModelId < models[j].ModelId })
+	return models
+}
+
+// 检查所有模型的关联模型和一对多的关联字段是否已经注册
+func (registry *ModelRegistry) Validate() error {
+	for _, model := range registry.Models() {
+		for _, field := range model.Fields {
+			if len(field.RelatedModelId) == 0 {
+				continue
+			}
+			related, ok := registry.GetModel(field.RelatedModelId)
+			if !ok {
+				return newModelDefinitionError(model.ModelId, field.Field, "related model "+field.RelatedModelId+" is not registered")
+			}
+			if field.FieldType == FIELDTYPE_ONE2MANY && related.GetField(field.RelatedField) == nil {
+				return newModelDefinitionError(model.ModelId, field.Field, "related field "+field.RelatedField+" is not defined in "+field.RelatedModelId)
+			}
+		}
+	}
+	return nil
+}
+
+// 关联数据按照id关联，多对一和多对多字段的关联模型、一对多和多对多字段所在模型的主键必须是id
+func checkRelationPrimaryKey(model *ModelDefinition, field *ModelField, related *ModelDefinition) string {
+	if field.FieldType != FIELDTYPE_ONE2MANY && related != nil && related.GetPrimaryKey() != CC_ID {
+		return "primary key of related model " + related.ModelId + " must be " + CC_ID
+	}
+	if field.FieldType != FIELDTYPE_MANY2ONE && model.GetPrimaryKey() != CC_ID {
+		return "primary key of model with " + field.FieldType + " field must be " + CC_ID
+	}
+	return ""
+}
+
+// 获取模型的主键字段名，模型没有注册时使用id
+func (registry *ModelRegistry) getPrimaryKey(modelId string) string {
+	if model, ok := registry.GetModel(modelId); ok {
+		return model.GetPrimaryKey()
+	}
+	return CC_ID
+}
+
+// 从JSON或YAML文件加载模型定义，根据扩展名判断文件格式
+func (registry *ModelRegistry) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Error("LoadFile read model definition file failed", "path", path, "error", err)
+		return err
+	}
+
+	var models []*ModelDefinition
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		models, err = parseModelDefinitions(data, json.Unmarshal)
+	case ".yaml", ".yml":
+		models, err = parseModelDefinitions(data, yaml.Unmarshal)
+	default:
+		return fmt.Errorf("not supported model definition file %s", path)
+	}
+	if err != nil {
+		slog.Error("LoadFile parse model definition file failed", "path", path, "error", err)
+		return fmt.Errorf("parse model definition file %s failed: %w", path, err)
+	}
+	return registry.Register(models...)
+}
+
+// 加载目录下所有的JSON和YAML文件
+func (registry *ModelRegistry) LoadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("LoadDir read model definition dir failed", "dir", dir, "error", err)
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+			if err := registry.LoadFile(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 文件内容可以是模型定义的数组或单个模型定义
+func parseModelDefinitions(data []byte, unmarshal func([]byte, interface{}) error) ([]*ModelDefinition, error) {
+	var models []*ModelDefinition
+	if err := unmarshal(data, &models); err == nil {
+		return models, nil
+	}
+	model := &ModelDefinition{}
+	if err := unmarshal(data, model); err != nil {
+		return nil, err
+	}
+	return []*ModelDefinition{model}, nil
+}
+
+// 从数据库的模型定义表加载模型定义，table为空时使用MODEL_DEFINITION_TABLE
+func (registry *ModelRegistry) LoadFromRepository(ctx context.Context, repo DataRepository, appDb string, table string) error {
+	if len(table) == 0 {
+		table = MODEL_DEFINITION_TABLE
+	}
+	if len(appDb) > 0 {
+		if err := CheckIdentifier(INVALID_KIND_APPDB, appDb); err != nil {
+			return err
+		}
+	}
+	if err := CheckIdentifier(INVALID_KIND_MODELID, table); err != nil {
+		return err
+	}
+
+	dialect := repo.GetDialect()
+	sql := "select " + dialect.QuoteIdentifier("model_id") + "," + dialect.QuoteIdentifier("definition") +
+		" from " + dialect.GetTableName(appDb, table)
+	rows, err := repo.QueryContext(ctx, sql)
+	if err != nil {
+		return err
+	}
+
+	var models []*ModelDefinition
+	for _, row := range rows {
+		var data []byte
+		switch row["definition"].(type) {
+		case string:
+			data = []byte(row["definition"].(string))
+		default:
+			//JSON类型的字段在查询结果中已经解码
+			data, err = json.Marshal(row["definition"])
+			if err != nil {
+				return err
+			}
+		}
+		model := &ModelDefinition{}
+		if err := json.Unmarshal(data, model); err != nil {
+			slog.Error("LoadFromRepository parse model definition failed", "modelId", row["model_id"], "error", err)
+			return fmt.Errorf("parse model definition %v failed: %w", row["model_id"], err)
+		}
+		if len(model.ModelId) == 0 {
+			model.ModelId = fmt.Sprint(row["model_id"])
+		}
+		models = append(models, model)
+	}
+	return registry.Register(models...)
+}
+
+// 根据模型定义补全查询字段的关联信息，返回补全后的查询参数，不修改原来的查询参数
+// 过滤条件和排序中的关联路径（如customer.name）按照模型定义查找关联字段，关联字段不需要出现在查询字段中
+// 模型没有注册时不做处理
+func (registry *ModelRegistry) ResolveQuery(query *QueryParam) (*QueryParam, error) {
+	if query == nil {
+		return query, nil
+	}
+	if _, ok := registry.GetModel(query.ModelId); !ok {
+		return query, nil
+	}
+	fields, err := registry.ResolveFields(query.ModelId, query.Fields)
+	if err != nil {
+		return nil, err
+	}
+	relationFields, err := registry.resolveRelationPaths(query.ModelId, getQueryRelationPaths(query))
+	if err != nil {
+		return nil, err
+	}
+	resolved := *query
+	resolved.Fields = fields
+	resolved.relationFields = relationFields
+	return &resolved, nil
+}
+
+// 关联路径，Relations为经过的关联字段，Field为最后一个关联模型上的字段，只检查关联字段时为空
+type relationPath struct {
+	Relations []string
+	Field     string
+}
+
+// 获取查询的过滤条件和排序中的关联路径，过滤条件格式错误时在生成SQL时返回错误
+func getQueryRelationPaths(query *QueryParam) []relationPath {
+	var paths []relationPath
+	if node, err := ParseFilter(query.Filter); err == nil {
+		paths = addFilterRelationPaths(paths, node, nil)
+	}
+	if query.Sorter != nil {
+		for _, sorter := range *query.Sorter {
+			if IsJSONPathField(sorter.Field) || !strings.Contains(sorter.Field, ".") {
+				continue
+			}
+			segments := strings.Split(sorter.Field, ".")
+			paths = append(paths, relationPath{Relations: segments[:len(segments)-1], Field: segments[len(segments)-1]})
+		}
+	}
+	return paths
+}
+
+func addFilterRelationPaths(paths []relationPath, node FilterNode, relations []string) []relationPath {
+	var fields []string
+	switch node := node.(type) {
+	case *AndNode:
+		for _, child := range node.Children {
+			paths = addFilterRelationPaths(paths, child, relations)
+		}
+	case *OrNode:
+		for _, child := range node.Children {
+			paths = addFilterRelationPaths(paths, child, relations)
+		}
+	case *NotNode:
+		paths = addFilterRelationPaths(paths, node.Child, relations)
+	case *ExistsNode:
+		nested := append(append([]string{}, relations...), node.Field)
+		paths = append(paths, relationPath{Relations: nested})
+		paths = addFilterRelationPaths(paths, node.Filter, nested)
+	case *CompareNode:
+		fields = []string{node.Field}
+	case *InNode:
+		fields = []string{node.Field}
+	case *NullNode:
+		fields = []string{node.Field}
+	case *MatchNode:
+		fields = node.Fields
+	}
+	//本模型上的字段在生成SQL时检查
+	if len(relations) == 0 {
+		return paths
+	}
+	for _, field := range fields {
+		paths = append(paths, relationPath{Relations: relations, Field: field})
+	}
+	return paths
+}
+
+// 根据模型定义生成关联路径经过的关联字段，关联模型上的关联字段放在关联字段的Fields中
+// 路径中的字段没有在模型中定义或者不是关联字段时返回错误，关联模型没有注册时不再检查后续的路径
+func (registry *ModelRegistry) resolveRelationPaths(modelId string, paths []relationPath) (*[]Field, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	relationFields := &[]Field{}
+	for _, path := range paths {
+		fields := relationFields
+		currentModelId := modelId
+		resolved := true
+		for _, relation := range path.Relations {
+			model, ok := registry.GetModel(currentModelId)
+			if !ok {
+				resolved = false
+				break
+			}
+			modelField := model.GetField(relation)
+			if modelField == nil || len(modelField.RelatedModelId) == 0 {
+				slog.Error("ResolveQuery relation path field is not a relation field", "modelId", currentModelId, "field", relation)
+				return nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: currentModelId + "." + relation}
+			}
+			related, _ := registry.GetModel(modelField.RelatedModelId)
+			if message := checkRelationPrimaryKey(model, modelField, related); len(message) > 0 {
+				slog.Error("ResolveQuery relation path field can not be resolved", "modelId", currentModelId, "field", relation, "message", message)
+				return nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: currentModelId + "." + relation + " " + message}
+			}
+			fields = getRelationPathField(fields, modelField).Fields
+			currentModelId = modelField.RelatedModelId
+		}
+
+		fieldName := path.Field
+		if IsJSONPathField(fieldName) {
+			fieldName, _, _ = strings.Cut(fieldName, JSON_PATH_SEPARATOR)
+		}
+		if model, ok := registry.GetModel(currentModelId); ok && resolved && len(fieldName) > 0 && model.GetField(fieldName) == nil {
+			slog.Error("ResolveQuery relation path field is not defined in model", "modelId", currentModelId, "field", path.Field)
+			return nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: currentModelId + "." + path.Field}
+		}
+	}
+	return relationFields, nil
+}
+
+// 查找或者添加关联字段，返回字段列表中的关联字段
+func getRelationPathField(fields *[]Field, modelField *ModelField) *Field {
+	for index := range *fields {
+		if (*fields)[index].Field == modelField.Field {
+			return &(*fields)[index]
+		}
+	}
+	field := Field{Field: modelField.Field, Fields: &[]Field{}}
+	field.FieldType = getResolvedValue(nil, modelField.FieldType)
+	field.RelatedModelId = getResolvedValue(nil, modelField.RelatedModelId)
+	field.RelatedField = getResolvedValue(nil, modelField.RelatedField)
+	field.AssociationModelId = getResolvedValue(nil, modelField.AssociationModelId)
+	*fields = append(*fields, field)
+	return &(*fields)[len(*fields)-1]
+}
+
+// 根据模型定义补全字段的类型和关联信息，并检查字段是否在模型中定义
+// 查询中给出的关联信息与模型定义不一致时返回错误
+// 多对一字段没有子字段时作为普通字段返回关联数据的ID，其它关联字段没有子字段时只查询关联数据的ID
+func (registry *ModelRegistry) ResolveFields(modelId string, fields *[]Field) (*[]Field, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	model, ok := registry.GetModel(modelId)
+	if !ok {
+		return fields, nil
+	}
+
+	resolved := make([]Field, len(*fields))
+	for index, field := range *fields {
+		modelFieldName := field.Field
+		if IsJSONPathField(modelFieldName) {
+			modelFieldName, _, _ = strings.Cut(modelFieldName, JSON_PATH_SEPARATOR)
+		}
+		modelField := model.GetField(modelFieldName)
+		if modelField == nil {
+			slog.Error("ResolveFields field is not defined in model", "modelId", modelId, "field", field.Field)
+			return nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: modelId + "." + field.Field}
+		}
+
+		if modelFieldName != field.Field {
+			resolved[index] = field
+			continue
+		}
+		if err := resolveField(modelId, &field, modelField); err != nil {
+			return nil, err
+		}
+		if field.FieldType != nil && field.RelatedModelId != nil {
+			related, _ := registry.GetModel(*field.RelatedModelId)
+			if message := checkRelationPrimaryKey(model, modelField, related); len(message) > 0 {
+				slog.Error("ResolveFields relation field can not be resolved", "modelId", modelId, "field", field.Field, "message", message)
+				return nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: modelId + "." + field.Field + " " + message}
+			}
+			var err error
+			field.Fields, err = registry.ResolveFields(*field.RelatedModelId, field.Fields)
+			if err != nil {
+				return nil, err
+			}
+			field.Fields = appendMissingField(field.Fields, registry.getPrimaryKey(*field.RelatedModelId))
+			if *field.FieldType == FIELDTYPE_ONE2MANY && field.RelatedField != nil {
+				field.Fields = appendMissingField(field.Fields, *field.RelatedField)
+			}
+		}
+		resolved[index] = field
+	}
+	return &resolved, nil
+}
+
+func resolveField(modelId string, field *Field, modelField *ModelField) error {
+	if err := checkResolvedValue(modelId, field.Field, "fieldType", field.FieldType, modelField.FieldType); err != nil {
+		return err
+	}
+	if err := checkResolvedValue(modelId, field.Field, "relatedModelId", field.RelatedModelId, modelField.RelatedModelId); err != nil {
+		return err
+	}
+	if err := checkResolvedValue(modelId, field.Field, "relatedField", field.RelatedField, modelField.RelatedField); err != nil {
+		return err
+	}
+
+	field.DataType = getResolvedValue(field.DataType, modelField.DataType)
+	if len(modelField.FieldType) == 0 {
+		return nil
+	}
+	//多对一字段没有子字段时作为普通字段
+	if modelField.FieldType == FIELDTYPE_MANY2ONE && field.Fields == nil {
+		return nil
+	}
+	//没有子字段时只查询关联数据的主键，主键字段在补全子字段后添加
+	if field.Fields == nil {
+		field.Fields = &[]Field{}
+	}
+	field.FieldType = getResolvedValue(field.FieldType, modelField.FieldType)
+	field.RelatedModelId = getResolvedValue(field.RelatedModelId, modelField.RelatedModelId)
+	field.RelatedField = getResolvedValue(field.RelatedField, modelField.RelatedField)
+	field.AssociationModelId = getResolvedValue(field.AssociationModelId, modelField.AssociationModelId)
+	return nil
+}
+
+func checkResolvedValue(modelId string, fieldName string, name string, value *string, defined string) error {
+	if value != nil && len(defined) > 0 && *value != defined {
+		slog.Error("ResolveFields field does not match model definition", "modelId", modelId, "field", fieldName, name, *value, "defined", defined)
+		return &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: modelId + "." + fieldName + " " + name + " " + *value}
+	}
+	return nil
+}
+
+func getResolvedValue(value *string, defined string) *string {
+	if value != nil || len(defined) == 0 {
+		return value
+	}
+	return &defined
+}
@@ -0,0 +1,232 @@
+package crvorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testOrderModelYAML = `
+- modelId: order
+  fields:
+    - field: id
+    - field: name
+      dataType: varchar(64)
+    - field: customer
+      fieldType: many2one
+      relatedModelId: customer
+    - field: lines
+      fieldType: one2many
+      relatedModelId: order_line
+      relatedField: order_id
+    - field: tags
+      fieldType: many2many
+      relatedModelId: tag
+    - field: attrs
+      dataType: json
+- modelId: order_line
+  fields:
+    - field: id
+    - field: order_id
+    - field: product
+`
+
+const testCustomerModelJSON = `{"modelId":"customer","fields":[{"field":"id"},{"field":"name"},{"field":"region","fieldType":"many2one","relatedModelId":"region"}]}`
+
+func newTestModelRegistry(t *testing.T) *ModelRegistry {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "order.yaml"), []byte(testOrderModelYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "customer.json"), []byte(testCustomerModelJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("ignored"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewModelRegistry()
+	if err := registry.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir failed: %v", err)
+	}
+	return registry
+}
+
+func TestModelRegistryResolveFields(t *testing.T) {
+	registry := newTestModelRegistry(t)
+	if len(registry.Models()) != 3 {
+		t.Fatalf("unexpected models %v", registry.Models())
+	}
+	if err := registry.Validate(); err == nil {
+		t.Errorf("expected error for unregistered related models")
+	}
+
+	query := &QueryParam{
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "customer", Fields: &[]Field{{Field: "name"}, {Field: "region"}}},
+			{Field: "lines", Fields: &[]Field{{Field: "product"}}},
+			{Field: "tags"},
+			{Field: "attrs->$.color"},
+		},
+	}
+	resolved, err := registry.ResolveQuery(query)
+	if err != nil {
+		t.Fatalf("ResolveQuery failed: %v", err)
+	}
+	data, _ := json.Marshal(resolved.Fields)
+	expected := `[{"field":"id"},` +
+		`{"field":"customer","fieldType":"many2one","relatedModelId":"customer","fields":[{"field":"name"},{"field":"region"},{"field":"id"}]},` +
+		`{"field":"lines","fieldType":"one2many","relatedModelId":"order_line","relatedField":"order_id","fields":[{"field":"product"},{"field":"id"},{"field":"order_id"}]},` +
+		`{"field":"tags","fieldType":"many2many","relatedModelId":"tag","fields":[{"field":"id"}]},` +
+		`{"field":"attrs-\u003e$.color"}]`
+	if string(data) != expected {
+		t.Errorf("unexpected resolved fields %s", data)
+	}
+	if (*query.Fields)[1].FieldType != nil {
+		t.Errorf("ResolveQuery should not modify the original query")
+	}
+
+	invalidFields := []*[]Field{
+		{{Field: "unknown"}},
+		{{Field: "customer", Fields: &[]Field{{Field: "unknown"}}}},
+		{{Field: "customer", RelatedModelId: &query.ModelId, Fields: &[]Field{{Field: "id"}}}},
+	}
+	for _, fields := range invalidFields {
+		_, err := registry.ResolveFields("order", fields)
+		var validationErr *QueryValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%v: expected validation error, got %v", fields, err)
+		}
+	}
+
+	err = registry.Register(&ModelDefinition{ModelId: "bad", Fields: []ModelField{{Field: "id"}, {Field: "lines", FieldType: FIELDTYPE_ONE2MANY, RelatedModelId: "line"}}})
+	var definitionErr *ModelDefinitionError
+	if !errors.As(err, &definitionErr) || definitionErr.Field != "lines" {
+		t.Errorf("expected definition error for one2many without relatedField, got %v", err)
+	}
+	err = registry.Register(&ModelDefinition{ModelId: "no_id", Fields: []ModelField{{Field: "code"}}})
+	if !errors.As(err, &definitionErr) || definitionErr.Field != CC_ID {
+		t.Errorf("expected definition error for model without id, got %v", err)
+	}
+	err = registry.Register(&ModelDefinition{ModelId: "no_key", PrimaryKey: "code", Fields: []ModelField{{Field: "id"}}})
+	if !errors.As(err, &definitionErr) || definitionErr.Field != "code" {
+		t.Errorf("expected definition error for model without primary key field, got %v", err)
+	}
+
+	//关联模型的子字段补全关联模型的主键，多对一字段只能关联主键为id的模型
+	err = registry.Register(
+		&ModelDefinition{ModelId: "country", Fields: []ModelField{{Field: "id"}, {Field: "currencies", FieldType: FIELDTYPE_ONE2MANY, RelatedModelId: "currency", RelatedField: "country_id"}}},
+		&ModelDefinition{ModelId: "currency", PrimaryKey: "code", Fields: []ModelField{{Field: "code"}, {Field: "name"}, {Field: "country_id", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "country"}}},
+		&ModelDefinition{ModelId: "price", Fields: []ModelField{{Field: "id"}, {Field: "currency", FieldType: FIELDTYPE_MANY2ONE, RelatedModelId: "currency"}}},
+	)
+	if err != nil {
+		t.Fatalf("Register models with primary key failed: %v", err)
+	}
+	countryFields, err := registry.ResolveFields("country", &[]Field{{Field: "currencies", Fields: &[]Field{{Field: "name"}}}})
+	if err != nil {
+		t.Fatalf("ResolveFields failed: %v", err)
+	}
+	if data, _ := json.Marshal((*countryFields)[0].Fields); string(data) != `[{"field":"name"},{"field":"code"},{"field":"country_id"}]` {
+		t.Errorf("unexpected resolved currency fields %s", data)
+	}
+	_, err = registry.ResolveFields("price", &[]Field{{Field: "currency", Fields: &[]Field{{Field: "name"}}}})
+	var validationErr *QueryValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("expected validation error for many2one to model with other primary key, got %v", err)
+	}
+}
+
+func TestSQLiteModelRegistryQuery(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`create table core_model (model_id text primary key, definition text)`,
+		`insert into region (id, name) values ('r1','east')`,
+		`insert into customer (id, name, region) values ('c1','customer1','r1')`,
+		`insert into "order" (id, name, customer) values ('o1','order1','c1'),('o2','order2',null)`,
+		`insert into order_line (id, order_id, product) values ('l1','o1','p1')`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("prepare failed: %v", err)
+		}
+	}
+	//definition中没有modelId时使用model_id字段的值
+	models := [][]interface{}{{"region", `{"fields":[{"field":"id"},{"field":"name"}]}`}, {"customer", testCustomerModelJSON}}
+	for _, model := range models {
+		if _, err := repo.DB.Exec(`insert into core_model (model_id, definition) values (?,?)`, model...); err != nil {
+			t.Fatalf("insert model failed: %v", err)
+		}
+	}
+
+	registry := newTestModelRegistry(t)
+	if err := registry.LoadFromRepository(context.Background(), repo, "main", ""); err != nil {
+		t.Fatalf("LoadFromRepository failed: %v", err)
+	}
+	if _, ok := registry.GetModel("region"); !ok {
+		t.Fatalf("region model should be loaded from database")
+	}
+
+	orm := &CrvOrm{Repo: repo, Registry: registry}
+	res, err := orm.ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "customer", Fields: &[]Field{{Field: "name"}, {Field: "region", Fields: &[]Field{{Field: "name"}}}}},
+			{Field: "lines", Fields: &[]Field{{Field: "product"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	customer := res.List[0]["customer"].(*QueryResult)
+	region := customer.List[0]["region"].(*QueryResult)
+	lines := res.List[0]["lines"].(*QueryResult)
+	if customer.List[0]["name"] != "customer1" || region.List[0]["name"] != "east" || lines.Total != 1 {
+		t.Errorf("unexpected order %v", res.List[0])
+	}
+	if res.List[1]["customer"] != nil {
+		t.Errorf("unexpected customer of order without customer %v", res.List[1]["customer"])
+	}
+
+	//过滤条件和排序中的关联路径按照模型定义查找关联字段，关联字段不需要出现在查询字段中
+	filters := []map[string]interface{}{
+		{"customer.name": "customer1"},
+		{"lines": map[string]interface{}{Op_exists: map[string]interface{}{"product": "p1"}}},
+		{"customer.region": map[string]interface{}{Op_exists: map[string]interface{}{"name": "east"}}},
+	}
+	for _, filter := range filters {
+		res, err := orm.ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Filter: &filter, Fields: &[]Field{{Field: "id"}}})
+		if err != nil {
+			t.Errorf("%v: ExecuteQuery failed: %v", filter, err)
+			continue
+		}
+		if res.Total != 1 || res.List[0]["id"] != "o1" {
+			t.Errorf("%v: unexpected result %v", filter, res.List)
+		}
+	}
+	res, err = orm.ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Sorter: &[]Sorter{{Field: "customer.name", Order: "asc"}}, Fields: &[]Field{{Field: "id"}}})
+	if err != nil {
+		t.Fatalf("ExecuteQuery with relation sorter failed: %v", err)
+	}
+	if len(res.List) != 2 || res.List[0]["id"] != "o2" || res.List[1]["id"] != "o1" {
+		t.Errorf("unexpected sorted result %v", res.List)
+	}
+
+	invalidFilters := []map[string]interface{}{
+		{"name.id": "o1"},
+		{"customer.unknown": "c1"},
+		{"customer": map[string]interface{}{Op_exists: map[string]interface{}{"unknown": "c1"}}},
+	}
+	for _, filter := range invalidFilters {
+		_, err := orm.ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Filter: &filter, Fields: &[]Field{{Field: "id"}}})
+		var validationErr *QueryValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%v: expected validation error, got %v", filter, err)
+		}
+	}
+}
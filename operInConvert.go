@@ -4,13 +4,14 @@ import (
 	"errors"
 	"log/slog"
 	"reflect"
-	"strconv"
 	"strings"
 )
 
 type DefaultOperInConvert struct {
+	AppDb   string   `json:"appDb"`
 	ModelId string   `json:"modelId"`
 	Fields  *[]Field `json:"fields"`
+	Dialect Dialect  `json:"-"`
 }
 
 func (opc *DefaultOperInConvert) Convert(op string, field string, value interface{}) (string, interface{}, error) {
@@ -38,26 +39,120 @@ func (opc *DefaultOperInConvert) Convert(op string, field string, value interfac
 	return field, value, nil
 }
 
-func (opc *DefaultOperInConvert) convertMany2manyValue(modelId string, field *Field, value interface{}) (string, error) {
+func (opc *DefaultOperInConvert) convertMany2manyValue(modelId string, field *Field, value interface{}) (*SubQuery, error) {
 	if field.RelatedModelId == nil {
 		slog.Error("convertMany2manyValue the many2many field has not related model id", "field", field.Field)
-		return "", errors.New("the many2many field has not related model id")
+		return nil, errors.New("the many2many field has not related model id")
 	}
 
-	var sVal string
+	var placeholders string
+	var args []interface{}
 	switch value.(type) {
 	case []string:
+		for _, sVal := range value.([]string) {
+			args = append(args, sVal)
+		}
+		placeholders = opc.getPlaceholders(len(args))
 	case []interface{}:
 		sliceVal := value.([]interface{})
-		sVal = opc.joinSlice(sliceVal, ",")
+		placeholders, args = opc.joinSlice(sliceVal)
 	default:
 		slog.Error("convertMany2manyValue not supported value type", "val type", reflect.TypeOf(value))
-		return "", errors.New("convertMany2manyValue not supported value type " + reflect.TypeOf(value).String())
+		return nil, errors.New("convertMany2manyValue not supported value type " + reflect.TypeOf(value).String())
+	}
+
+	if err := CheckIdentifier(INVALID_KIND_MODELID, *field.RelatedModelId); err != nil {
+		return nil, err
 	}
 
 	associationModelId := opc.getRelatedModelID(modelId, *field.RelatedModelId, field.AssociationModelId)
-	subSelect := "select " + modelId + "_id as id from " + associationModelId + " where " + *field.RelatedModelId + "_id in (" + sVal + ")"
-	return subSelect, nil
+	if err := CheckIdentifier(INVALID_KIND_MODELID, associationModelId); err != nil {
+		return nil, err
+	}
+
+	dialect := getDialectOrDefault(opc.Dialect)
+	subSelect := "select " + dialect.QuoteIdentifier(modelId+"_id") + " as id from " + dialect.GetTableName(opc.AppDb, associationModelId) +
+		" where " + dialect.QuoteIdentifier(*field.RelatedModelId+"_id") + " in (" + placeholders + ")"
+	return &SubQuery{SQL: subSelect, Args: args}, nil
+}
+
+// 将关联模型上的过滤条件转换为子查询，fieldName是本模型的关联字段，关联模型上的关联字段需要在关联字段的Fields中定义
+// many2one: field in (select id from related where ...)
+// one2many: id in (select relatedField from related where ...)
+// many2many: id in (select model_id from association where related_id in (select id from related where ...))
+func (opc *DefaultOperInConvert) ConvertRelationFilter(fieldName string, filter FilterNode) (string, *SubQuery, error) {
+	return opc.convertRelation(fieldName, func(fc *FilterConverter) (string, []interface{}, error) {
+		return fc.CompileFilter(filter)
+	})
+}
+
+// 查找关联字段，使用关联模型的FilterConverter生成关联模型上的过滤条件，再根据关联字段的类型生成子查询
+func (opc *DefaultOperInConvert) convertRelation(fieldName string, getWhere func(fc *FilterConverter) (string, []interface{}, error)) (string, *SubQuery, error) {
+	var refField *Field
+	if opc.Fields != nil {
+		for index := range *opc.Fields {
+			if (*opc.Fields)[index].Field == fieldName {
+				refField = &(*opc.Fields)[index]
+				break
+			}
+		}
+	}
+
+	if refField == nil || refField.FieldType == nil || refField.RelatedModelId == nil {
+		slog.Error("convertRelation the field is not a relation field", "field", fieldName, "model", opc.ModelId)
+		return "", nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: fieldName}
+	}
+
+	if err := CheckIdentifier(INVALID_KIND_MODELID, *refField.RelatedModelId); err != nil {
+		return "", nil, err
+	}
+
+	//关联模型上的过滤条件，递归处理多层的关联字段路径
+	relatedConvert := &DefaultOperInConvert{
+		AppDb:   opc.AppDb,
+		ModelId: *refField.RelatedModelId,
+		Fields:  refField.Fields,
+		Dialect: opc.Dialect,
+	}
+	fc := &FilterConverter{
+		OperInConvert:       relatedConvert,
+		RelationPathConvert: relatedConvert,
+		Dialect:             opc.Dialect,
+	}
+	where, args, err := getWhere(fc)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dialect := getDialectOrDefault(opc.Dialect)
+	relatedTable := dialect.GetTableName(opc.AppDb, *refField.RelatedModelId)
+	switch *refField.FieldType {
+	case FIELDTYPE_MANY2ONE:
+		subSelect := "select " + dialect.QuoteIdentifier(CC_ID) + " from " + relatedTable + " where " + where
+		return fieldName, &SubQuery{SQL: subSelect, Args: args}, nil
+	case FIELDTYPE_ONE2MANY:
+		if refField.RelatedField == nil {
+			slog.Error("convertRelation one2many field must have RelatedField", "field", fieldName, "model", opc.ModelId)
+			return "", nil, errors.New("One2many field must have RelatedField, field:" + fieldName + " model:" + opc.ModelId)
+		}
+		relatedField, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, *refField.RelatedField, dialect)
+		if err != nil {
+			return "", nil, err
+		}
+		subSelect := "select " + relatedField + " from " + relatedTable + " where " + where
+		return CC_ID, &SubQuery{SQL: subSelect, Args: args}, nil
+	case FIELDTYPE_MANY2MANY:
+		associationModelId := opc.getRelatedModelID(opc.ModelId, *refField.RelatedModelId, refField.AssociationModelId)
+		if err := CheckIdentifier(INVALID_KIND_MODELID, associationModelId); err != nil {
+			return "", nil, err
+		}
+		subSelect := "select " + dialect.QuoteIdentifier(opc.ModelId+"_id") + " from " + dialect.GetTableName(opc.AppDb, associationModelId) +
+			" where " + dialect.QuoteIdentifier(*refField.RelatedModelId+"_id") + " in (select " + dialect.QuoteIdentifier(CC_ID) + " from " + relatedTable + " where " + where + ")"
+		return CC_ID, &SubQuery{SQL: subSelect, Args: args}, nil
+	}
+
+	slog.Error("convertRelation not supported field type", "field", fieldName, "fieldType", *refField.FieldType)
+	return "", nil, &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: fieldName}
 }
 
 func (opc *DefaultOperInConvert) getRelatedModelID(
@@ -75,30 +170,23 @@ func (opc *DefaultOperInConvert) getRelatedModelID(
 	return modelID + "_" + relatedModelID
 }
 
-func (opc *DefaultOperInConvert) joinSlice(sliceVal []interface{}, split string) string {
-	values := ""
+func (opc *DefaultOperInConvert) getPlaceholders(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return strings.Repeat("?,", count-1) + "?"
+}
+
+func (opc *DefaultOperInConvert) joinSlice(sliceVal []interface{}) (string, []interface{}) {
+	var args []interface{}
 	for _, val := range sliceVal {
 		slog.Debug("joinSlice val type", "val type", reflect.TypeOf(val))
 
 		switch val.(type) {
-		case string:
-			sVal, _ := val.(string)
-			values = values + "'" + opc.replaceApostrophe(sVal) + "',"
-		case float64:
-			f64Val, _ := val.(float64)
-			sVal := strconv.FormatFloat(f64Val, 'f', -1, 64)
-			values = values + sVal + ","
+		case string, float64, int, int64:
+			args = append(args, val)
 		}
 	}
 
-	if len(values) > 1 {
-		values = values[0 : len(values)-1]
-	}
-
-	return values
-}
-
-func (opc *DefaultOperInConvert) replaceApostrophe(str string) string {
-	replacedStr := strings.ReplaceAll(str, "'", "''")
-	return replacedStr
+	return opc.getPlaceholders(len(args)), args
 }
@@ -1,11 +1,21 @@
 package crvorm
 
 import (
-	
+	"context"
 )
 
 type CrvOrm struct {
 	Repo DataRepository
+	//同一层级的关联字段并发查询的最大并发数，为0时使用DefaultRelatedQueryConcurrency
+	RelatedQueryConcurrency int
+	//模型定义的注册表，不为nil时查询前根据模型定义补全和检查查询字段的关联信息
+	Registry *ModelRegistry
+	//查询结果缓存，为nil时不缓存，通过ExecuteSave保存数据时使相关模型的缓存失效
+	Cache *QueryCache
+	//查询钩子，顶层查询的钩子在检查模型定义和读取缓存之前执行，关联字段的查询结果经过钩子处理后才放入缓存
+	Hooks QueryHooks
+	//拦截查询和保存时执行的SQL语句
+	SQLInterceptors []SQLInterceptor
 }
 
 func (orm *CrvOrm)InitDefaultRepo(dbConf *DbConf)(error){
@@ -19,7 +29,62 @@ func (orm *CrvOrm)InitDefaultRepo(dbConf *DbConf)(error){
 }
 
 func (orm *CrvOrm)ExecuteQuery(queryParam *QueryParam)(*QueryResult,error){
-	return ExecuteQuery(queryParam,orm.Repo,true)
+	return orm.ExecuteQueryContext(context.Background(),queryParam)
+}
+
+func (orm *CrvOrm)ExecuteQueryContext(ctx context.Context,queryParam *QueryParam)(*QueryResult,error){
+	if orm.RelatedQueryConcurrency>0 {
+		ctx=WithRelatedQueryConcurrency(ctx,orm.RelatedQueryConcurrency)
+	}
+	if len(orm.Hooks.BeforeQuery)>0 || len(orm.Hooks.AfterQuery)>0 {
+		ctx=WithQueryHooks(ctx,&orm.Hooks)
+	}
+	//钩子改写后的查询参数作为缓存的键，注入的过滤条件不同时不会使用其它查询的缓存
+	queryParam,err:=orm.Hooks.applyBeforeQuery(ctx,queryParam)
+	if err!=nil {
+		return nil,err
+	}
+	if orm.Registry!=nil {
+		queryParam,err=orm.Registry.ResolveQuery(queryParam)
+		if err!=nil {
+			return nil,err
+		}
+	}
+
+	var result *QueryResult
+	if orm.Cache!=nil {
+		result,err=orm.Cache.Query(ctx,queryParam,orm.executeQuery)
+	} else {
+		result,err=orm.executeQuery(ctx,queryParam)
+	}
+	if err!=nil {
+		return nil,err
+	}
+	return orm.Hooks.applyAfterQuery(ctx,queryParam,result)
+}
+
+func (orm *CrvOrm)executeQuery(ctx context.Context,queryParam *QueryParam)(*QueryResult,error){
+	return ExecuteQueryContext(ctx,queryParam,orm.getRepo(),true)
+}
+
+// 获取经过SQL拦截器包装的DataRepository
+func (orm *CrvOrm)getRepo()(DataRepository){
+	return WithSQLInterceptors(orm.Repo,orm.SQLInterceptors...)
+}
+
+func (orm *CrvOrm)ExecuteSave(saveParam *SaveParam)(*SaveResult,error){
+	return orm.ExecuteSaveContext(context.Background(),saveParam)
+}
+
+func (orm *CrvOrm)ExecuteSaveContext(ctx context.Context,saveParam *SaveParam)(*SaveResult,error){
+	result,err:=ExecuteSaveContext(ctx,saveParam,orm.getRepo())
+	if err!=nil {
+		return nil,err
+	}
+	if orm.Cache!=nil {
+		orm.Cache.InvalidateSave(saveParam,result)
+	}
+	return result,nil
 }
 
 func (orm *CrvOrm)ProcessFilter(
@@ -27,5 +92,14 @@ func (orm *CrvOrm)ProcessFilter(
 	filterData *[]FilterDataItem,
 	globalFilterData *map[string]interface{},
 	appDb string) error {
-	return ProcessFilter(filter,filterData,globalFilterData,appDb,orm.Repo)
+	return ProcessFilter(filter,filterData,globalFilterData,appDb,orm.getRepo())
+}
+
+func (orm *CrvOrm)ProcessFilterContext(
+	ctx context.Context,
+	filter *map[string]interface{},
+	filterData *[]FilterDataItem,
+	globalFilterData *map[string]interface{},
+	appDb string) error {
+	return ProcessFilterContext(ctx,filter,filterData,globalFilterData,appDb,orm.getRepo())
 }
\ No newline at end of file
@@ -1,10 +1,17 @@
 package crvorm
 
 import (
+	"context"
 	"log/slog"
+	"errors"
 	"strconv"
 	"strings"
-	"errors"
+)
+
+// 按父记录分页查询时，查询结果中用于记录行号和分组总数的字段
+const (
+	PARTITION_ROW_NUMBER = "__row_number"
+	PARTITION_TOTAL      = "__partition_total"
 )
 
 type Sorter struct {
@@ -14,18 +21,34 @@ type Sorter struct {
 }
 
 type SQLParam struct {
-	AppDb     string `json:"appDb"`
-	ModelId   string `json:"modelId"`
-	Fields    string `json:"fields"`
-	Where     string `json:"where"`
-	Limit     string `json:"limit"`
-	Sorter    string `json:"sorter"`
-	Summarize string `json:"summarize"`
+	Dialect    Dialect       `json:"-"`
+	AppDb      string        `json:"appDb"`
+	ModelId    string        `json:"modelId"`
+	Fields     string        `json:"fields"`
+	Where      string        `json:"where"`
+	WhereArgs  []interface{} `json:"whereArgs,omitempty"`
+	Limit      string        `json:"limit"`
+	Sorter     string        `json:"sorter"`
+	SorterArgs []interface{} `json:"sorterArgs,omitempty"`
+	Summarize  string        `json:"summarize"`
+	//分组字段和分组内的分页条件，仅在按父记录分页查询时设置
+	PartitionBy    string `json:"partitionBy,omitempty"`
+	PartitionLimit string `json:"partitionLimit,omitempty"`
+	//游标分页的过滤条件，仅用于查询数据，不影响汇总
+	Cursor     string        `json:"cursor,omitempty"`
+	CursorArgs []interface{} `json:"cursorArgs,omitempty"`
+	//分组查询的分组字段和having条件，只有聚合字段时Group为true，整个表作为一个分组
+	Group      bool          `json:"group,omitempty"`
+	GroupBy    string        `json:"groupBy,omitempty"`
+	Having     string        `json:"having,omitempty"`
+	HavingArgs []interface{} `json:"havingArgs,omitempty"`
 }
 
+// PerParent仅对一对多和多对多字段有效，为true时分页参数对每条父记录的关联数据分别生效
 type Pagination struct {
-	Current  int `json:"current"`
-	PageSize int `json:"pageSize"`
+	Current   int  `json:"current"`
+	PageSize  int  `json:"pageSize"`
+	PerParent bool `json:"perParent,omitempty"`
 }
 
 type Field struct {
@@ -50,6 +73,18 @@ type QueryParam struct {
 	Sorter     *[]Sorter               `json:"sorter,omitempty"`
 	Pagination *Pagination             `json:"pagination,omitempty"`
 	Distinct   bool                    `json:"distinct,omitempty"`
+	//游标分页，不为nil时按照游标获取下一页数据，空字符串表示获取第一页
+	//游标分页时只使用Pagination中的PageSize，下一页的游标在查询结果的NextCursor中返回
+	Cursor *string `json:"cursor,omitempty"`
+	//分组查询，指定分组字段或聚合字段时按照分组返回聚合结果，此时不再使用Fields
+	//Having的格式与Filter相同，字段名可以是分组字段或聚合字段
+	GroupBy    *[]GroupField           `json:"groupBy,omitempty"`
+	Aggregates *[]Aggregate            `json:"aggregates,omitempty"`
+	Having     *map[string]interface{} `json:"having,omitempty"`
+	//按照该字段分组后再分页，由一对多和多对多字段按父记录分页查询时设置
+	partitionBy string
+	//模型注册表根据模型定义生成的关联字段，用于过滤条件和排序中的关联路径，不作为查询字段
+	relationFields *[]Field
 }
 
 type QueryResult struct {
@@ -58,10 +93,20 @@ type QueryResult struct {
 	Total     int                      `json:"total"`
 	Summaries map[string]interface{}  `json:"summaries,omitempty"`
 	List      []map[string]interface{} `json:"list,omitempty"`
+	//游标分页时下一页的游标，没有下一页时为nil
+	NextCursor *string `json:"nextCursor,omitempty"`
+	//按父记录分页查询时，每个分组的数据总数，键为分组字段的值
+	partitionTotals map[interface{}]int
 }
 
 func QueryToSQLPARAM(query *QueryParam) (*SQLParam, error) {
+	return QueryToSQLPARAMWithDialect(query, DefaultDialect)
+}
+
+func QueryToSQLPARAMWithDialect(query *QueryParam, dialect Dialect) (*SQLParam, error) {
+	dialect = getDialectOrDefault(dialect)
 	sqlParam := &SQLParam{
+		Dialect:   dialect,
 		AppDb:     query.AppDb,
 		ModelId:   query.ModelId,
 		Fields:    "",
@@ -70,42 +115,153 @@ func QueryToSQLPARAM(query *QueryParam) (*SQLParam, error) {
 		Sorter:    "",
 		Summarize: "",
 	}
-	//处理fields
-	sqlParam.Fields = GetQueryFields(query.Fields,query.Distinct)
-	//处理汇总列
-	sqlParam.Summarize = GetSummarizeFields(query.Fields)
-	//处理filter
+	//检查库名和表名
+	if len(query.AppDb) > 0 {
+		if err := CheckIdentifier(INVALID_KIND_APPDB, query.AppDb); err != nil {
+			return nil, err
+		}
+	}
+	if err := CheckIdentifier(INVALID_KIND_MODELID, query.ModelId); err != nil {
+		return nil, err
+	}
 	var err error
+	//处理fields，分组查询的查询字段由分组字段和聚合字段生成
+	if !IsGroupQuery(query) {
+		sqlParam.Fields, err = GetQueryFields(query.Fields, query.Distinct, dialect)
+		if err != nil {
+			return nil, err
+		}
+		//处理汇总列
+		sqlParam.Summarize, err = GetSummarizeFields(query.Fields, dialect)
+		if err != nil {
+			return nil, err
+		}
+	}
+	//处理filter
 	opc := &DefaultOperInConvert{
+		AppDb:   query.AppDb,
 		ModelId: query.ModelId,
-		Fields:  query.Fields,
+		Fields:  getRelationPathFields(query),
+		Dialect: dialect,
 	}
 	fc := &FilterConverter{
-		OperInConvert: opc,
+		OperInConvert:       opc,
+		RelationPathConvert: opc,
+		Dialect:             dialect,
 	}
-	sqlParam.Where, err = fc.FilterToSQLWhere(query.Filter)
+	sqlParam.Where, sqlParam.WhereArgs, err = fc.FilterToSQLWhere(query.Filter)
 	if err != nil {
 		return nil, err
 	}
+	//分组查询
+	if IsGroupQuery(query) {
+		err = setGroupParam(sqlParam, query, dialect)
+		if err != nil {
+			return nil, err
+		}
+		return sqlParam, nil
+	}
+	//游标分页
+	if query.Cursor != nil {
+		err = setCursorParam(sqlParam, query, dialect)
+		if err != nil {
+			return nil, err
+		}
+		return sqlParam, nil
+	}
 	//处理sorter
-	sqlParam.Sorter = GetQuerySorter(query.Sorter)
+	sqlParam.Sorter, sqlParam.SorterArgs, err = GetModelQuerySorter(query.AppDb, query.ModelId, getRelationPathFields(query), query.Sorter, dialect)
+	if err != nil {
+		return nil, err
+	}
 	//处理pagination
-	sqlParam.Limit = GetQueryLimit(query.Pagination)
+	if len(query.partitionBy) > 0 {
+		sqlParam.PartitionBy, err = CheckAndQuoteIdentifier(INVALID_KIND_FIELD, query.partitionBy, dialect)
+		if err != nil {
+			return nil, err
+		}
+		sqlParam.PartitionLimit = GetPartitionLimit(query.Pagination)
+	} else {
+		sqlParam.Limit = GetQueryLimit(query.Pagination, dialect)
+	}
 	return sqlParam, nil
 }
 
-func GetQueryFields(fields *[]Field,distinct bool) string {
+// 获取查找关联路径使用的字段，模型注册表生成的关联字段优先于查询字段
+func getRelationPathFields(query *QueryParam) *[]Field {
+	if query.relationFields == nil {
+		return query.Fields
+	}
+	fields := append([]Field{}, *query.relationFields...)
+	if query.Fields != nil {
+		fields = append(fields, *query.Fields...)
+	}
+	return &fields
+}
+
+// 游标分页时按照游标排序字段排序，并多取一条数据用于判断是否有下一页
+func setCursorParam(sqlParam *SQLParam, query *QueryParam, dialect Dialect) error {
+	sorters, err := GetCursorSorters(query.Sorter, query.Fields)
+	if err != nil {
+		return err
+	}
+	sqlParam.Sorter, sqlParam.SorterArgs, err = GetQuerySorter(&sorters, dialect)
+	if err != nil {
+		return err
+	}
+	if len(*query.Cursor) > 0 {
+		values, err := DecodeCursor(*query.Cursor, len(sorters))
+		if err != nil {
+			return err
+		}
+		sqlParam.Cursor, sqlParam.CursorArgs, err = GetCursorWhere(sorters, values, dialect)
+		if err != nil {
+			return err
+		}
+	}
+	pageSize := getCursorPageSize(query.Pagination)
+	if pageSize > 0 {
+		pageSize++
+	}
+	sqlParam.Limit = dialect.Limit(0, pageSize)
+	return nil
+}
+
+func GetQueryFields(fields *[]Field, distinct bool, dialect Dialect) (string, error) {
+	if fields == nil || len(*fields) == 0 {
+		slog.Error("GetQueryFields query must have fields")
+		return "", errors.New("query must have fields")
+	}
+
 	fieldsStr := ""
 	for _, field := range *fields {
-		if field.FieldType == nil {
-			fieldsStr = fieldsStr + field.Field + ","
-		} else {
-			if *(field.FieldType) != FIELDTYPE_MANY2MANY &&
-				*(field.FieldType) != FIELDTYPE_ONE2MANY &&
-				*(field.FieldType) != FIELDTYPE_FILE {
-					fieldsStr = fieldsStr + field.Field + ","
+		if field.FieldType != nil &&
+			(*(field.FieldType) == FIELDTYPE_MANY2MANY ||
+				*(field.FieldType) == FIELDTYPE_ONE2MANY ||
+				*(field.FieldType) == FIELDTYPE_FILE) {
+			continue
+		}
+
+		//JSON字段的路径返回JSON值，使用完整的路径作为别名
+		if IsJSONPathField(field.Field) {
+			expression, err := getJSONPathExpression(field.Field, false, dialect)
+			if err != nil {
+				return "", err
 			}
+			fieldsStr = fieldsStr + expression + " as " + dialect.QuoteIdentifier(field.Field) + ","
+			continue
 		}
+
+		quotedField, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, field.Field, dialect)
+		if err != nil {
+			return "", err
+		}
+		fieldsStr = fieldsStr + quotedField + ","
+	}
+
+	if len(fieldsStr) == 0 {
+		slog.Error("GetQueryFields query must have fields")
+		return "", errors.New("query must have fields")
 	}
 	fieldsStr = fieldsStr[0 : len(fieldsStr)-1]
 
@@ -113,73 +269,268 @@ func GetQueryFields(fields *[]Field,distinct bool) string {
 		fieldsStr="distinct "+fieldsStr
 	}
 
-	return fieldsStr
+	return fieldsStr, nil
 }
 
-func GetSummarizeFields(fields *[]Field) string {
+func GetSummarizeFields(fields *[]Field, dialect Dialect) (string, error) {
 	var summarizeFields string
 	for _, field := range *fields {
 		if field.Summarize != nil && len(*field.Summarize) > 0 {
-			summarizeFields = summarizeFields + *field.Summarize + " as " + field.Field + ","
+			summarize, err := CheckSummarize(*field.Summarize, dialect)
+			if err != nil {
+				return "", err
+			}
+			quotedField, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, field.Field, dialect)
+			if err != nil {
+				return "", err
+			}
+			summarizeFields = summarizeFields + summarize + " as " + quotedField + ","
 		}
 	}
-	return summarizeFields
+	return summarizeFields, nil
+}
+
+func GetQuerySorter(sorters *[]Sorter, dialect Dialect) (string, []interface{}, error) {
+	return GetModelQuerySorter("", "", nil, sorters, dialect)
 }
 
-func GetQuerySorter(sorters *[]Sorter) string {
+// 排序字段可以是多对一关联字段的路径，如customer.name，按照关联模型上字段的值排序
+// 关联字段需要在fields中定义，多层路径的关联字段需要在上一层关联字段的Fields中定义
+func GetModelQuerySorter(appDb string, modelId string, fields *[]Field, sorters *[]Sorter, dialect Dialect) (string, []interface{}, error) {
 	if sorters == nil || len(*(sorters)) == 0 {
-		return " id asc "
+		return " id asc ", nil, nil
 	}
 
 	var sorterStr string
+	var args []interface{}
 	for _, sorter := range *(sorters) {
+		var field string
+		var err error
+		if !IsJSONPathField(sorter.Field) && strings.Contains(sorter.Field, ".") && len(modelId) > 0 {
+			field, err = GetRelationPathSorter(appDb, dialect.GetTableName(appDb, modelId), fields, sorter.Field, dialect, 0)
+		} else {
+			field, err = GetFieldExpression(sorter.Field, dialect)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		order, err := CheckSorterOrder(sorter.Order)
+		if err != nil {
+			return "", nil, err
+		}
+
 		if sorter.Values != nil && len(*sorter.Values) > 0 {
-			sorterStr = sorterStr + "FIELD(" + sorter.Field + ",'" + strings.Join(*sorter.Values, "','") + "') " + sorter.Order + ","
+			sorterStr = sorterStr + dialect.FieldOrder(field, len(*sorter.Values)) + " " + order + ","
+			for _, value := range *sorter.Values {
+				args = append(args, value)
+			}
 		} else {
-			sorterStr = sorterStr + sorter.Field + " " + sorter.Order + ","
+			sorterStr = sorterStr + field + " " + order + ","
 		}
 	}
 
 	sorterStr = sorterStr[0 : len(sorterStr)-1]
-	return sorterStr
+	return sorterStr, args, nil
 }
 
-func GetQueryLimit(pagination *Pagination) string {
+// 将多对一关联字段的路径转换为关联子查询，子查询使用__sorter加层级作为表别名
+// 如customer.name转换为(select __sorter0.name from customer __sorter0 where __sorter0.id=order.customer)
+func GetRelationPathSorter(appDb string, outerTable string, fields *[]Field, path string, dialect Dialect, depth int) (string, error) {
+	fieldName, relatedPath, _ := strings.Cut(path, ".")
+	var refField *Field
+	if fields != nil {
+		for index := range *fields {
+			if (*fields)[index].Field == fieldName {
+				refField = &(*fields)[index]
+				break
+			}
+		}
+	}
+
+	if refField == nil || refField.FieldType == nil || *refField.FieldType != FIELDTYPE_MANY2ONE ||
+		refField.RelatedModelId == nil || len(relatedPath) == 0 {
+		slog.Error("GetRelationPathSorter sorter path must go through many2one fields", "path", path)
+		return "", &QueryValidationError{Kind: INVALID_KIND_FIELD, Value: path}
+	}
+
+	quotedField, err := CheckAndQuoteIdentifier(INVALID_KIND_FIELD, fieldName, dialect)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckIdentifier(INVALID_KIND_MODELID, *refField.RelatedModelId); err != nil {
+		return "", err
+	}
+
+	alias := dialect.QuoteIdentifier("__sorter" + strconv.Itoa(depth))
+	var column string
+	if strings.Contains(relatedPath, ".") {
+		column, err = GetRelationPathSorter(appDb, alias, refField.Fields, relatedPath, dialect, depth+1)
+	} else {
+		column, err = CheckAndQuoteIdentifier(INVALID_KIND_FIELD, relatedPath, dialect)
+		column = alias + "." + column
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "(select " + column + " from " + dialect.GetTableName(appDb, *refField.RelatedModelId) + " " + alias +
+		" where " + alias + "." + dialect.QuoteIdentifier(CC_ID) + "=" + outerTable + "." + quotedField + ")", nil
+}
+
+func GetQueryLimit(pagination *Pagination, dialect Dialect) string {
 	//如果没有提供分页信息，这里暂时给一个固定值，避免数据量过大造成性能或内存问题
 	if pagination == nil {
-		return "0,1000"
+		return dialect.Limit(0, 1000)
 	}
 
 	if pagination.PageSize < 0 || pagination.Current <= 0 {
 		slog.Error("GetQueryLimit pageSize and current must great than 0", "Pagination", pagination)
-		return "0,0"
+		return dialect.Limit(0, 0)
 	}
 
-	row := strconv.Itoa((pagination.Current - 1) * pagination.PageSize)
-	count := strconv.Itoa(pagination.PageSize)
-	limit := row + "," + count
+	return dialect.Limit((pagination.Current-1)*pagination.PageSize, pagination.PageSize)
+}
 
-	return limit
+// 获取分组内分页的过滤条件，行号从1开始
+func GetPartitionLimit(pagination *Pagination) string {
+	if pagination == nil {
+		return "1=1"
+	}
+
+	if pagination.PageSize < 0 || pagination.Current <= 0 {
+		slog.Error("GetPartitionLimit pageSize and current must great than 0", "Pagination", pagination)
+		return "1=0"
+	}
+
+	offset := (pagination.Current - 1) * pagination.PageSize
+	return PARTITION_ROW_NUMBER + " > " + strconv.Itoa(offset) +
+		" and " + PARTITION_ROW_NUMBER + " <= " + strconv.Itoa(offset+pagination.PageSize)
 }
 
-func SQLParamToSummarizeSQL(sqlParam *SQLParam) string {
+// 获取查询的表名，库名和表名在QueryToSQLPARAM中已经做过检查
+func GetQueryTable(appDb string, modelId string, dialect Dialect) string {
+	return getDialectOrDefault(dialect).GetTableName(appDb, modelId)
+}
+
+func SQLParamToSummarizeSQL(sqlParam *SQLParam) (string, []interface{}) {
+	if isGroupSQLParam(sqlParam) {
+		return SQLParamToGroupCountSQL(sqlParam)
+	}
 	sql := "select " + sqlParam.Summarize + " count(*) as __count" +
-		" from " + sqlParam.AppDb + "." + sqlParam.ModelId +
+		" from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, sqlParam.Dialect) +
 		" where " + sqlParam.Where
-	return sql
+	return sql, sqlParam.WhereArgs
 }
 
-func SQLParamToDataSQL(sqlParam *SQLParam) (string) {
+func SQLParamToDataSQL(sqlParam *SQLParam) (string, []interface{}) {
+	where := sqlParam.Where
+	if len(sqlParam.Cursor) > 0 {
+		where = "(" + where + ") and (" + sqlParam.Cursor + ")"
+	}
 	sql := "select " + sqlParam.Fields +
-		" from " + sqlParam.AppDb + "." + sqlParam.ModelId +
-		" where " + sqlParam.Where +
+		" from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, sqlParam.Dialect) +
+		" where " + where +
+		getGroupByClause(sqlParam) +
 		" order by " + sqlParam.Sorter +
-		" limit " + sqlParam.Limit
-	return sql
+		" " + sqlParam.Limit
+	args := make([]interface{}, 0, len(sqlParam.WhereArgs)+len(sqlParam.CursorArgs)+len(sqlParam.HavingArgs)+len(sqlParam.SorterArgs))
+	args = append(args, sqlParam.WhereArgs...)
+	args = append(args, sqlParam.CursorArgs...)
+	args = append(args, sqlParam.HavingArgs...)
+	args = append(args, sqlParam.SorterArgs...)
+	return sql, args
+}
+
+// 按分组字段分组后在每个分组内分页
+// 窗口函数中的排序参数在过滤条件的参数之前
+func SQLParamToPartitionDataSQL(sqlParam *SQLParam) (string, []interface{}) {
+	fields := sqlParam.Fields
+	//窗口函数与distinct不能同时使用，按父记录分页时忽略distinct
+	if len(fields) > 9 && fields[0:9] == "distinct " {
+		fields = fields[9:]
+	}
+
+	sql := "select * from (select " + fields +
+		",row_number() over (partition by " + sqlParam.PartitionBy + " order by " + sqlParam.Sorter + ") as " + PARTITION_ROW_NUMBER +
+		" from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, sqlParam.Dialect) +
+		" where " + sqlParam.Where +
+		") __partition where " + sqlParam.PartitionLimit +
+		" order by " + PARTITION_ROW_NUMBER
+	args := make([]interface{}, 0, len(sqlParam.WhereArgs)+len(sqlParam.SorterArgs))
+	args = append(args, sqlParam.SorterArgs...)
+	args = append(args, sqlParam.WhereArgs...)
+	return sql, args
+}
+
+// 获取每个分组的数据总数，分页后没有数据的分组也需要返回总数，所以不能从分页的结果中获取
+func SQLParamToPartitionCountSQL(sqlParam *SQLParam) (string, []interface{}) {
+	sql := "select " + sqlParam.PartitionBy + "," +
+		"count(*) as " + PARTITION_TOTAL +
+		" from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, sqlParam.Dialect) +
+		" where " + sqlParam.Where +
+		" group by " + sqlParam.PartitionBy
+	return sql, sqlParam.WhereArgs
+}
+
+// 将数据库返回的数值转换为int
+func toInt(value interface{}) int {
+	switch value.(type) {
+	case int64:
+		return int(value.(int64))
+	case int:
+		return value.(int)
+	case float64:
+		return int(value.(float64))
+	case string:
+		iVal, _ := strconv.Atoi(value.(string))
+		return iVal
+	}
+	return 0
+}
+
+// 查询按父记录分页时每个分组的数据总数，并删除分页结果中的行号字段
+func getPartitionTotals(ctx context.Context, repo DataRepository, sqlParam *SQLParam, partitionBy string, list []map[string]interface{}) (map[interface{}]int, error) {
+	for _, row := range list {
+		delete(row, PARTITION_ROW_NUMBER)
+	}
+	sql, args := SQLParamToPartitionCountSQL(sqlParam)
+	counts, err := repo.QueryContext(ctx, sql, args...)
+	if err != nil {
+		slog.Error("Query failed", "error", err)
+		return nil, err
+	}
+	totals := map[interface{}]int{}
+	for _, row := range counts {
+		totals[GetRelationKey(row[partitionBy])] = toInt(row[PARTITION_TOTAL])
+	}
+	return totals, nil
 }
 
 func ExecuteQuery(queryParam *QueryParam,repo DataRepository,withSummarize bool) (*QueryResult, error) {
-	sqlParam, err := QueryToSQLPARAM(queryParam)
+	return ExecuteQueryContext(context.Background(), queryParam, repo, withSummarize)
+}
+
+// 关联字段的查询执行context中设置的查询钩子
+func ExecuteQueryContext(ctx context.Context, queryParam *QueryParam, repo DataRepository, withSummarize bool) (*QueryResult, error) {
+	hooks := getQueryHooks(ctx)
+	if hooks == nil || !IsRelatedQuery(ctx) {
+		return executeQueryContext(ctx, queryParam, repo, withSummarize)
+	}
+
+	queryParam, err := hooks.applyBeforeQuery(ctx, queryParam)
+	if err != nil {
+		return nil, err
+	}
+	result, err := executeQueryContext(ctx, queryParam, repo, withSummarize)
+	if err != nil {
+		return nil, err
+	}
+	return hooks.applyAfterQuery(ctx, queryParam, result)
+}
+
+func executeQueryContext(ctx context.Context, queryParam *QueryParam, repo DataRepository, withSummarize bool) (*QueryResult, error) {
+	sqlParam, err := QueryToSQLPARAMWithDialect(queryParam, repo.GetDialect())
 	if err != nil {
 		slog.Error("QueryToSQLPARAM failed", "error", err)
 		return nil,err
@@ -191,8 +542,8 @@ func ExecuteQuery(queryParam *QueryParam,repo DataRepository,withSummarize bool)
 	}
 
 	if withSummarize==true {
-		sql := SQLParamToSummarizeSQL(sqlParam)
-		summaries, err := repo.Query(sql)
+		sql, args := SQLParamToSummarizeSQL(sqlParam)
+		summaries, err := repo.QueryContext(ctx, sql, args...)
 		if err != nil {
 			slog.Error("Query failed", "error", err)
 			return nil,err
@@ -210,31 +561,52 @@ func ExecuteQuery(queryParam *QueryParam,repo DataRepository,withSummarize bool)
 	}
 
 	if result.Total != 0 && (queryParam.Pagination==nil || queryParam.Pagination.PageSize > 0) {
-		sql := SQLParamToDataSQL(sqlParam)
-		data, err := repo.Query(sql)
+		var sql string
+		var args []interface{}
+		if len(queryParam.partitionBy) > 0 {
+			sql, args = SQLParamToPartitionDataSQL(sqlParam)
+		} else {
+			sql, args = SQLParamToDataSQL(sqlParam)
+		}
+		data, err := repo.QueryContext(ctx, sql, args...)
 		if err != nil {
 			slog.Error("Query failed", "error", err)
 			return nil, err
 		}
 		result.List = data
+		if !IsGroupQuery(queryParam) {
+			decodeJSONFields(queryParam.Fields, data)
+		}
+
+		if queryParam.Cursor != nil {
+			sorters, _ := GetCursorSorters(queryParam.Sorter, queryParam.Fields)
+			result.List, result.NextCursor, err = getNextCursor(data, sorters, getCursorPageSize(queryParam.Pagination))
+			if err != nil {
+				return nil, err
+			}
+			data = result.List
+		}
+
+		if len(queryParam.partitionBy) > 0 {
+			result.partitionTotals, err = getPartitionTotals(ctx, repo, sqlParam, queryParam.partitionBy, data)
+			if err != nil {
+				return nil, err
+			}
+		}
 
 		if result.Total <= 0 {
 			result.Total = len(data)
 		}
 
-		//循环所有字段，对每个关联字段进行处理
-		for _, field := range *(queryParam.Fields) {
-			//由于MANY_TO_MANY和ONE_TO_MANY字段本身不对应实际数据库表中的字段，
-			//需要单独处理，所以先将这两个类型的字段过滤掉
-			if field.FieldType != nil {
-				slog.Debug("fieldType", "fieldType", *field.FieldType, "field", field.Field)
-				relatedQuery:= GetRelatedModelQuerier(queryParam.AppDb,queryParam.ModelId,*field.FieldType)
-				err:=relatedQuery.Query(repo, result, &field)
-				if err != nil {
-					slog.Error("Query relatedmodel failed", "error", err, "field", field.Field, "model", queryParam.ModelId)
-					return nil, err
-				}
-			}
+		//分组查询的结果不包含关联字段
+		if IsGroupQuery(queryParam) {
+			return result, nil
+		}
+
+		//查询所有关联字段的数据
+		err = QueryRelatedModels(ctx, queryParam.AppDb, queryParam.ModelId, *(queryParam.Fields), repo, result)
+		if err != nil {
+			return nil, err
 		}
 	}
 
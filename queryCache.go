@@ -0,0 +1,379 @@
+package crvorm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+查询结果缓存，用于字典、组织机构等查询频繁而修改很少的数据
+缓存的键由规范化的查询参数和查询涉及的模型的版本号组成，查询参数按照JSON序列化，对象的键按照字典序排列
+通过CrvOrm保存数据时，保存涉及的模型的版本号增加，之前缓存的相关查询不再命中，等待过期或被淘汰
+模型的版本号保存在QueryCache中，只能感知本进程中通过CrvOrm保存的数据，直接修改数据库或其它进程保存的数据只能等待缓存过期
+*/
+
+// 默认的缓存条数
+const DEFAULT_QUERY_CACHE_CAPACITY = 1000
+
+// 缓存接口，可以替换为其它的缓存实现，保存的值不会被修改
+type Cache interface {
+	Get(key string) (*QueryResult, bool)
+	Set(key string, value *QueryResult, ttl time.Duration)
+}
+
+// 查询缓存的配置，模型的缓存时间优先使用ModelTTL中的设置，没有设置时使用DefaultTTL，缓存时间不大于0的模型不缓存
+type QueryCacheConf struct {
+	DefaultTTL time.Duration            `json:"defaultTTL" mapstructure:"defaultTTL"`
+	ModelTTL   map[string]time.Duration `json:"modelTTL" mapstructure:"modelTTL"`
+	//默认缓存的条数，为0时使用DEFAULT_QUERY_CACHE_CAPACITY
+	Capacity int `json:"capacity" mapstructure:"capacity"`
+}
+
+type QueryCache struct {
+	Conf  QueryCacheConf
+	Cache Cache
+
+	mutex sync.Mutex
+	//模型的版本号，键为appDb.modelId
+	versions map[string]uint64
+}
+
+// 创建查询缓存，cache为nil时使用内存中的LRU缓存
+func NewQueryCache(conf QueryCacheConf, cache Cache) *QueryCache {
+	if cache == nil {
+		capacity := conf.Capacity
+		if capacity <= 0 {
+			capacity = DEFAULT_QUERY_CACHE_CAPACITY
+		}
+		cache = NewLRUCache(capacity)
+	}
+	return &QueryCache{Conf: conf, Cache: cache, versions: map[string]uint64{}}
+}
+
+func (queryCache *QueryCache) getTTL(modelId string) time.Duration {
+	if ttl, ok := queryCache.Conf.ModelTTL[modelId]; ok {
+		return ttl
+	}
+	return queryCache.Conf.DefaultTTL
+}
+
+func getModelVersionKey(appDb string, modelId string) string {
+	return appDb + "." + modelId
+}
+
+// 缓存的键为查询参数和涉及模型的版本号的摘要，查询参数无法序列化时不缓存
+func (queryCache *QueryCache) getKey(queryParam *QueryParam) (string, bool) {
+	data, err := json.Marshal(queryParam)
+	if err != nil {
+		slog.Debug("QueryCache query param can not be serialized, skip cache", "error", err)
+		return "", false
+	}
+
+	queryCache.mutex.Lock()
+	defer queryCache.mutex.Unlock()
+	hash := sha256.New()
+	hash.Write(data)
+	for _, modelId := range GetQueryModelIds(queryParam) {
+		hash.Write([]byte("\n" + modelId + ":" + strconv.FormatUint(queryCache.versions[getModelVersionKey(queryParam.AppDb, modelId)], 10)))
+	}
+	return queryParam.ModelId + ":" + hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// 从缓存中获取查询结果，没有缓存时执行query并缓存查询结果，返回的查询结果可以被调用方修改
+func (queryCache *QueryCache) Query(ctx context.Context, queryParam *QueryParam, query func(ctx context.Context, queryParam *QueryParam) (*QueryResult, error)) (*QueryResult, error) {
+	ttl := queryCache.getTTL(queryParam.ModelId)
+	if ttl <= 0 {
+		return query(ctx, queryParam)
+	}
+	//键中包含查询开始时的模型版本号，查询过程中保存的数据会使本次缓存的结果不再命中
+	key, ok := queryCache.getKey(queryParam)
+	if !ok {
+		return query(ctx, queryParam)
+	}
+	if result, ok := queryCache.Cache.Get(key); ok {
+		slog.Debug("QueryCache hit", "modelId", queryParam.ModelId, "key", key)
+		return copyQueryResult(result), nil
+	}
+
+	result, err := query(ctx, queryParam)
+	if err != nil {
+		return nil, err
+	}
+	queryCache.Cache.Set(key, copyQueryResult(result), ttl)
+	return result, nil
+}
+
+// 使模型相关的缓存失效
+func (queryCache *QueryCache) Invalidate(appDb string, modelIds ...string) {
+	queryCache.mutex.Lock()
+	defer queryCache.mutex.Unlock()
+	if queryCache.versions == nil {
+		queryCache.versions = map[string]uint64{}
+	}
+	for _, modelId := range modelIds {
+		queryCache.versions[getModelVersionKey(appDb, modelId)]++
+	}
+}
+
+// 使保存结果中实际保存的模型相关的缓存失效，多对多字段只保存中间表，关联模型的缓存不受影响
+func (queryCache *QueryCache) InvalidateSave(saveParam *SaveParam, saveResult *SaveResult) {
+	modelIds := map[string]bool{saveParam.ModelId: true}
+	addSaveResultModelIds(modelIds, saveResult)
+	for modelId := range modelIds {
+		queryCache.Invalidate(saveParam.AppDb, modelId)
+	}
+}
+
+// 获取查询涉及的模型，包括关联字段查询的模型、多对多的中间表、文件表，以及过滤条件和排序中关联路径引用的模型，按照字典序排序
+func GetQueryModelIds(queryParam *QueryParam) []string {
+	modelIds := map[string]bool{queryParam.ModelId: true}
+	addFieldModelIds(modelIds, queryParam.ModelId, queryParam.Fields)
+	relationFields := getRelationPathFields(queryParam)
+	addFilterModelIds(modelIds, queryParam.ModelId, relationFields, queryParam.Filter)
+	if queryParam.Sorter != nil {
+		for _, sorter := range *queryParam.Sorter {
+			if IsJSONPathField(sorter.Field) || !strings.Contains(sorter.Field, ".") {
+				continue
+			}
+			segments := strings.Split(sorter.Field, ".")
+			addRelationPathModelIds(modelIds, queryParam.ModelId, relationFields, segments[:len(segments)-1])
+		}
+	}
+	sorted := make([]string, 0, len(modelIds))
+	for modelId := range modelIds {
+		sorted = append(sorted, modelId)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func addFieldModelIds(modelIds map[string]bool, modelId string, fields *[]Field) {
+	if fields == nil {
+		return
+	}
+	for _, field := range *fields {
+		if field.FieldType == nil {
+			continue
+		}
+		if *field.FieldType == FIELDTYPE_FILE {
+			modelIds[FILE_MODEL_ID] = true
+			continue
+		}
+		if field.RelatedModelId == nil {
+			continue
+		}
+		modelIds[*field.RelatedModelId] = true
+		if *field.FieldType == FIELDTYPE_MANY2MANY {
+			modelIds[GetRelatedModelId(modelId, *field.RelatedModelId, field.AssociationModelId)] = true
+		}
+		addFieldModelIds(modelIds, *field.RelatedModelId, field.Fields)
+		addFilterModelIds(modelIds, *field.RelatedModelId, field.Fields, field.Filter)
+	}
+}
+
+// 过滤条件中的关联路径和Op.exists引用关联模型，多对多字段上的过滤条件引用中间表，过滤条件格式错误时查询会失败，不需要处理
+func addFilterModelIds(modelIds map[string]bool, modelId string, fields *[]Field, filter *map[string]interface{}) {
+	if filter == nil {
+		return
+	}
+	node, err := ParseFilter(filter)
+	if err != nil {
+		return
+	}
+	addFilterNodeModelIds(modelIds, modelId, fields, node)
+}
+
+func addFilterNodeModelIds(modelIds map[string]bool, modelId string, fields *[]Field, node FilterNode) {
+	var field string
+	switch node := node.(type) {
+	case *AndNode:
+		for _, child := range node.Children {
+			addFilterNodeModelIds(modelIds, modelId, fields, child)
+		}
+	case *OrNode:
+		for _, child := range node.Children {
+			addFilterNodeModelIds(modelIds, modelId, fields, child)
+		}
+	case *NotNode:
+		addFilterNodeModelIds(modelIds, modelId, fields, node.Child)
+	case *ExistsNode:
+		if refField := addRelationPathModelIds(modelIds, modelId, fields, []string{node.Field}); refField != nil {
+			addFilterNodeModelIds(modelIds, *refField.RelatedModelId, refField.Fields, node.Filter)
+		}
+	case *CompareNode:
+		field = node.Field
+	case *InNode:
+		field = node.Field
+	case *NullNode:
+		field = node.Field
+	}
+	//多对多字段上的过滤条件转换为中间表的子查询
+	if refField := findField(fields, field); refField != nil && refField.FieldType != nil && refField.RelatedModelId != nil && *refField.FieldType == FIELDTYPE_MANY2MANY {
+		modelIds[GetRelatedModelId(modelId, *refField.RelatedModelId, refField.AssociationModelId)] = true
+	}
+}
+
+// 添加关联路径经过的关联模型，返回路径上最后一个关联字段，路径中的字段不是关联字段时返回nil
+func addRelationPathModelIds(modelIds map[string]bool, modelId string, fields *[]Field, relations []string) *Field {
+	var refField *Field
+	for _, relation := range relations {
+		refField = findField(fields, relation)
+		if refField == nil || refField.FieldType == nil || refField.RelatedModelId == nil {
+			return nil
+		}
+		modelIds[*refField.RelatedModelId] = true
+		if *refField.FieldType == FIELDTYPE_MANY2MANY {
+			modelIds[GetRelatedModelId(modelId, *refField.RelatedModelId, refField.AssociationModelId)] = true
+		}
+		modelId = *refField.RelatedModelId
+		fields = refField.Fields
+	}
+	return refField
+}
+
+func findField(fields *[]Field, fieldName string) *Field {
+	if fields == nil || len(fieldName) == 0 {
+		return nil
+	}
+	for index := range *fields {
+		if (*fields)[index].Field == fieldName {
+			return &(*fields)[index]
+		}
+	}
+	return nil
+}
+
+func addSaveResultModelIds(modelIds map[string]bool, saveResult *SaveResult) {
+	if saveResult == nil {
+		return
+	}
+	modelIds[saveResult.ModelId] = true
+	for _, row := range saveResult.List {
+		for _, related := range row.Related {
+			addSaveResultModelIds(modelIds, related)
+		}
+	}
+}
+
+// 复制查询结果和其中的关联查询结果，避免调用方修改缓存中的数据
+func copyQueryResult(result *QueryResult) *QueryResult {
+	if result == nil {
+		return nil
+	}
+	copied := *result
+	if result.List != nil {
+		copied.List = make([]map[string]interface{}, len(result.List))
+		for index, row := range result.List {
+			copiedRow := make(map[string]interface{}, len(row))
+			for key, value := range row {
+				copiedRow[key] = copyResultValue(value)
+			}
+			copied.List[index] = copiedRow
+		}
+	}
+	if result.Summaries != nil {
+		copied.Summaries = make(map[string]interface{}, len(result.Summaries))
+		for key, value := range result.Summaries {
+			copied.Summaries[key] = value
+		}
+	}
+	return &copied
+}
+
+// 复制查询结果中的关联查询结果和JSON字段解析后的对象和数组，其它类型的值不会被修改
+func copyResultValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *QueryResult:
+		return copyQueryResult(value)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyResultValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for index, item := range value {
+			copied[index] = copyResultValue(item)
+		}
+		return copied
+	}
+	return value
+}
+
+// 内存中的LRU缓存，超过容量时淘汰最久没有使用的数据
+type LRUCache struct {
+	capacity int
+	mutex    sync.Mutex
+	list     *list.List
+	items    map[string]*list.Element
+}
+
+type lruCacheEntry struct {
+	key      string
+	value    *QueryResult
+	expireAt time.Time
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{capacity: capacity, list: list.New(), items: map[string]*list.Element{}}
+}
+
+func (cache *LRUCache) Get(key string) (*QueryResult, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		cache.removeElement(element)
+		return nil, false
+	}
+	cache.list.MoveToFront(element)
+	return entry.value, true
+}
+
+func (cache *LRUCache) Set(key string, value *QueryResult, ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	expireAt := time.Now().Add(ttl)
+	if element, ok := cache.items[key]; ok {
+		element.Value = &lruCacheEntry{key: key, value: value, expireAt: expireAt}
+		cache.list.MoveToFront(element)
+		return
+	}
+	cache.items[key] = cache.list.PushFront(&lruCacheEntry{key: key, value: value, expireAt: expireAt})
+	for cache.capacity > 0 && cache.list.Len() > cache.capacity {
+		cache.removeElement(cache.list.Back())
+	}
+}
+
+func (cache *LRUCache) Delete(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[key]; ok {
+		cache.removeElement(element)
+	}
+}
+
+func (cache *LRUCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.list.Len()
+}
+
+func (cache *LRUCache) removeElement(element *list.Element) {
+	cache.list.Remove(element)
+	delete(cache.items, element.Value.(*lruCacheEntry).key)
+}
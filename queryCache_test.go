@@ -0,0 +1,188 @@
+package crvorm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Set("a", &QueryResult{ModelId: "a"}, time.Minute)
+	cache.Set("b", &QueryResult{ModelId: "b"}, time.Minute)
+	cache.Get("a")
+	cache.Set("c", &QueryResult{ModelId: "c"}, time.Minute)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("least recently used entry should be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("recently used entry should be kept")
+	}
+	cache.Set("d", &QueryResult{ModelId: "d"}, -time.Second)
+	if _, ok := cache.Get("d"); ok {
+		t.Errorf("expired entry should not be returned")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("unexpected cache size %d", cache.Len())
+	}
+}
+
+func TestSQLiteQueryCache(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	if _, err := repo.DB.Exec(`insert into tag (id, name) values ('t1','tag1')`); err != nil {
+		t.Fatal(err)
+	}
+	orm := &CrvOrm{
+		Repo:  repo,
+		Cache: NewQueryCache(QueryCacheConf{ModelTTL: map[string]time.Duration{"tag": time.Minute, "order": time.Minute}}, nil),
+	}
+	query := func() *QueryResult {
+		many2many := FIELDTYPE_MANY2MANY
+		tagModelId := "tag"
+		res, err := orm.ExecuteQueryContext(context.Background(), &QueryParam{
+			AppDb:   "main",
+			ModelId: "order",
+			Fields:  &[]Field{{Field: "id"}, {Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId, Fields: &[]Field{{Field: "id"}, {Field: "name"}}}},
+		})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		return res
+	}
+	tagQuery := &QueryParam{AppDb: "main", ModelId: "tag", Fields: &[]Field{{Field: "id"}, {Field: "name"}}}
+
+	tags, _ := orm.ExecuteQuery(tagQuery)
+	tags.List[0]["name"] = "changed"
+	repo.DB.Exec(`update tag set name='tag2'`)
+	tags, _ = orm.ExecuteQuery(tagQuery)
+	if tags.List[0]["name"] != "tag1" {
+		t.Errorf("cached result should not be changed by the caller or the database, got %v", tags.List[0]["name"])
+	}
+	if res := query(); res.Total != 0 {
+		t.Errorf("unexpected orders %v", res.List)
+	}
+
+	//保存订单和多对多关联后，订单和中间表的缓存失效，标签查询不受影响
+	many2many := FIELDTYPE_MANY2MANY
+	tagModelId := "tag"
+	_, err := orm.ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  &[]Field{{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId}},
+		List: []map[string]interface{}{{
+			SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "o1",
+			"tags": map[string]interface{}{"list": []interface{}{map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t1"}}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	res := query()
+	if res.Total != 1 || res.List[0]["tags"].(*QueryResult).List[0]["name"] != "tag2" {
+		t.Errorf("order cache should be invalidated after save, got %v", res.List)
+	}
+	if tags, _ = orm.ExecuteQuery(tagQuery); tags.List[0]["name"] != "tag1" {
+		t.Errorf("tag cache should not be invalidated by saving orders")
+	}
+
+	_, err = orm.ExecuteSave(&SaveParam{AppDb: "main", ModelId: "tag", List: []map[string]interface{}{{SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "t1", "name": "tag3"}}})
+	if err != nil {
+		t.Fatalf("save tag failed: %v", err)
+	}
+	if tags, _ = orm.ExecuteQuery(tagQuery); tags.List[0]["name"] != "tag3" {
+		t.Errorf("tag cache should be invalidated after saving tags")
+	}
+	if res = query(); res.List[0]["tags"].(*QueryResult).List[0]["name"] != "tag3" {
+		t.Errorf("order cache should be invalidated after saving related tags")
+	}
+
+	if _, err := orm.ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "customer", Fields: &[]Field{{Field: "id"}}}); err != nil {
+		t.Fatal(err)
+	}
+	//修改之前的缓存等待过期或被淘汰
+	if orm.Cache.Cache.(*LRUCache).Len() != 5 {
+		t.Errorf("models without ttl should not be cached, cache size %d", orm.Cache.Cache.(*LRUCache).Len())
+	}
+}
+
+func TestGetQueryModelIds(t *testing.T) {
+	many2one := FIELDTYPE_MANY2ONE
+	many2many := FIELDTYPE_MANY2MANY
+	customerModelId := "customer"
+	regionModelId := "region"
+	tagModelId := "tag"
+	fields := []Field{
+		{Field: "id"},
+		{Field: "customer", FieldType: &many2one, RelatedModelId: &customerModelId, Fields: &[]Field{
+			{Field: "region", FieldType: &many2one, RelatedModelId: &regionModelId},
+		}},
+		{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId},
+	}
+	tests := []struct {
+		filter   map[string]interface{}
+		sorter   []Sorter
+		modelIds string
+	}{
+		{nil, nil, "[order]"},
+		{map[string]interface{}{"customer.name": "c1"}, nil, "[customer order]"},
+		{map[string]interface{}{Op_or: []interface{}{map[string]interface{}{"id": "o1"}, map[string]interface{}{"customer.region.name": "east"}}}, nil, "[customer order region]"},
+		{map[string]interface{}{"tags": map[string]interface{}{Op_exists: map[string]interface{}{"name": "t1"}}}, nil, "[order order_tag tag]"},
+		{map[string]interface{}{"tags": map[string]interface{}{Op_in: []interface{}{"t1"}}}, nil, "[order order_tag]"},
+		{nil, []Sorter{{Field: "customer.region.name"}}, "[customer order region]"},
+	}
+	for _, test := range tests {
+		query := &QueryParam{ModelId: "order", Fields: &[]Field{{Field: "id"}}, relationFields: &fields}
+		if test.filter != nil {
+			query.Filter = &test.filter
+		}
+		if test.sorter != nil {
+			query.Sorter = &test.sorter
+		}
+		if modelIds := fmt.Sprint(GetQueryModelIds(query)); modelIds != test.modelIds {
+			t.Errorf("%v %v: expected %s, got %s", test.filter, test.sorter, test.modelIds, modelIds)
+		}
+	}
+}
+
+func TestSQLiteQueryCacheRelationPathAndJSON(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`insert into customer (id, name) values ('c1','customer1')`,
+		`insert into "order" (id, name, customer, attrs) values ('o1','order1','c1','{"sizes":[38,39],"tag":{"name":"a"}}')`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("prepare failed: %v", err)
+		}
+	}
+	orm := &CrvOrm{
+		Repo:     repo,
+		Registry: newTestModelRegistry(t),
+		Cache:    NewQueryCache(QueryCacheConf{ModelTTL: map[string]time.Duration{"order": time.Minute}}, nil),
+	}
+
+	//过滤条件中的关联模型不在查询字段中，保存关联模型后缓存同样失效
+	filter := map[string]interface{}{"customer.name": "customer1"}
+	query := &QueryParam{AppDb: "main", ModelId: "order", Filter: &filter, Fields: &[]Field{{Field: "id"}, {Field: "attrs->$.sizes"}, {Field: "attrs->$.tag"}}}
+	res, err := orm.ExecuteQuery(query)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if res.Total != 1 {
+		t.Fatalf("unexpected orders %v", res.List)
+	}
+	res.List[0]["attrs->$.sizes"].([]interface{})[0] = float64(40)
+	res.List[0]["attrs->$.tag"].(map[string]interface{})["name"] = "b"
+	res, _ = orm.ExecuteQuery(query)
+	if fmt.Sprint(res.List[0]["attrs->$.sizes"]) != "[38 39]" || fmt.Sprint(res.List[0]["attrs->$.tag"]) != "map[name:a]" {
+		t.Errorf("cached JSON values should not be changed by the caller, got %v", res.List[0])
+	}
+
+	_, err = orm.ExecuteSave(&SaveParam{AppDb: "main", ModelId: "customer", List: []map[string]interface{}{{SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "c1", "name": "customer2"}}})
+	if err != nil {
+		t.Fatalf("save customer failed: %v", err)
+	}
+	if res, _ = orm.ExecuteQuery(query); res.Total != 0 {
+		t.Errorf("order cache should be invalidated after saving the customer in the filter, got %v", res.List)
+	}
+}
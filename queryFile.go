@@ -1,7 +1,7 @@
 package crvorm
 
 import (
-	"log/slog"
+	"context"
 )
 
 type QueryFile struct {
@@ -12,27 +12,26 @@ type QueryFile struct {
 func (queryFile *QueryFile) mergeResult(res *QueryResult, relatedRes *QueryResult, refField *Field) {
 	relatedFieldName := "row_id"
 	fieldName := refField.Field
+	if len(relatedRes.List) == 0 {
+		return
+	}
+	//文件表的row_id字段存储了本表的ID，先将文件数据按照row_id分组
+	relatedGroups := GroupRowsByField(relatedRes.List, relatedFieldName)
 	//将每一行的结果按照ID分配到不同的记录行上的关联字段上
 	//循环结果的每行数据
-	for _, relatedRow := range relatedRes.List {
-		for _, row := range res.List {
-			//一对多字段,关联表的关联字段存储了本表的ID，
-			value, ok := row[fieldName]
-			if !ok {
-				value = &QueryResult{
-					Total:   0,
-					ModelId: "core_file",
-					List:    []map[string]interface{}{},
-				}
-				row[fieldName] = value
-			}
-			slog.Debug("mergeResult", "id", row["id"], "relatedFieldName", relatedRow[relatedFieldName])
-			//所以判断本表ID的值和关联表对应关联字段的值是否相等
-			if row["id"] == relatedRow[relatedFieldName] {
-				value.(*QueryResult).Total += 1
-				value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRow)
+	for _, row := range res.List {
+		value, ok := row[fieldName]
+		if !ok {
+			value = &QueryResult{
+				Total:   0,
+				ModelId: FILE_MODEL_ID,
+				List:    []map[string]interface{}{},
 			}
+			row[fieldName] = value
 		}
+		relatedRows := relatedGroups[GetRelationKey(row["id"])]
+		value.(*QueryResult).Total += len(relatedRows)
+		value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRows...)
 	}
 }
 
@@ -105,25 +104,28 @@ func (queryFile *QueryFile) getQueryFields() *[]Field {
 	return &fields
 }
 
-func (queryFile *QueryFile) Query(repo DataRepository, parentList *QueryResult, refField *Field) error {
+func (queryFile *QueryFile) Query(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) error {
+	result, err := queryFile.fetch(ctx, repo, parentList, refField)
+	if err != nil {
+		return err
+	}
+	//更新查询结果到父级数据列表中
+	queryFile.mergeResult(parentList, result, refField)
+	return nil
+}
+
+func (queryFile *QueryFile) fetch(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) (*QueryResult, error) {
 	filter := queryFile.getFilter(parentList, refField)
 	files := queryFile.getQueryFields()
 
 	//执行查询，构造一个新的Query对象进行子表的查询，这样可以实现多层级数据表的递归查询操作
 	refQueryParam := &QueryParam{
-		ModelId:    "core_file",
+		ModelId:    FILE_MODEL_ID,
 		Pagination: refField.Pagination,
 		Filter:     filter,
 		Fields:     files,
 		AppDb:      queryFile.AppDb,
 		Sorter:     refField.Sorter,
 	}
-	result, err := ExecuteQuery(refQueryParam,repo, false)
-	//更新查询结果到父级数据列表中
-	if err != nil {
-		return err
-	}
-
-	queryFile.mergeResult(parentList, result, refField)
-	return nil
+	return ExecuteQueryContext(ctx, refQueryParam, repo, false)
 }
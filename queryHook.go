@@ -0,0 +1,157 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+)
+
+/*
+查询的钩子和SQL拦截器，用于注入租户过滤条件、审计日志、监控指标和权限检查等
+BeforeQueryHook在查询前执行，可以改写查询参数，AfterQueryHook在查询后执行，可以转换查询结果
+钩子按照添加的顺序执行，通过context传递给关联字段的查询，关联字段的查询参数和结果同样经过钩子处理
+SQLInterceptor包装DataRepository的Query和ExecWithTx，第一个拦截器在最外层，关联字段的查询和保存使用同一个DataRepository，同样经过拦截器
+*/
+
+// 改写查询参数，返回nil时使用原来的查询参数，返回错误时终止查询
+type BeforeQueryHook func(ctx context.Context, queryParam *QueryParam) (*QueryParam, error)
+
+// 转换查询结果，返回nil时使用原来的查询结果，返回错误时终止查询
+type AfterQueryHook func(ctx context.Context, queryParam *QueryParam, result *QueryResult) (*QueryResult, error)
+
+type QueryHooks struct {
+	BeforeQuery []BeforeQueryHook
+	AfterQuery  []AfterQueryHook
+}
+
+type queryHooksKey struct{}
+
+type relatedQueryKey struct{}
+
+// 设置关联字段查询时执行的钩子，顶层查询的钩子由CrvOrm执行
+func WithQueryHooks(ctx context.Context, hooks *QueryHooks) context.Context {
+	return context.WithValue(ctx, queryHooksKey{}, hooks)
+}
+
+func getQueryHooks(ctx context.Context) *QueryHooks {
+	hooks, _ := ctx.Value(queryHooksKey{}).(*QueryHooks)
+	return hooks
+}
+
+func withRelatedQuery(ctx context.Context) context.Context {
+	return context.WithValue(ctx, relatedQueryKey{}, true)
+}
+
+// 判断是否为关联字段的查询，钩子可以据此区分顶层查询和关联字段的查询
+func IsRelatedQuery(ctx context.Context) bool {
+	related, _ := ctx.Value(relatedQueryKey{}).(bool)
+	return related
+}
+
+func (hooks *QueryHooks) applyBeforeQuery(ctx context.Context, queryParam *QueryParam) (*QueryParam, error) {
+	if hooks == nil {
+		return queryParam, nil
+	}
+	for _, hook := range hooks.BeforeQuery {
+		rewritten, err := hook(ctx, queryParam)
+		if err != nil {
+			return nil, err
+		}
+		if rewritten != nil {
+			//按父记录分页的分组字段是内部设置的，钩子无法修改
+			rewritten.partitionBy = queryParam.partitionBy
+			queryParam = rewritten
+		}
+	}
+	return queryParam, nil
+}
+
+func (hooks *QueryHooks) applyAfterQuery(ctx context.Context, queryParam *QueryParam, result *QueryResult) (*QueryResult, error) {
+	if hooks == nil {
+		return result, nil
+	}
+	for _, hook := range hooks.AfterQuery {
+		transformed, err := hook(ctx, queryParam, result)
+		if err != nil {
+			return nil, err
+		}
+		if transformed != nil {
+			//合并关联数据时需要按父记录分页的分组总数
+			transformed.partitionTotals = result.partitionTotals
+			result = transformed
+		}
+	}
+	return result, nil
+}
+
+type SQLQueryFunc func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error)
+
+type SQLExecFunc func(ctx context.Context, sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error)
+
+// 拦截DataRepository执行的SQL语句，调用next继续执行，可以修改语句和参数或者不调用next直接返回
+type SQLInterceptor interface {
+	InterceptQuery(ctx context.Context, sql string, args []interface{}, next SQLQueryFunc) ([]map[string]interface{}, error)
+	InterceptExec(ctx context.Context, sql string, tx *sql.Tx, args []interface{}, next SQLExecFunc) (int64, int64, error)
+}
+
+// 通过函数实现的SQLInterceptor，函数为nil时直接调用next
+type SQLInterceptorFuncs struct {
+	Query func(ctx context.Context, sql string, args []interface{}, next SQLQueryFunc) ([]map[string]interface{}, error)
+	Exec  func(ctx context.Context, sql string, tx *sql.Tx, args []interface{}, next SQLExecFunc) (int64, int64, error)
+}
+
+func (interceptor *SQLInterceptorFuncs) InterceptQuery(ctx context.Context, sql string, args []interface{}, next SQLQueryFunc) ([]map[string]interface{}, error) {
+	if interceptor.Query == nil {
+		return next(ctx, sql, args...)
+	}
+	return interceptor.Query(ctx, sql, args, next)
+}
+
+func (interceptor *SQLInterceptorFuncs) InterceptExec(ctx context.Context, sql string, tx *sql.Tx, args []interface{}, next SQLExecFunc) (int64, int64, error) {
+	if interceptor.Exec == nil {
+		return next(ctx, sql, tx, args...)
+	}
+	return interceptor.Exec(ctx, sql, tx, args, next)
+}
+
+// 使用拦截器包装DataRepository，没有拦截器时返回原来的DataRepository
+func WithSQLInterceptors(repo DataRepository, interceptors ...SQLInterceptor) DataRepository {
+	if len(interceptors) == 0 {
+		return repo
+	}
+	return &interceptedDataRepository{DataRepository: repo, interceptors: interceptors}
+}
+
+type interceptedDataRepository struct {
+	DataRepository
+	interceptors []SQLInterceptor
+}
+
+func (repo *interceptedDataRepository) Query(sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return repo.QueryContext(context.Background(), sql, args...)
+}
+
+func (repo *interceptedDataRepository) QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	var next SQLQueryFunc = repo.DataRepository.QueryContext
+	for index := len(repo.interceptors) - 1; index >= 0; index-- {
+		interceptor, inner := repo.interceptors[index], next
+		next = func(ctx context.Context, statement string, args ...interface{}) ([]map[string]interface{}, error) {
+			return interceptor.InterceptQuery(ctx, statement, args, inner)
+		}
+	}
+	return next(ctx, sql, args...)
+}
+
+func (repo *interceptedDataRepository) ExecWithTx(sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	return repo.ExecWithTxContext(context.Background(), sql, tx, args...)
+}
+
+func (repo *interceptedDataRepository) ExecWithTxContext(ctx context.Context, statement string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	var next SQLExecFunc = repo.DataRepository.ExecWithTxContext
+	for index := len(repo.interceptors) - 1; index >= 0; index-- {
+		interceptor, inner := repo.interceptors[index], next
+		next = func(ctx context.Context, statement string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+			return interceptor.InterceptExec(ctx, statement, tx, args, inner)
+		}
+	}
+	return next(ctx, statement, tx, args...)
+}
@@ -0,0 +1,115 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteQueryHooks(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`insert into customer (id, name) values ('c1','customer1')`,
+		`insert into "order" (id, name, customer) values ('o1','order1','c1'),('o2','order2','c1')`,
+		`insert into order_line (id, order_id, product) values ('l1','o1','p1'),('l2','o1','p2')`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("prepare failed: %v", err)
+		}
+	}
+
+	var queries, execs []string
+	recorder := &SQLInterceptorFuncs{
+		Query: func(ctx context.Context, sql string, args []interface{}, next SQLQueryFunc) ([]map[string]interface{}, error) {
+			queries = append(queries, sql)
+			return next(ctx, sql, args...)
+		},
+		Exec: func(ctx context.Context, sql string, tx *sql.Tx, args []interface{}, next SQLExecFunc) (int64, int64, error) {
+			execs = append(execs, sql)
+			return next(ctx, sql, tx, args...)
+		},
+	}
+	var related []string
+	orm := &CrvOrm{
+		Repo:            repo,
+		SQLInterceptors: []SQLInterceptor{recorder, &SQLInterceptorFuncs{}},
+		Hooks: QueryHooks{
+			BeforeQuery: []BeforeQueryHook{
+				func(ctx context.Context, queryParam *QueryParam) (*QueryParam, error) {
+					if IsRelatedQuery(ctx) {
+						related = append(related, queryParam.ModelId)
+					}
+					if queryParam.ModelId == "customer" {
+						return nil, errors.New("permission denied")
+					}
+					//只允许查询产品p1的订单明细
+					if queryParam.ModelId == "order_line" {
+						rewritten := *queryParam
+						rewritten.Filter = &map[string]interface{}{Op_and: []interface{}{*queryParam.Filter, map[string]interface{}{"product": "p1"}}}
+						return &rewritten, nil
+					}
+					return nil, nil
+				},
+			},
+			AfterQuery: []AfterQueryHook{
+				func(ctx context.Context, queryParam *QueryParam, result *QueryResult) (*QueryResult, error) {
+					for _, row := range result.List {
+						if name, ok := row["name"].(string); ok {
+							row["name"] = strings.ToUpper(name)
+						}
+					}
+					return result, nil
+				},
+			},
+		},
+	}
+
+	one2many := FIELDTYPE_ONE2MANY
+	lineModelId := "order_line"
+	orderId := "order_id"
+	res, err := orm.ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Filter:  &map[string]interface{}{"id": "o1"},
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "name"},
+			{Field: "lines", FieldType: &one2many, RelatedModelId: &lineModelId, RelatedField: &orderId, Fields: &[]Field{{Field: "id"}, {Field: "order_id"}, {Field: "product"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	lines := res.List[0]["lines"].(*QueryResult)
+	if res.List[0]["name"] != "ORDER1" || lines.Total != 1 || lines.List[0]["product"] != "p1" {
+		t.Errorf("unexpected result %v %v", res.List[0], lines.List)
+	}
+	if len(related) != 1 || related[0] != "order_line" {
+		t.Errorf("unexpected related queries %v", related)
+	}
+	//汇总、数据和关联字段的查询
+	if len(queries) != 3 {
+		t.Errorf("unexpected queries %v", queries)
+	}
+
+	many2one := FIELDTYPE_MANY2ONE
+	customerModelId := "customer"
+	_, err = orm.ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  &[]Field{{Field: "id"}, {Field: "customer", FieldType: &many2one, RelatedModelId: &customerModelId, Fields: &[]Field{{Field: "id"}}}},
+	})
+	if err == nil || err.Error() != "permission denied" {
+		t.Errorf("expected error from related query hook, got %v", err)
+	}
+
+	_, err = orm.ExecuteSave(&SaveParam{AppDb: "main", ModelId: "order", List: []map[string]interface{}{{SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "o2", "name": "order3"}}})
+	if err != nil {
+		t.Fatalf("ExecuteSave failed: %v", err)
+	}
+	if len(execs) != 1 || !strings.HasPrefix(execs[0], "update") {
+		t.Errorf("unexpected execs %v", execs)
+	}
+}
@@ -1,6 +1,7 @@
 package crvorm
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 )
@@ -18,35 +19,63 @@ func (queryManyToMany *QueryManyToMany) mergeResult(res *QueryResult, relatedRes
 	relatedFieldName := relatedModelId + "_id"
 	localRelatedFieldName := queryManyToMany.ModelId + "_id"
 	fieldName := refField.Field
+	//没有关联数据时也为每一行设置空的查询结果，按父记录分页时当前页没有数据的记录同样需要总数
+	//中间表中存储了本表的ID，先将中间表数据按照本表ID分组
+	relatedGroups := GroupRowsByField(relatedRes.List, localRelatedFieldName)
 	//将每一行的结果按照ID分配到不同的记录行上的关联字段上
 	//循环结果的每行数据
-	for _, relatedRow := range relatedRes.List {
-		for _, row := range res.List {
-			//一对多字段,关联表的关联字段存储了本表的ID，
-			value, ok := row[fieldName]
-			if !ok {
-				value = &QueryResult{
-					ModelId: *(refField.RelatedModelId),
-					Total:   0,
-					List:    []map[string]interface{}{},
-				}
-				row[fieldName] = value
+	for _, row := range res.List {
+		value, ok := row[fieldName]
+		if !ok {
+			value = &QueryResult{
+				ModelId: *(refField.RelatedModelId),
+				Total:   0,
+				List:    []map[string]interface{}{},
 			}
+			row[fieldName] = value
+		}
+		key := GetRelationKey(row["id"])
+		for _, relatedRow := range relatedGroups[key] {
 			//这里的关联字段本身的值是一个多对一字段，这里取出其中的值
-			if row["id"] == relatedRow[localRelatedFieldName] {
-				relatedValue, ok := relatedRow[relatedFieldName].(*QueryResult)
-				if ok {
-					value.(*QueryResult).Total += relatedValue.Total
-					//对于多对多字段来说，这里不是取中间表的数据，而是取中间表关联的下一层表的数据
-					if relatedValue.Total > 0 {
-						value.(*QueryResult).List = append(value.(*QueryResult).List, relatedValue.List...)
-					}
+			relatedValue, ok := relatedRow[relatedFieldName].(*QueryResult)
+			if ok {
+				value.(*QueryResult).Total += relatedValue.Total
+				//对于多对多字段来说，这里不是取中间表的数据，而是取中间表关联的下一层表的数据
+				if relatedValue.Total > 0 {
+					value.(*QueryResult).List = append(value.(*QueryResult).List, relatedValue.List...)
 				}
 			}
 		}
+		//按父记录分页时，关联数据只是当前页的数据，总数取中间表分组的总数
+		if relatedRes.partitionTotals != nil {
+			value.(*QueryResult).Total = relatedRes.partitionTotals[key]
+		}
 	}
 }
 
+func (queryManyToMany *QueryManyToMany) isPerParent(refField *Field) bool {
+	return refField.Pagination != nil && refField.Pagination.PerParent
+}
+
+// 按父记录分页时，分页在中间表上进行，关联表上的过滤条件需要转换为中间表的子查询条件
+// 否则不满足过滤条件的关联数据也会占用分页的条数
+func (queryManyToMany *QueryManyToMany) getRelatedSubQuery(refField *Field, dialect Dialect) (*SubQuery, error) {
+	relatedQuery := &QueryParam{
+		AppDb:   queryManyToMany.AppDb,
+		ModelId: *(refField.RelatedModelId),
+		Filter:  refField.Filter,
+		Fields:  &[]Field{{Field: "id"}},
+	}
+	sqlParam, err := QueryToSQLPARAMWithDialect(relatedQuery, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &SubQuery{
+		SQL:  "select " + sqlParam.Fields + " from " + GetQueryTable(sqlParam.AppDb, sqlParam.ModelId, dialect) + " where " + sqlParam.Where,
+		Args: sqlParam.WhereArgs,
+	}, nil
+}
+
 func (queryManyToMany *QueryManyToMany) getFilter(parentList *QueryResult, refField *Field) *map[string]interface{} {
 	//多对多字段，将先通过一对多方式查询中间表，然后再通过中间表的多对一查询实际的关联表
 	//这里字段携带的过滤条件在查询中间表的时候不需要考虑，这些过滤条件将在后续多对一的查询中使用
@@ -82,24 +111,39 @@ func (queryManyToMany *QueryManyToMany) getRelatedQueryFields(refField *Field) *
 		Fields:         refField.Fields,
 		Sorter:         refField.Sorter,
 	}
+	//按父记录分页时，分页和过滤已经在中间表上处理
+	if queryManyToMany.isPerParent(refField) {
+		manyToOneField.Pagination = nil
+		manyToOneField.Filter = nil
+	}
 	fields := []Field{localIDField, manyToOneField}
 	return &fields
 }
 
-func (queryManyToMany *QueryManyToMany) Query(repo DataRepository, parentList *QueryResult, refField *Field) error {
+func (queryManyToMany *QueryManyToMany) Query(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) error {
+	result, err := queryManyToMany.fetch(ctx, repo, parentList, refField)
+	if err != nil {
+		return err
+	}
+	//更新查询结果到父级数据列表中
+	queryManyToMany.mergeResult(parentList, result, refField)
+	return nil
+}
+
+func (queryManyToMany *QueryManyToMany) fetch(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) (*QueryResult, error) {
 	if refField.RelatedModelId == nil {
 		slog.Error("Many2many field must have relatedModelId", "field", refField.Field, "model", queryManyToMany.ModelId)
-		return errors.New("Many2many field must have relatedModelId, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
+		return nil, errors.New("Many2many field must have relatedModelId, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
 	}
 
 	if refField.Fields == nil {
 		slog.Error("Many2many field must have fields", "field", refField.Field, "model", queryManyToMany.ModelId)
-		return errors.New("Many2many field must have fields, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
+		return nil, errors.New("Many2many field must have fields, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
 	}
 
 	if len(*refField.Fields) == 0 {
 		slog.Error("Many2many field must have fields", "field", refField.Field, "model", queryManyToMany.ModelId)
-		return errors.New("Many2many field must have fields, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
+		return nil, errors.New("Many2many field must have fields, field:" + refField.Field+" model:"+queryManyToMany.ModelId)
 	}
 
 	filter := queryManyToMany.getFilter(parentList, refField)
@@ -108,21 +152,31 @@ func (queryManyToMany *QueryManyToMany) Query(repo DataRepository, parentList *Q
 	modelId := *(refField.RelatedModelId)
 	modelId = GetRelatedModelId(queryManyToMany.ModelId, modelId, refField.AssociationModelId)
 
+	if queryManyToMany.isPerParent(refField) && refField.Filter != nil {
+		subQuery, err := queryManyToMany.getRelatedSubQuery(refField, repo.GetDialect())
+		if err != nil {
+			return nil, err
+		}
+		(*filter)[*(refField.RelatedModelId)+"_id"] = map[string]interface{}{Op_in: subQuery}
+	}
+
 	fields := queryManyToMany.getRelatedQueryFields(refField)
+	//中间表可能使用两个关联字段作为复合主键而没有id字段，按照两个关联字段排序
+	sorter := []Sorter{
+		{Field: queryManyToMany.ModelId + "_id", Order: "asc"},
+		{Field: *(refField.RelatedModelId) + "_id", Order: "asc"},
+	}
 	//执行查询，构造一个新的Query对象进行子表的查询，这样可以实现多层级数据表的递归查询操作
 	refQueryParam := &QueryParam{
 		ModelId:    modelId,
 		Filter:     filter,
 		Fields:     fields,
+		Sorter:     &sorter,
 		Pagination: refField.Pagination,
 		AppDb:      queryManyToMany.AppDb,
 	}
-	result, err := ExecuteQuery(refQueryParam,repo, false)
-	//更新查询结果到父级数据列表中
-	if err != nil {
-		return err
+	if queryManyToMany.isPerParent(refField) {
+		refQueryParam.partitionBy = queryManyToMany.ModelId + "_id"
 	}
-
-	queryManyToMany.mergeResult(parentList, result, refField)
-	return nil
+	return ExecuteQueryContext(ctx, refQueryParam, repo, false)
 }
@@ -1,6 +1,7 @@
 package crvorm
 
 import (
+	"context"
 	"log/slog"
 	"errors"
 )
@@ -13,31 +14,32 @@ type QueryManyToOne struct {
 func (queryManyToOne *QueryManyToOne) mergeResult(res *QueryResult, relatedRes *QueryResult, refField *Field) {
 	relatedFieldName := "id"
 	fieldName := refField.Field
+	if len(relatedRes.List) == 0 {
+		return
+	}
+	//先将关联数据按照ID分组
+	relatedGroups := GroupRowsByField(relatedRes.List, relatedFieldName)
 	//将每一行的结果按照ID分配到不同的记录行上的关联字段上
 	//循环结果的每行数据
-	for _, relatedRow := range relatedRes.List {
-		for _, row := range res.List {
-			value := row[fieldName]
-			strValue := ""
-			switch value.(type) {
-			case string:
-				strValue = value.(string)
-				value = &QueryResult{
-					ModelId: *(refField.RelatedModelId),
-					Total:   0,
-					Value:   &strValue,
-					List:    []map[string]interface{}{},
-				}
-				row[fieldName] = value
-			case *QueryResult:
-				strValue = *(value.(*QueryResult).Value)
-			}
-
-			if strValue == relatedRow[relatedFieldName] {
-				value.(*QueryResult).Total += 1
-				value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRow)
+	for _, row := range res.List {
+		value := row[fieldName]
+		strValue, ok := getRelationKeyString(value)
+		if !ok {
+			continue
+		}
+		if _, merged := value.(*QueryResult); !merged {
+			value = &QueryResult{
+				ModelId: *(refField.RelatedModelId),
+				Total:   0,
+				Value:   &strValue,
+				List:    []map[string]interface{}{},
 			}
+			row[fieldName] = value
 		}
+
+		relatedRows := relatedGroups[strValue]
+		value.(*QueryResult).Total += len(relatedRows)
+		value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRows...)
 	}
 }
 
@@ -64,26 +66,45 @@ func (queryManyToOne *QueryManyToOne) getFilter(parentList *QueryResult, refFiel
 	return &filter
 }
 
-func (queryManyToOne *QueryManyToOne) Query(repo DataRepository, parentList *QueryResult, refField *Field) error {
+func (queryManyToOne *QueryManyToOne) Query(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) error {
+	result, err := queryManyToOne.fetch(ctx, repo, parentList, refField)
+	if err != nil || result == nil {
+		return err
+	}
+	//更新查询结果到父级数据列表中
+	queryManyToOne.mergeResult(parentList, result, refField)
+	return nil
+}
+
+func (queryManyToOne *QueryManyToOne) fetch(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) (*QueryResult, error) {
 	if refField.RelatedModelId == nil {
 		slog.Error("Many2one field must have relatedModelId", "field", refField.Field, "model", queryManyToOne.ModelId)
-		return errors.New("Many2one field must have relatedModelId, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
+		return nil, errors.New("Many2one field must have relatedModelId, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
 	}
 
 	if refField.Fields == nil {
 		slog.Error("Many2one field must have fields", "field", refField.Field, "model", queryManyToOne.ModelId)
-		return errors.New("Many2one field must have fields, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
+		return nil, errors.New("Many2one field must have fields, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
 	}
 
 	if len(*refField.Fields) == 0 {
 		slog.Error("Many2one field must have fields", "field", refField.Field, "model", queryManyToOne.ModelId)
-		return errors.New("Many2one field must have fields, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
+		return nil, errors.New("Many2one field must have fields, field:" + refField.Field+" model:"+queryManyToOne.ModelId)
 	}
 	//slog.Info("queryManyToOne", "query", "queryManyToOne","parentList",parentList,"refField",refField)
 	filter := queryManyToOne.getFilter(parentList, refField)
 	if filter == nil {
 		slog.Error("Many2one field filter is nil", "field", refField.Field, "model", queryManyToOne.ModelId)
-		return nil
+		return nil, nil
+	}
+
+	//未指定分页时按照关联ID的数量查询，避免默认分页条数截断关联数据
+	pagination := refField.Pagination
+	if pagination == nil {
+		pagination = &Pagination{
+			Current:  1,
+			PageSize: len(GetFieldValues(parentList, refField.Field)),
+		}
 	}
 
 	//执行查询，构造一个新的Query对象进行子表的查询，这样可以实现多层级数据表的递归查询操作
@@ -91,16 +112,9 @@ func (queryManyToOne *QueryManyToOne) Query(repo DataRepository, parentList *Que
 		ModelId:    *(refField.RelatedModelId),
 		Filter:     filter,
 		Fields:     refField.Fields,
-		Pagination: refField.Pagination,
+		Pagination: pagination,
 		AppDb:      queryManyToOne.AppDb,
 		Sorter:     refField.Sorter,
 	}
-	result, err := ExecuteQuery(refQueryParam,repo,false)
-	//更新查询结果到父级数据列表中
-	if err != nil {
-		return err
-	}
-
-	queryManyToOne.mergeResult(parentList, result, refField)
-	return nil
+	return ExecuteQueryContext(ctx, refQueryParam, repo, false)
 }
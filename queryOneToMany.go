@@ -1,6 +1,7 @@
 package crvorm
 
 import (
+	"context"
 	"log/slog"
 	"errors"
 )
@@ -13,26 +14,29 @@ type QueryOneToMany struct {
 func (queryOneToMany *QueryOneToMany) mergeResult(res *QueryResult, relatedRes *QueryResult, refField *Field) {
 	relatedFieldName := *(refField.RelatedField)
 	fieldName := refField.Field
+	//没有关联数据时也为每一行设置空的查询结果，按父记录分页时当前页没有数据的记录同样需要总数
+	//一对多字段,关联表的关联字段存储了本表的ID，先将关联数据按照关联字段的值分组
+	relatedGroups := GroupRowsByField(relatedRes.List, relatedFieldName)
 	//将每一行的结果按照ID分配到不同的记录行上的关联字段上
 	//循环结果的每行数据
-	for _, relatedRow := range relatedRes.List {
-		for _, row := range res.List {
-			//一对多字段,关联表的关联字段存储了本表的ID，
-			value, ok := row[fieldName]
-			if !ok {
-				value = &QueryResult{
-					ModelId: *(refField.RelatedModelId),
-					Total:   0,
-					List:    []map[string]interface{}{},
-				}
-				row[fieldName] = value
-			}
-			//slog.Debug("mergeResult","id",row["id"],"relatedFieldName",relatedRow[relatedFieldName])
-			//所以判断本表ID的值和关联表对应关联字段的值是否相等
-			if row["id"] == relatedRow[relatedFieldName] {
-				value.(*QueryResult).Total += 1
-				value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRow)
+	for _, row := range res.List {
+		value, ok := row[fieldName]
+		if !ok {
+			value = &QueryResult{
+				ModelId: *(refField.RelatedModelId),
+				Total:   0,
+				List:    []map[string]interface{}{},
 			}
+			row[fieldName] = value
+		}
+		//本表ID的值对应的分组就是当前行的关联数据
+		key := GetRelationKey(row["id"])
+		relatedRows := relatedGroups[key]
+		value.(*QueryResult).Total += len(relatedRows)
+		value.(*QueryResult).List = append(value.(*QueryResult).List, relatedRows...)
+		//按父记录分页时，关联数据只是当前页的数据，总数取分组的总数
+		if relatedRes.partitionTotals != nil {
+			value.(*QueryResult).Total = relatedRes.partitionTotals[key]
 		}
 	}
 }
@@ -56,25 +60,35 @@ func (queryOneToMany *QueryOneToMany) getFilter(parentList *QueryResult, refFiel
 	return &filter
 }
 
-func (queryOneToMany *QueryOneToMany) Query(repo DataRepository, parentList *QueryResult, refField *Field) error {
+func (queryOneToMany *QueryOneToMany) Query(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) error {
+	result, err := queryOneToMany.fetch(ctx, repo, parentList, refField)
+	if err != nil {
+		return err
+	}
+	//更新查询结果到父级数据列表中
+	queryOneToMany.mergeResult(parentList, result, refField)
+	return nil
+}
+
+func (queryOneToMany *QueryOneToMany) fetch(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) (*QueryResult, error) {
 	if refField.RelatedModelId == nil {
 		slog.Error("One2many field must have relatedModelId", "field", refField.Field, "model", queryOneToMany.ModelId)
-		return errors.New("Many2one field must have relatedModelId, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
+		return nil, errors.New("Many2one field must have relatedModelId, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
 	}
 
 	if refField.RelatedField == nil {
 		slog.Error("One2many field must have RelatedField", "field", refField.Field, "model", queryOneToMany.ModelId)
-		return errors.New("Many2one field must have RelatedField, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
+		return nil, errors.New("Many2one field must have RelatedField, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
 	}
 
 	if refField.Fields == nil {
 		slog.Error("One2many field must have fields", "field", refField.Field, "model", queryOneToMany.ModelId)
-		return errors.New("One2many field must have fields, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
+		return nil, errors.New("One2many field must have fields, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
 	}
 
 	if len(*refField.Fields) == 0 {
 		slog.Error("One2many field must have fields", "field", refField.Field, "model", queryOneToMany.ModelId)
-		return errors.New("One2many field must have fields, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
+		return nil, errors.New("One2many field must have fields, field:" + refField.Field+" model:"+queryOneToMany.ModelId)
 	}
 
 	filter := queryOneToMany.getFilter(parentList, refField)
@@ -88,12 +102,9 @@ func (queryOneToMany *QueryOneToMany) Query(repo DataRepository, parentList *Que
 		AppDb:      queryOneToMany.AppDb,
 		Sorter:     refField.Sorter,
 	}
-	result, err := ExecuteQuery(refQueryParam, repo, false)
-	//更新查询结果到父级数据列表中
-	if err != nil {
-		return err
+	//按父记录分页时，按照关联字段分组后在每个分组内分页
+	if refField.Pagination != nil && refField.Pagination.PerParent {
+		refQueryParam.partitionBy = *(refField.RelatedField)
 	}
-
-	queryOneToMany.mergeResult(parentList, result, refField)
-	return nil
+	return ExecuteQueryContext(ctx, refQueryParam, repo, false)
 }
@@ -1,7 +1,11 @@
 package crvorm
 
 import (
-	//"log/slog"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
 )
 
 const (
@@ -12,7 +16,133 @@ const (
 )
 
 type QueryRelatedModel interface {
-	Query(repo DataRepository, parentList *QueryResult, refField *Field) error
+	Query(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) error
+}
+
+// 将关联查询拆分为查询和合并两个阶段，查询阶段只读取父级数据，可以并发执行
+// 合并阶段会修改父级数据行，需要依次执行
+type relatedModelFetcher interface {
+	fetch(ctx context.Context, repo DataRepository, parentList *QueryResult, refField *Field) (*QueryResult, error)
+	mergeResult(res *QueryResult, relatedRes *QueryResult, refField *Field)
+}
+
+// 同一层级的关联字段并发查询时的默认最大并发数，小于等于1时依次查询
+// 每一层级的关联查询分别限制并发数
+var DefaultRelatedQueryConcurrency = 4
+
+type relatedQueryConcurrencyKey struct{}
+
+// 设置本次查询中同一层级的关联字段并发查询的最大并发数
+func WithRelatedQueryConcurrency(ctx context.Context, concurrency int) context.Context {
+	return context.WithValue(ctx, relatedQueryConcurrencyKey{}, concurrency)
+}
+
+func getRelatedQueryConcurrency(ctx context.Context) int {
+	if concurrency, ok := ctx.Value(relatedQueryConcurrencyKey{}).(int); ok {
+		return concurrency
+	}
+	return DefaultRelatedQueryConcurrency
+}
+
+// 查询所有关联字段的数据并合并到查询结果中
+// 各字段的查询并发执行，任意一个字段查询失败时取消其它字段的查询并返回第一个错误
+func QueryRelatedModels(ctx context.Context, appDb string, modelId string, fields []Field, repo DataRepository, result *QueryResult) error {
+	ctx = withRelatedQuery(ctx)
+	type relatedQuery struct {
+		field   *Field
+		querier QueryRelatedModel
+		result  *QueryResult
+	}
+
+	queries := []*relatedQuery{}
+	for index := range fields {
+		field := &fields[index]
+		//由于MANY_TO_MANY和ONE_TO_MANY字段本身不对应实际数据库表中的字段，
+		//需要单独处理，所以先将这两个类型的字段过滤掉
+		if field.FieldType == nil {
+			continue
+		}
+		slog.Debug("fieldType", "fieldType", *field.FieldType, "field", field.Field)
+		querier := GetRelatedModelQuerier(appDb, modelId, *field.FieldType)
+		if querier == nil {
+			continue
+		}
+		queries = append(queries, &relatedQuery{field: field, querier: querier})
+	}
+
+	concurrency := getRelatedQueryConcurrency(ctx)
+	if concurrency <= 1 || len(queries) <= 1 {
+		for _, query := range queries {
+			if err := query.querier.Query(ctx, repo, result, query.field); err != nil {
+				slog.Error("Query relatedmodel failed", "error", err, "field", query.field.Field, "model", modelId)
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	workers := make(chan struct{}, concurrency)
+	for _, query := range queries {
+		fetcher, ok := query.querier.(relatedModelFetcher)
+		if !ok {
+			continue
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(query *relatedQuery, fetcher relatedModelFetcher) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			res, err := fetcher.fetch(ctx, repo, result, query.field)
+			if err != nil {
+				slog.Error("Query relatedmodel failed", "error", err, "field", query.field.Field, "model", modelId)
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			query.result = res
+		}(query, fetcher)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	//调用方取消查询时，未启动的字段查询不会执行
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	//依次合并各字段的查询结果，不支持拆分查询的关联字段在这里依次查询
+	for _, query := range queries {
+		fetcher, ok := query.querier.(relatedModelFetcher)
+		if !ok {
+			if err := query.querier.Query(ctx, repo, result, query.field); err != nil {
+				slog.Error("Query relatedmodel failed", "error", err, "field", query.field.Field, "model", modelId)
+				return err
+			}
+			continue
+		}
+		if query.result != nil {
+			fetcher.mergeResult(result, query.result, query.field)
+		}
+	}
+	return nil
 }
 
 func GetRelatedModelId(
@@ -55,12 +185,62 @@ func GetRelatedModelQuerier(appDb string, modelId string,fieldType string) Query
 	return nil
 }
 
+// 获取用于匹配关联数据的键值，值为nil时返回nil，其它值统一转换为字符串
+// 不同数据库和驱动返回的ID类型可能不同，如本表ID为int64，关联表的关联字段为字符串，转换为字符串后可以互相匹配
+func GetRelationKey(value interface{}) interface{} {
+	key, ok := getRelationKeyString(value)
+	if !ok {
+		return nil
+	}
+	return key
+}
+
+func getRelationKeyString(value interface{}) (string, bool) {
+	switch value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value.(string), true
+	case []byte:
+		return string(value.([]byte)), true
+	case int:
+		return strconv.Itoa(value.(int)), true
+	case int32:
+		return strconv.FormatInt(int64(value.(int32)), 10), true
+	case int64:
+		return strconv.FormatInt(value.(int64), 10), true
+	case uint64:
+		return strconv.FormatUint(value.(uint64), 10), true
+	case float64:
+		return strconv.FormatFloat(value.(float64), 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(value.(float32)), 'f', -1, 32), true
+	case *QueryResult:
+		//已经合并了关联数据的多对一字段，取其中保存的ID
+		if value.(*QueryResult).Value == nil {
+			return "", false
+		}
+		return *value.(*QueryResult).Value, true
+	}
+	return fmt.Sprint(value), true
+}
+
+// 按照字段的值对数据行分组，分组内保持数据行原来的顺序
+// 合并关联数据时通过分组直接查找每行对应的关联数据，避免对每行数据都遍历全部关联数据
+func GroupRowsByField(rows []map[string]interface{}, fieldName string) map[interface{}][]map[string]interface{} {
+	groups := make(map[interface{}][]map[string]interface{})
+	for _, row := range rows {
+		key := GetRelationKey(row[fieldName])
+		groups[key] = append(groups[key], row)
+	}
+	return groups
+}
+
+// 获取数据行中字段的值，数值类型的ID转换为字符串
 func GetFieldValues(res *QueryResult, fieldName string) []string {
 	var valList []string
 	for _, row := range res.List {
-		if row[fieldName] != nil {
-			//slog.Info("Field value: ","fieldName",fieldName, "value",row[fieldName])
-			sVal := row[fieldName].(string)
+		if sVal, ok := getRelationKeyString(row[fieldName]); ok {
 			valList = append(valList, sVal)
 		}
 	}
@@ -0,0 +1,152 @@
+package crvorm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newMergeTestRows(parentCount int, childCount int) (*QueryResult, *QueryResult) {
+	parents := &QueryResult{ModelId: "order", List: make([]map[string]interface{}, parentCount)}
+	for i := 0; i < parentCount; i++ {
+		parents.List[i] = map[string]interface{}{
+			"id":       fmt.Sprintf("o%d", i),
+			"customer": fmt.Sprintf("c%d", i%100),
+		}
+	}
+
+	children := &QueryResult{ModelId: "order_line", List: make([]map[string]interface{}, childCount)}
+	for i := 0; i < childCount; i++ {
+		children.List[i] = map[string]interface{}{
+			"id":       fmt.Sprintf("l%d", i),
+			"order_id": fmt.Sprintf("o%d", i%parentCount),
+			"row_id":   fmt.Sprintf("o%d", i%parentCount),
+		}
+	}
+	return parents, children
+}
+
+func TestMergeResult(t *testing.T) {
+	relatedModelId := "order_line"
+	relatedField := "order_id"
+	refField := &Field{Field: "lines", RelatedModelId: &relatedModelId, RelatedField: &relatedField}
+
+	parents, children := newMergeTestRows(3, 7)
+	(&QueryOneToMany{ModelId: "order"}).mergeResult(parents, children, refField)
+	for index, expected := range []int{3, 2, 2} {
+		lines := parents.List[index]["lines"].(*QueryResult)
+		if lines.Total != expected || len(lines.List) != expected {
+			t.Errorf("one2many row %d: expected %d lines, got %d", index, expected, lines.Total)
+		}
+	}
+	if parents.List[0]["lines"].(*QueryResult).List[1]["id"] != "l3" {
+		t.Errorf("one2many should keep the order of related rows")
+	}
+
+	parents, children = newMergeTestRows(3, 7)
+	fileField := &Field{Field: "files"}
+	(&QueryFile{ModelId: "order"}).mergeResult(parents, children, fileField)
+	if files := parents.List[2]["files"].(*QueryResult); files.Total != 2 {
+		t.Errorf("file: expected 2 files, got %d", files.Total)
+	}
+
+	customerModelId := "customer"
+	customerField := &Field{Field: "customer", RelatedModelId: &customerModelId}
+	parents, _ = newMergeTestRows(3, 0)
+	customers := &QueryResult{List: []map[string]interface{}{{"id": "c1"}, {"id": "c2"}}}
+	(&QueryManyToOne{ModelId: "order"}).mergeResult(parents, customers, customerField)
+	if customer := parents.List[0]["customer"].(*QueryResult); customer.Total != 0 || *customer.Value != "c0" {
+		t.Errorf("many2one: unexpected customer of row 0: %+v", customer)
+	}
+	if customer := parents.List[1]["customer"].(*QueryResult); customer.Total != 1 || customer.List[0]["id"] != "c1" {
+		t.Errorf("many2one: unexpected customer of row 1: %+v", customer)
+	}
+
+	tagModelId := "tag"
+	tagField := &Field{Field: "tags", RelatedModelId: &tagModelId}
+	parents, _ = newMergeTestRows(2, 0)
+	associations := &QueryResult{List: []map[string]interface{}{
+		{"order_id": "o0", "tag_id": &QueryResult{Total: 1, List: []map[string]interface{}{{"id": "t1"}}}},
+		{"order_id": "o0", "tag_id": &QueryResult{Total: 1, List: []map[string]interface{}{{"id": "t2"}}}},
+		{"order_id": "o1", "tag_id": &QueryResult{Total: 0, List: []map[string]interface{}{}}},
+	}}
+	(&QueryManyToMany{ModelId: "order"}).mergeResult(parents, associations, tagField)
+	if tags := parents.List[0]["tags"].(*QueryResult); tags.Total != 2 || tags.List[1]["id"] != "t2" {
+		t.Errorf("many2many: unexpected tags of row 0: %+v", tags)
+	}
+	if tags := parents.List[1]["tags"].(*QueryResult); tags.Total != 0 {
+		t.Errorf("many2many: unexpected tags of row 1: %+v", tags)
+	}
+}
+
+// 合并的耗时应与父表和子表的数据行数之和成线性关系
+func BenchmarkMergeOneToMany(b *testing.B) {
+	relatedModelId := "order_line"
+	relatedField := "order_id"
+	refField := &Field{Field: "lines", RelatedModelId: &relatedModelId, RelatedField: &relatedField}
+	queryOneToMany := &QueryOneToMany{ModelId: "order"}
+
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				parents, children := newMergeTestRows(size, size)
+				b.StartTimer()
+				queryOneToMany.mergeResult(parents, children, refField)
+			}
+		})
+	}
+}
+
+func BenchmarkMergeManyToOne(b *testing.B) {
+	customerModelId := "customer"
+	refField := &Field{Field: "customer", RelatedModelId: &customerModelId}
+	queryManyToOne := &QueryManyToOne{ModelId: "order"}
+
+	for _, size := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			customers := &QueryResult{List: make([]map[string]interface{}, size)}
+			for i := 0; i < size; i++ {
+				customers.List[i] = map[string]interface{}{"id": fmt.Sprintf("c%d", i)}
+			}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				parents, _ := newMergeTestRows(size, 0)
+				b.StartTimer()
+				queryManyToOne.mergeResult(parents, customers, refField)
+			}
+		})
+	}
+}
+
+func TestMergeResultNumericIds(t *testing.T) {
+	relatedModelId := "order_line"
+	relatedField := "order_id"
+	refField := &Field{Field: "lines", RelatedModelId: &relatedModelId, RelatedField: &relatedField}
+	parents := &QueryResult{List: []map[string]interface{}{{"id": int64(1), "customer": int64(7)}, {"id": int64(2), "customer": nil}}}
+	children := &QueryResult{List: []map[string]interface{}{{"id": "l1", "order_id": "1"}, {"id": "l2", "order_id": float64(2)}, {"id": "l3", "order_id": int64(1)}}}
+
+	if ids := GetFieldValues(parents, "id"); fmt.Sprint(ids) != "[1 2]" {
+		t.Errorf("unexpected ids %v", ids)
+	}
+	(&QueryOneToMany{ModelId: "order"}).mergeResult(parents, children, refField)
+	if lines := parents.List[0]["lines"].(*QueryResult); lines.Total != 2 || lines.List[1]["id"] != "l3" {
+		t.Errorf("one2many: unexpected lines of row 0: %+v", lines)
+	}
+	if lines := parents.List[1]["lines"].(*QueryResult); lines.Total != 1 {
+		t.Errorf("one2many: unexpected lines of row 1: %+v", lines)
+	}
+
+	customerModelId := "customer"
+	customerField := &Field{Field: "customer", RelatedModelId: &customerModelId}
+	if ids := GetFieldValues(parents, "customer"); fmt.Sprint(ids) != "[7]" {
+		t.Errorf("unexpected customer ids %v", ids)
+	}
+	customers := &QueryResult{List: []map[string]interface{}{{"id": "7"}}}
+	(&QueryManyToOne{ModelId: "order"}).mergeResult(parents, customers, customerField)
+	if customer := parents.List[0]["customer"].(*QueryResult); customer.Total != 1 || *customer.Value != "7" {
+		t.Errorf("many2one: unexpected customer of row 0: %+v", customer)
+	}
+	if parents.List[1]["customer"] != nil {
+		t.Errorf("many2one: null customer should stay null, got %v", parents.List[1]["customer"])
+	}
+}
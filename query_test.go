@@ -1,8 +1,15 @@
 package crvorm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var fields = &[]Field{
@@ -56,12 +63,508 @@ func TestQueryToSQLPARAM(t *testing.T) {
 	fmt.Println("modelId:", sqlParam.ModelId)
 	fmt.Println("fields:", sqlParam.Fields)
 	fmt.Println("where:", sqlParam.Where)
+	fmt.Println("whereArgs:", sqlParam.WhereArgs)
 	fmt.Println("limit:", sqlParam.Limit)
 	fmt.Println("sorter:", sqlParam.Sorter)
 
-	sql := SQLParamToSummarizeSQL(sqlParam)
-	fmt.Println(sql)
+	sql, args := SQLParamToSummarizeSQL(sqlParam)
+	fmt.Println(sql, args)
 
-	sql = SQLParamToDataSQL(sqlParam)
-	fmt.Println(sql)
+	sql, args = SQLParamToDataSQL(sqlParam)
+	fmt.Println(sql, args)
+}
+
+func TestFilterToSQLWhereArgs(t *testing.T) {
+	fc := &FilterConverter{}
+	where, args, err := fc.FilterToSQLWhere(&map[string]interface{}{
+		"name": map[string]interface{}{
+			"Op.eq": "it's a \\ test",
+		},
+	})
+	if err != nil {
+		t.Fatalf("FilterToSQLWhere failed: %v", err)
+	}
+
+	if where != "`name` = ?" {
+		t.Errorf("unexpected where: %q", where)
+	}
+
+	if len(args) != 1 || args[0] != "it's a \\ test" {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestDataSQLArgsOrder(t *testing.T) {
+	values := []string{"b", "a"}
+	query := &QueryParam{
+		Fields:  fields,
+		ModelId: "user",
+		AppDb:   "app",
+		Filter: &map[string]interface{}{
+			"id": []interface{}{"1", "2"},
+		},
+		Sorter: &[]Sorter{
+			{Field: "name", Order: "asc", Values: &values},
+		},
+	}
+
+	sqlParam, err := QueryToSQLPARAM(query)
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAM failed: %v", err)
+	}
+
+	sql, args := SQLParamToDataSQL(sqlParam)
+	expectedSQL := "select `id`,`name` from `app`.`user` where `id` in (?,?) order by FIELD(`name`,?,?) asc limit 0,1000"
+	if sql != expectedSQL {
+		t.Errorf("unexpected sql: %q", sql)
+	}
+
+	expectedArgs := []interface{}{"1", "2", "b", "a"}
+	if fmt.Sprint(args) != fmt.Sprint(expectedArgs) {
+		t.Errorf("unexpected args: %v", args)
+	}
+}
+
+func TestQueryToSQLPARAMValidation(t *testing.T) {
+	badSummarize := "sum(amount);drop table user"
+	countAll := "count(*)"
+	tests := []struct {
+		name  string
+		query *QueryParam
+		kind  string
+	}{
+		{
+			name:  "appDb",
+			query: &QueryParam{AppDb: "app;drop", ModelId: "user", Fields: fields},
+			kind:  INVALID_KIND_APPDB,
+		},
+		{
+			name:  "modelId",
+			query: &QueryParam{ModelId: "user u", Fields: fields},
+			kind:  INVALID_KIND_MODELID,
+		},
+		{
+			name:  "field",
+			query: &QueryParam{ModelId: "user", Fields: &[]Field{{Field: "(select 1)"}}},
+			kind:  INVALID_KIND_FIELD,
+		},
+		{
+			name:  "filter field",
+			query: &QueryParam{ModelId: "user", Fields: fields, Filter: &map[string]interface{}{"1=1 or name": "x"}},
+			kind:  INVALID_KIND_FIELD,
+		},
+		{
+			name:  "sorter field",
+			query: &QueryParam{ModelId: "user", Fields: fields, Sorter: &[]Sorter{{Field: "name`", Order: "asc"}}},
+			kind:  INVALID_KIND_FIELD,
+		},
+		{
+			name:  "sorter order",
+			query: &QueryParam{ModelId: "user", Fields: fields, Sorter: &[]Sorter{{Field: "name", Order: "asc, sleep(1)"}}},
+			kind:  INVALID_KIND_SORTER,
+		},
+		{
+			name:  "summarize",
+			query: &QueryParam{ModelId: "user", Fields: &[]Field{{Field: "total", Summarize: &badSummarize}}},
+			kind:  INVALID_KIND_SUMMARIZE,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := QueryToSQLPARAM(test.query)
+		var validationErr *QueryValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected QueryValidationError, got %v", test.name, err)
+			continue
+		}
+		if validationErr.Kind != test.kind {
+			t.Errorf("%s: expected kind %s, got %s", test.name, test.kind, validationErr.Kind)
+		}
+	}
+
+	sqlParam, err := QueryToSQLPARAM(&QueryParam{
+		ModelId: "user",
+		Fields:  &[]Field{{Field: "id"}, {Field: "total", Summarize: &countAll}},
+		Sorter:  &[]Sorter{{Field: "name", Order: "DESC"}},
+	})
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAM failed: %v", err)
+	}
+	if sqlParam.Summarize != "count(*) as `total`," {
+		t.Errorf("unexpected summarize: %q", sqlParam.Summarize)
+	}
+	if sqlParam.Sorter != "`name` desc" {
+		t.Errorf("unexpected sorter: %q", sqlParam.Sorter)
+	}
+}
+
+// 通过queryFunc返回查询结果的DataRepository，用于不连接数据库的测试
+type mockDataRepository struct {
+	recordDataRepository
+	queryFunc func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error)
+}
+
+func (repo *mockDataRepository) Query(sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return repo.QueryContext(context.Background(), sql, args...)
+}
+
+func (repo *mockDataRepository) QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return repo.queryFunc(ctx, sql, args...)
+}
+
+type testContextKey struct{}
+
+func TestExecuteQueryContext(t *testing.T) {
+	many2one := FIELDTYPE_MANY2ONE
+	relatedModelId := "customer"
+	query := &QueryParam{
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{
+				Field:          "customer",
+				FieldType:      &many2one,
+				RelatedModelId: &relatedModelId,
+				Fields:         &[]Field{{Field: "id"}, {Field: "name"}},
+			},
+		},
+	}
+
+	var sqls []string
+	repo := &mockDataRepository{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+			if ctx.Value(testContextKey{}) != "request" {
+				t.Errorf("context not propagated to sql: %s", sql)
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			sqls = append(sqls, sql)
+			if strings.Contains(sql, "`customer` where") {
+				return []map[string]interface{}{{"id": "c1", "name": "customer1"}}, nil
+			}
+			return []map[string]interface{}{{"id": "o1", "customer": "c1"}}, nil
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), testContextKey{}, "request")
+	res, err := ExecuteQueryContext(ctx, query, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQueryContext failed: %v", err)
+	}
+	if len(sqls) != 2 {
+		t.Errorf("expected 2 sqls, got %v", sqls)
+	}
+	customer, ok := res.List[0]["customer"].(*QueryResult)
+	if !ok || customer.Total != 1 {
+		t.Errorf("unexpected customer value: %v", res.List[0]["customer"])
+	}
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ExecuteQueryContext(canceledCtx, query, repo, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func getConcurrentTestQuery() *QueryParam {
+	one2many := FIELDTYPE_ONE2MANY
+	orderId := "order_id"
+	fields := []Field{{Field: "id"}}
+	for _, modelId := range []string{"line_a", "line_b", "line_c", "line_d"} {
+		relatedModelId := modelId
+		fields = append(fields, Field{
+			Field:          modelId,
+			FieldType:      &one2many,
+			RelatedModelId: &relatedModelId,
+			RelatedField:   &orderId,
+			Fields:         &[]Field{{Field: "id"}, {Field: "order_id"}},
+		})
+	}
+	return &QueryParam{ModelId: "order", Fields: &fields}
+}
+
+func TestQueryRelatedModelsConcurrent(t *testing.T) {
+	var running, maxRunning int32
+	repo := &mockDataRepository{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+			if strings.Contains(sql, "`order` where") {
+				return []map[string]interface{}{{"id": "o1"}, {"id": "o2"}}, nil
+			}
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				old := atomic.LoadInt32(&maxRunning)
+				if current <= old || atomic.CompareAndSwapInt32(&maxRunning, old, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return []map[string]interface{}{{"id": "l1", "order_id": "o1"}, {"id": "l2", "order_id": "o2"}}, nil
+		},
+	}
+
+	ctx := WithRelatedQueryConcurrency(context.Background(), 2)
+	res, err := ExecuteQueryContext(ctx, getConcurrentTestQuery(), repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQueryContext failed: %v", err)
+	}
+	if maxRunning != 2 {
+		t.Errorf("expected 2 concurrent queries, got %d", maxRunning)
+	}
+	for _, row := range res.List {
+		for _, field := range []string{"line_a", "line_b", "line_c", "line_d"} {
+			lines, ok := row[field].(*QueryResult)
+			if !ok || lines.Total != 1 || lines.List[0]["order_id"] != row["id"] {
+				t.Errorf("unexpected %s of %v: %v", field, row["id"], row[field])
+			}
+		}
+	}
+}
+
+func TestQueryRelatedModelsCancelOnError(t *testing.T) {
+	queryErr := errors.New("query failed")
+	var mutex sync.Mutex
+	canceled := 0
+	repo := &mockDataRepository{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+			if strings.Contains(sql, "`order` where") {
+				return []map[string]interface{}{{"id": "o1"}}, nil
+			}
+			if strings.Contains(sql, "`line_a`") {
+				return nil, queryErr
+			}
+			select {
+			case <-ctx.Done():
+				mutex.Lock()
+				canceled++
+				mutex.Unlock()
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+				return []map[string]interface{}{}, nil
+			}
+		},
+	}
+
+	ctx := WithRelatedQueryConcurrency(context.Background(), 4)
+	_, err := ExecuteQueryContext(ctx, getConcurrentTestQuery(), repo, false)
+	if !errors.Is(err, queryErr) {
+		t.Fatalf("expected first error, got %v", err)
+	}
+	if canceled != 3 {
+		t.Errorf("expected 3 canceled queries, got %d", canceled)
+	}
+}
+
+func TestGetCursorWhere(t *testing.T) {
+	tests := []struct {
+		sorters []Sorter
+		values  []interface{}
+		dialect Dialect
+		where   string
+		args    string
+	}{
+		{[]Sorter{{Field: "id"}}, []interface{}{1}, nil, "`id` > ?", "[1]"},
+		{[]Sorter{{Field: "amount"}, {Field: "id"}}, []interface{}{1, 2}, nil, "(`amount`,`id`) > (?,?)", "[1 2]"},
+		{[]Sorter{{Field: "amount", Order: "desc"}, {Field: "id", Order: "desc"}}, []interface{}{1, 2}, nil, "(`amount` < ? or `amount` is null) or (`amount` = ? and `id` < ?)", "[1 1 2]"},
+		{[]Sorter{{Field: "amount", Order: "desc"}, {Field: "id"}}, []interface{}{1, 2}, nil, "(`amount` < ? or `amount` is null) or (`amount` = ? and `id` > ?)", "[1 1 2]"},
+		{[]Sorter{{Field: "amount"}, {Field: "id"}}, []interface{}{nil, 2}, nil, "(`amount` is not null) or (`amount` is null and `id` > ?)", "[2]"},
+		{[]Sorter{{Field: "amount", Order: "desc"}, {Field: "id"}}, []interface{}{nil, 2}, nil, "(`amount` is null and `id` > ?)", "[2]"},
+		{[]Sorter{{Field: "amount"}, {Field: "id"}}, []interface{}{1, 2}, &PostgresDialect{}, `("amount" > ? or "amount" is null) or ("amount" = ? and "id" > ?)`, "[1 1 2]"},
+		{[]Sorter{{Field: "amount", Order: "desc"}, {Field: "id"}}, []interface{}{nil, 2}, &PostgresDialect{}, `("amount" is not null) or ("amount" is null and "id" > ?)`, "[2]"},
+	}
+	for _, test := range tests {
+		where, args, err := GetCursorWhere(test.sorters, test.values, test.dialect)
+		if err != nil {
+			t.Fatalf("GetCursorWhere failed: %v", err)
+		}
+		if where != test.where || fmt.Sprint(args) != test.args {
+			t.Errorf("expected %q %s, got %q %v", test.where, test.args, where, args)
+		}
+	}
+
+	cursor, err := EncodeCursor([]interface{}{"a", int64(9007199254740993)})
+	if err != nil {
+		t.Fatalf("EncodeCursor failed: %v", err)
+	}
+	values, err := DecodeCursor(cursor, 2)
+	if err != nil || values[0] != "a" || values[1] != int64(9007199254740993) {
+		t.Errorf("unexpected decoded cursor: %v %v", values, err)
+	}
+
+	created := time.Date(2024, 1, 5, 10, 0, 0, 500, time.FixedZone("", 8*3600))
+	cursor, err = EncodeCursor([]interface{}{created, nil})
+	if err != nil {
+		t.Fatalf("EncodeCursor failed: %v", err)
+	}
+	values, err = DecodeCursor(cursor, 2)
+	if timeValue, ok := values[0].(time.Time); err != nil || !ok || !timeValue.Equal(created) || values[1] != nil {
+		t.Errorf("unexpected decoded time cursor: %v %v", values, err)
+	}
+}
+
+func TestRelationPathFilterSQL(t *testing.T) {
+	many2one := FIELDTYPE_MANY2ONE
+	many2many := FIELDTYPE_MANY2MANY
+	customerModelId := "customer"
+	tagModelId := "tag"
+	query := &QueryParam{
+		AppDb:   "app",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "customer", FieldType: &many2one, RelatedModelId: &customerModelId},
+			{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId},
+		},
+		Filter: &map[string]interface{}{
+			Op_and: []interface{}{
+				map[string]interface{}{"customer.name": map[string]interface{}{Op_eq: "c"}},
+				map[string]interface{}{"tags.name": map[string]interface{}{Op_in: []interface{}{"a", "b"}}},
+			},
+		},
+	}
+	sqlParam, err := QueryToSQLPARAM(query)
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAM failed: %v", err)
+	}
+	expected := "(`customer` in (select `id` from `app`.`customer` where `name` = ?) ) and " +
+		"(`id` in (select `order_id` from `app`.`order_tag` where `tag_id` in (select `id` from `app`.`tag` where `name` in (?,?))) )"
+	if sqlParam.Where != expected || fmt.Sprint(sqlParam.WhereArgs) != "[c a b]" {
+		t.Errorf("unexpected where %q %v", sqlParam.Where, sqlParam.WhereArgs)
+	}
+}
+
+func TestRelationPathSorterSQL(t *testing.T) {
+	many2one := FIELDTYPE_MANY2ONE
+	customerModelId := "customer"
+	query := &QueryParam{
+		AppDb:   "app",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "customer", FieldType: &many2one, RelatedModelId: &customerModelId},
+		},
+		Sorter: &[]Sorter{{Field: "customer.name", Order: "desc"}},
+	}
+	sqlParam, err := QueryToSQLPARAM(query)
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAM failed: %v", err)
+	}
+	expected := "(select `__sorter0`.`name` from `app`.`customer` `__sorter0` where `__sorter0`.`id`=`app`.`order`.`customer`) desc"
+	if sqlParam.Sorter != expected {
+		t.Errorf("unexpected sorter %q", sqlParam.Sorter)
+	}
+
+	query.Sorter = &[]Sorter{{Field: "lines.name"}}
+	if _, err := QueryToSQLPARAM(query); err == nil {
+		t.Errorf("sorter path through unknown field should fail")
+	}
+}
+
+func TestFilterToSQLWhereStableOrder(t *testing.T) {
+	filter := &map[string]interface{}{
+		"status": map[string]interface{}{Op_ne: "closed", Op_in: []interface{}{"a", "b"}, Op_like: "x"},
+		"amount": map[string]interface{}{Op_lte: 100, Op_gte: 1},
+		"name":   "n",
+		Op_or: []interface{}{
+			map[string]interface{}{"b": 2, "a": 1},
+			map[string]interface{}{"c": map[string]interface{}{Op_is: nil}},
+		},
+		"id": map[string]interface{}{Op_and: []interface{}{
+			map[string]interface{}{Op_gt: 0},
+			map[string]interface{}{Op_notIn: []interface{}{5, 6}},
+		}},
+	}
+
+	expectedWhere := "(((`a` = ?) and (`b` = ?)) or (`c` is  null )) and " +
+		"((`amount` >= ?) and (`amount` <= ?)) and " +
+		"((`id` > ?) and (`id` not in (?,?))) and " +
+		"(`name` like ?) and " +
+		"((`status` in (?,?)) and (`status` like ?) and (`status` <> ?))"
+	expectedArgs := "[1 2 1 100 0 5 6 %n% a b %x% closed]"
+	//多次转换得到完全相同的语句和参数
+	for i := 0; i < 20; i++ {
+		fc := &FilterConverter{}
+		where, args, err := fc.FilterToSQLWhere(filter)
+		if err != nil {
+			t.Fatalf("FilterToSQLWhere failed: %v", err)
+		}
+		if where != expectedWhere || fmt.Sprint(args) != expectedArgs {
+			t.Fatalf("unexpected where %q %v", where, args)
+		}
+	}
+}
+
+func TestJSONPathSQL(t *testing.T) {
+	query := &QueryParam{
+		AppDb:   "app",
+		ModelId: "product",
+		Fields:  &[]Field{{Field: "id"}, {Field: "attrs->$.color"}},
+		Filter:  &map[string]interface{}{"attrs->$.sizes[0]": map[string]interface{}{Op_gte: 38}},
+		Sorter:  &[]Sorter{{Field: "attrs->$.color", Order: "asc"}},
+	}
+	sqlParam, err := QueryToSQLPARAM(query)
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAM failed: %v", err)
+	}
+	sql, _ := SQLParamToDataSQL(sqlParam)
+	expected := "select `id`,cast(json_extract(`attrs`,'$.color') as char) as `attrs->$.color` from `app`.`product` " +
+		"where `attrs`->>'$.sizes[0]' >= ? order by `attrs`->>'$.color' asc limit 0,1000"
+	if sql != expected {
+		t.Errorf("unexpected sql %q", sql)
+	}
+
+	sqlParam, err = QueryToSQLPARAMWithDialect(query, &PostgresDialect{})
+	if err != nil {
+		t.Fatalf("QueryToSQLPARAMWithDialect failed: %v", err)
+	}
+	if sqlParam.Fields != `"id",("attrs" #> '{color}')::text as "attrs->$.color"` || sqlParam.Where != `"attrs" #>> '{sizes,0}' >= ?` {
+		t.Errorf("unexpected postgres fields %q where %q", sqlParam.Fields, sqlParam.Where)
+	}
+
+	for _, field := range []string{"attrs->color", "attrs->$.a'b", "attrs->$.a[x]", "at-trs->$.a"} {
+		query.Fields = &[]Field{{Field: field}}
+		_, err := QueryToSQLPARAM(query)
+		var validationErr *QueryValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected validation error, got %v", field, err)
+		}
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	conversion := &ValueConversion{}
+	tests := []struct {
+		typeName string
+		value    interface{}
+		expected interface{}
+	}{
+		{"INT", []byte("42"), int64(42)},
+		{"BIGINT", []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{"DECIMAL", []byte("12.50"), "12.50"},
+		{"DOUBLE", []byte("1.5"), 1.5},
+		{"TINYINT", []byte("1"), int64(1)},
+		{"BIT", []byte{1, 0}, int64(256)},
+		{"DATETIME", []byte("2024-03-01 08:30:00.5"), time.Date(2024, 3, 1, 8, 30, 0, 500000000, time.UTC)},
+		{"DATETIME", []byte("0000-00-00 00:00:00"), "0000-00-00 00:00:00"},
+		{"VARCHAR", []byte("a"), "a"},
+		{"BLOB", []byte("a"), []byte("a")},
+		{"JSON", []byte("not json"), "not json"},
+		{"INT", nil, nil},
+	}
+	for _, test := range tests {
+		value := conversion.ConvertValue(test.typeName, test.value)
+		if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%s %v: expected %#v, got %#v", test.typeName, test.value, test.expected, value)
+		}
+	}
+
+	conversion = &ValueConversion{DecimalAsFloat: true, TinyintAsBool: true}
+	if value := conversion.ConvertValue("DECIMAL", []byte("12.50")); value != 12.5 {
+		t.Errorf("expected decimal as float, got %#v", value)
+	}
+	if value := conversion.ConvertValue("TINYINT", int64(0)); value != false {
+		t.Errorf("expected tinyint as bool, got %#v", value)
+	}
 }
@@ -1,10 +1,12 @@
 package crvorm
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"log/slog"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -18,27 +20,64 @@ type DbConf struct {
 	MaxOpenConns    int    `json:"maxOpenConns" mapstructure:"maxOpenConns"`
 	MaxIdleConns    int    `json:"maxIdleConns" mapstructure:"maxIdleConns"`
 	Tls		    string   `json:"tls" mapstructure:"tls"` //skip-verify
+	//数据库类型，支持mysql、postgres、sqlite，默认为mysql
+	//postgres和sqlite需要调用方引入对应的驱动，如github.com/lib/pq和github.com/mattn/go-sqlite3
+	//sqlite使用DbName作为数据库文件路径
+	Dialect         string `json:"dialect" mapstructure:"dialect"`
+	//查询结果中值的转换方式，为nil时使用DefaultValueConversion
+	ValueConversion *ValueConversion `json:"valueConversion" mapstructure:"valueConversion"`
 }
 
+// 带Context的方法允许调用方取消执行中的语句或设置超时时间
 type DataRepository interface {
 	Begin() (*sql.Tx, error)
-	Query(sql string) ([]map[string]interface{}, error)
-	ExecWithTx(sql string, tx *sql.Tx) (int64, int64, error)
+	BeginContext(ctx context.Context) (*sql.Tx, error)
+	Query(sql string, args ...interface{}) ([]map[string]interface{}, error)
+	QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error)
+	ExecWithTx(sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error)
+	ExecWithTxContext(ctx context.Context, sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error)
+	GetDialect() Dialect
 }
 
 type DefatultDataRepository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
+	//查询结果中值的转换方式，为nil时使用DefaultValueConversion
+	ValueConversion *ValueConversion
+}
+
+func (repo *DefatultDataRepository) GetDialect() Dialect {
+	return getDialectOrDefault(repo.Dialect)
 }
 
 func (repo *DefatultDataRepository) Begin() (*sql.Tx, error) {
-	return repo.DB.Begin()
+	return repo.BeginContext(context.Background())
+}
+
+func (repo *DefatultDataRepository) BeginContext(ctx context.Context) (*sql.Tx, error) {
+	return repo.DB.BeginTx(ctx, nil)
+}
+
+func (repo *DefatultDataRepository) ExecWithTx(sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	return repo.ExecWithTxContext(context.Background(), sql, tx, args...)
 }
 
-func (repo *DefatultDataRepository) ExecWithTx(sql string, tx *sql.Tx) (int64, int64, error) {
-	//替换sql语句中的转义字符
-	sql = strings.Replace(sql, "\\", "\\\\", -1) // -1 表示替换所有匹配项
-	slog.Info(sql)
-	res, err := tx.Exec(sql)
+func (repo *DefatultDataRepository) ExecWithTxContext(ctx context.Context, sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	dialect := repo.GetDialect()
+	//没有参数时，语句中的值是直接拼接的，mysql需要替换sql语句中的转义字符
+	//使用参数时值由驱动处理，不能再做替换
+	if len(args) == 0 && dialect.Name() == DIALECT_MYSQL {
+		sql = strings.Replace(sql, "\\", "\\\\", -1) // -1 表示替换所有匹配项
+	}
+	sql = dialect.Rebind(sql)
+	slog.Info(sql, "args", args)
+
+	//不支持LastInsertId的数据库，新建语句通过returning子句获取ID
+	if !dialect.SupportsLastInsertId() && len(dialect.ReturningId()) > 0 && strings.HasSuffix(sql, dialect.ReturningId()) {
+		return repo.execReturningId(ctx, sql, tx, args...)
+	}
+
+	res, err := tx.ExecContext(ctx, sql, args...)
 	if err != nil {
 		slog.Error(err.Error())
 		return 0, 0, err
@@ -50,6 +89,10 @@ func (repo *DefatultDataRepository) ExecWithTx(sql string, tx *sql.Tx) (int64, i
 		return 0, 0, err
 	}
 
+	if !dialect.SupportsLastInsertId() {
+		return 0, rowCount, nil
+	}
+
 	//获取最后插入数据的ID
 	id, err := res.LastInsertId()
 	if err != nil {
@@ -60,8 +103,30 @@ func (repo *DefatultDataRepository) ExecWithTx(sql string, tx *sql.Tx) (int64, i
 	return id, rowCount, nil
 }
 
+func (repo *DefatultDataRepository) execReturningId(ctx context.Context, sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		slog.Error(err.Error())
+		return 0, 0, err
+	}
+	return id, 1, nil
+}
+
+// 按照列类型转换每列的值，转换方式由ValueConversion决定
 func (repo *DefatultDataRepository) rowsToMap(rows *sql.Rows) ([]map[string]interface{}, error) {
-	cols, _ := rows.Columns()
+	cols, err := rows.Columns()
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+	conversion := getValueConversionOrDefault(repo.ValueConversion)
+	typeNames := make([]string, len(cols))
+	if columnTypes, err := rows.ColumnTypes(); err == nil {
+		for i, columnType := range columnTypes {
+			typeNames[i] = getColumnTypeName(columnType)
+		}
+	}
 	columns := make([]interface{}, len(cols))
 	colPointers := make([]interface{}, len(cols))
 	for i, _ := range columns {
@@ -78,21 +143,26 @@ func (repo *DefatultDataRepository) rowsToMap(rows *sql.Rows) ([]map[string]inte
 		row := make(map[string]interface{})
 		for i, colName := range cols {
 			val := colPointers[i].(*interface{})
-			switch (*val).(type) {
-			case []byte:
-				row[colName] = string((*val).([]byte))
-			default:
-				row[colName] = *val
-			}
+			row[colName] = conversion.ConvertValue(typeNames[i], *val)
 		}
 		list = append(list, row)
 	}
+	//读取过程中出错或者context被取消时，rows.Next返回false，需要通过rows.Err区分是否读取完成
+	if err := rows.Err(); err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
 	return list, nil
 }
 
-func (repo *DefatultDataRepository) Query(sql string) ([]map[string]interface{}, error) {
-	slog.Info(sql)
-	rows, err := repo.DB.Query(sql)
+func (repo *DefatultDataRepository) Query(sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return repo.QueryContext(context.Background(), sql, args...)
+}
+
+func (repo *DefatultDataRepository) QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	sql = repo.GetDialect().Rebind(sql)
+	slog.Info(sql, "args", args)
+	rows, err := repo.DB.QueryContext(ctx, sql, args...)
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
@@ -102,11 +172,47 @@ func (repo *DefatultDataRepository) Query(sql string) ([]map[string]interface{},
 	return repo.rowsToMap(rows)
 }
 
+func getPostgresSSLMode(tls string) string {
+	switch tls {
+	case "", "false":
+		return "disable"
+	case "skip-verify", "preferred":
+		return "require"
+	case "true":
+		return "verify-full"
+	}
+	return tls
+}
+
+func getDataSourceName(dbConf *DbConf, dialect Dialect) string {
+	switch dialect.Name() {
+	case DIALECT_POSTGRES:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(dbConf.User, dbConf.Password),
+			Host:     dbConf.Server,
+			Path:     "/" + dbConf.DbName,
+			RawQuery: "sslmode=" + url.QueryEscape(getPostgresSSLMode(dbConf.Tls)),
+		}
+		return dsn.String()
+	case DIALECT_SQLITE:
+		return dbConf.DbName
+	}
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?allowNativePasswords=true&tls=%s", dbConf.User, dbConf.Password, dbConf.Server, dbConf.DbName, dbConf.Tls)
+}
+
 func (repo *DefatultDataRepository) Connect(dbConf *DbConf)(error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?allowNativePasswords=true&tls=%s", dbConf.User, dbConf.Password, dbConf.Server, dbConf.DbName, dbConf.Tls)
-	slog.Info("connect to mysql server", "dsn", dsn)
+	repo.Dialect = GetDialect(dbConf.Dialect)
+	repo.ValueConversion = dbConf.ValueConversion
+	if repo.Dialect == nil {
+		slog.Error("not supported dialect", "dialect", dbConf.Dialect)
+		return fmt.Errorf("not supported dialect %s", dbConf.Dialect)
+	}
+
+	dsn := getDataSourceName(dbConf, repo.Dialect)
+	slog.Info("connect to "+repo.Dialect.Name()+" server", "dsn", dsn)
 	var err error
-	repo.DB, err = sql.Open("mysql", dsn)
+	repo.DB, err = sql.Open(repo.Dialect.DriverName(), dsn)
 	if err != nil {
 		slog.Error(err.Error())
 		return err
@@ -121,7 +227,7 @@ func (repo *DefatultDataRepository) Connect(dbConf *DbConf)(error) {
 	repo.DB.SetConnMaxLifetime(time.Minute * time.Duration(dbConf.ConnMaxLifetime))
 	repo.DB.SetMaxOpenConns(dbConf.MaxOpenConns)
 	repo.DB.SetMaxIdleConns(dbConf.MaxIdleConns)
-	slog.Info("connect to mysql server " + dbConf.Server)
+	slog.Info("connect to " + repo.Dialect.Name() + " server " + dbConf.Server)
 
 	return nil
 }
@@ -0,0 +1,368 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 每行数据的保存方式通过_save_type字段指定
+const (
+	SAVE_TYPE_COLUMN = "_save_type"
+	SAVE_CREATE      = "create"
+	SAVE_UPDATE      = "update"
+	SAVE_DELETE      = "delete"
+)
+
+// 数据的主键字段
+const CC_ID = "id"
+
+// Fields用于说明数据中包含的关联字段，一对多、多对多和文件字段的值会在同一个事务中级联保存
+// 关联字段的值格式为{"list":[{...,"_save_type":"create"},...]}
+type SaveParam struct {
+	AppDb   string                   `json:"appDb"`
+	ModelId string                   `json:"modelId"`
+	Fields  *[]Field                 `json:"fields,omitempty"`
+	List    []map[string]interface{} `json:"list"`
+	//一对多和文件字段保存子表数据时设置，更新和删除子表数据时只处理关联到父记录的数据
+	parentScope []saveScope
+}
+
+// 更新和删除数据时附加的条件，Field字段的值必须等于Value
+type saveScope struct {
+	Field string
+	Value string
+}
+
+type SaveRowResult struct {
+	Id       string                 `json:"id"`
+	SaveType string                 `json:"saveType"`
+	Count    int64                  `json:"count"`
+	Related  map[string]*SaveResult `json:"related,omitempty"`
+}
+
+type SaveResult struct {
+	ModelId string          `json:"modelId"`
+	Total   int64           `json:"total"`
+	List    []SaveRowResult `json:"list"`
+}
+
+// 获取行数据中需要保存的字段，以下划线开头的字段为控制字段，不保存到数据库中
+// 字段按名称排序，保证相同的数据生成相同的SQL语句
+func GetSaveColumns(row map[string]interface{}) ([]string, error) {
+	columns := []string{}
+	for column, value := range row {
+		if strings.HasPrefix(column, "_") {
+			continue
+		}
+
+		if err := CheckIdentifier(INVALID_KIND_FIELD, column); err != nil {
+			return nil, err
+		}
+
+		switch value.(type) {
+		case map[string]interface{}, []interface{}, []map[string]interface{}:
+			slog.Error("GetSaveColumns not supported value type", "column", column, "value", value)
+			return nil, errors.New("not supported value type of column " + column)
+		}
+
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns, nil
+}
+
+// 数据库不支持LastInsertId且数据中没有提供ID时，在语句后增加获取ID的returning子句
+func GetInsertSQL(appDb string, modelId string, row map[string]interface{}, dialect Dialect) (string, []interface{}, error) {
+	dialect = getDialectOrDefault(dialect)
+	columns, err := GetSaveColumns(row)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(columns) == 0 {
+		slog.Error("GetInsertSQL no column to insert", "modelId", modelId)
+		return "", nil, errors.New("no column to insert, model:" + modelId)
+	}
+
+	quotedColumns := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		quotedColumns[i] = dialect.QuoteIdentifier(column)
+		args[i] = row[column]
+	}
+
+	sql := "insert into " + dialect.GetTableName(appDb, modelId) +
+		" (" + strings.Join(quotedColumns, ",") + ")" +
+		" values (" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+	if !dialect.SupportsLastInsertId() && len(getRowId(row)) == 0 {
+		sql = sql + dialect.ReturningId()
+	}
+	return sql, args, nil
+}
+
+// 生成更新语句，没有需要更新的字段时返回空字符串
+func GetUpdateSQL(appDb string, modelId string, row map[string]interface{}, dialect Dialect) (string, []interface{}, error) {
+	dialect = getDialectOrDefault(dialect)
+	id, ok := row[CC_ID]
+	if !ok || id == nil {
+		slog.Error("GetUpdateSQL row must have id", "modelId", modelId)
+		return "", nil, errors.New("update row must have id, model:" + modelId)
+	}
+
+	columns, err := GetSaveColumns(row)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sets := ""
+	args := []interface{}{}
+	for _, column := range columns {
+		if column == CC_ID {
+			continue
+		}
+		sets = sets + dialect.QuoteIdentifier(column) + "=?,"
+		args = append(args, row[column])
+	}
+
+	if len(sets) == 0 {
+		return "", nil, nil
+	}
+
+	sql := "update " + dialect.GetTableName(appDb, modelId) +
+		" set " + sets[0:len(sets)-1] +
+		" where " + dialect.QuoteIdentifier(CC_ID) + "=?"
+	args = append(args, id)
+	return sql, args, nil
+}
+
+func GetDeleteSQL(appDb string, modelId string, row map[string]interface{}, dialect Dialect) (string, []interface{}, error) {
+	dialect = getDialectOrDefault(dialect)
+	id, ok := row[CC_ID]
+	if !ok || id == nil {
+		slog.Error("GetDeleteSQL row must have id", "modelId", modelId)
+		return "", nil, errors.New("delete row must have id, model:" + modelId)
+	}
+
+	sql := "delete from " + dialect.GetTableName(appDb, modelId) +
+		" where " + dialect.QuoteIdentifier(CC_ID) + "=?"
+	return sql, []interface{}{id}, nil
+}
+
+func getRowId(row map[string]interface{}) string {
+	id, ok := row[CC_ID]
+	if !ok || id == nil {
+		return ""
+	}
+
+	switch id.(type) {
+	case string:
+		return id.(string)
+	case float64:
+		return strconv.FormatFloat(id.(float64), 'f', -1, 64)
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+// 将行数据拆分为本表字段和需要级联保存的关联字段
+// 删除数据时，所有关联字段都需要处理，以便删除中间表和文件表中对应的记录
+func splitRelatedValues(row map[string]interface{}, saveType string, fields *[]Field) (map[string]interface{}, []Field) {
+	if fields == nil {
+		return row, nil
+	}
+
+	var relatedFields []Field
+	localRow := row
+	for _, field := range *fields {
+		if field.FieldType == nil {
+			continue
+		}
+
+		fieldType := *field.FieldType
+		if fieldType != FIELDTYPE_ONE2MANY &&
+			fieldType != FIELDTYPE_MANY2MANY &&
+			fieldType != FIELDTYPE_FILE {
+			continue
+		}
+
+		if _, ok := row[field.Field]; !ok && saveType != SAVE_DELETE {
+			continue
+		}
+
+		//复制一份数据，避免修改调用方传入的数据
+		if len(relatedFields) == 0 {
+			localRow = make(map[string]interface{}, len(row))
+			for key, value := range row {
+				localRow[key] = value
+			}
+		}
+		delete(localRow, field.Field)
+		relatedFields = append(relatedFields, field)
+	}
+	return localRow, relatedFields
+}
+
+func saveRelatedValues(
+	ctx context.Context,
+	appDb string,
+	modelId string,
+	parentId string,
+	parentSaveType string,
+	row map[string]interface{},
+	relatedFields []Field,
+	rowResult *SaveRowResult,
+	repo DataRepository,
+	tx *sql.Tx) error {
+	for index := range relatedFields {
+		field := &relatedFields[index]
+		saver := GetRelatedModelSaver(appDb, modelId, *field.FieldType)
+		relatedResult, err := saver.Save(ctx, repo, tx, parentId, parentSaveType, row[field.Field], field)
+		if err != nil {
+			slog.Error("save relatedmodel failed", "error", err, "field", field.Field, "model", modelId)
+			return err
+		}
+
+		if relatedResult != nil {
+			if rowResult.Related == nil {
+				rowResult.Related = map[string]*SaveResult{}
+			}
+			rowResult.Related[field.Field] = relatedResult
+		}
+	}
+	return nil
+}
+
+func saveRow(ctx context.Context, appDb string, modelId string, fields *[]Field, row map[string]interface{}, scope []saveScope, repo DataRepository, tx *sql.Tx) (*SaveRowResult, error) {
+	saveType, _ := row[SAVE_TYPE_COLUMN].(string)
+	rowResult := &SaveRowResult{
+		Id:       getRowId(row),
+		SaveType: saveType,
+	}
+
+	localRow, relatedFields := splitRelatedValues(row, saveType, fields)
+
+	//删除数据时先处理关联数据，再删除本表数据
+	if saveType == SAVE_DELETE && len(relatedFields) > 0 {
+		err := saveRelatedValues(ctx, appDb, modelId, rowResult.Id, saveType, row, relatedFields, rowResult, repo, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var saveSQL string
+	var args []interface{}
+	var err error
+	switch saveType {
+	case SAVE_CREATE:
+		saveSQL, args, err = GetInsertSQL(appDb, modelId, localRow, repo.GetDialect())
+	case SAVE_UPDATE:
+		saveSQL, args, err = GetUpdateSQL(appDb, modelId, localRow, repo.GetDialect())
+	case SAVE_DELETE:
+		saveSQL, args, err = GetDeleteSQL(appDb, modelId, localRow, repo.GetDialect())
+	default:
+		slog.Error("saveRow not supported save type", "saveType", saveType, "modelId", modelId)
+		return nil, errors.New("not supported save type " + saveType + ", model:" + modelId)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	//更新和删除语句都以where id=?结尾，直接附加范围条件
+	if len(saveSQL) > 0 && saveType != SAVE_CREATE {
+		for _, condition := range scope {
+			saveSQL = saveSQL + " and " + repo.GetDialect().QuoteIdentifier(condition.Field) + "=?"
+			args = append(args, condition.Value)
+		}
+	}
+
+	//没有需要更新的字段时仅处理关联数据
+	if len(saveSQL) > 0 {
+		id, count, err := repo.ExecWithTxContext(ctx, saveSQL, tx, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		//新建数据时如果没有提供ID，则使用数据库生成的ID
+		if saveType == SAVE_CREATE && len(rowResult.Id) == 0 {
+			rowResult.Id = strconv.FormatInt(id, 10)
+		}
+		rowResult.Count = count
+	}
+
+	//新建和更新数据时，本表数据保存后再处理关联数据，这样新建的数据可以拿到本表的ID
+	if saveType != SAVE_DELETE && len(relatedFields) > 0 {
+		err := saveRelatedValues(ctx, appDb, modelId, rowResult.Id, saveType, row, relatedFields, rowResult, repo, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rowResult, nil
+}
+
+// 在给定的事务中保存数据，事务的提交和回滚由调用方负责
+func SaveWithTx(saveParam *SaveParam, repo DataRepository, tx *sql.Tx) (*SaveResult, error) {
+	return SaveWithTxContext(context.Background(), saveParam, repo, tx)
+}
+
+func SaveWithTxContext(ctx context.Context, saveParam *SaveParam, repo DataRepository, tx *sql.Tx) (*SaveResult, error) {
+	if len(saveParam.AppDb) > 0 {
+		if err := CheckIdentifier(INVALID_KIND_APPDB, saveParam.AppDb); err != nil {
+			return nil, err
+		}
+	}
+	if err := CheckIdentifier(INVALID_KIND_MODELID, saveParam.ModelId); err != nil {
+		return nil, err
+	}
+
+	result := &SaveResult{
+		ModelId: saveParam.ModelId,
+		Total:   0,
+		List:    []SaveRowResult{},
+	}
+
+	for _, row := range saveParam.List {
+		rowResult, err := saveRow(ctx, saveParam.AppDb, saveParam.ModelId, saveParam.Fields, row, saveParam.parentScope, repo, tx)
+		if err != nil {
+			slog.Error("SaveWithTx save row failed", "error", err, "model", saveParam.ModelId)
+			return nil, err
+		}
+		result.Total += rowResult.Count
+		result.List = append(result.List, *rowResult)
+	}
+
+	return result, nil
+}
+
+// 在一个事务中保存所有数据，任意一行保存失败时回滚整个事务
+func ExecuteSave(saveParam *SaveParam, repo DataRepository) (*SaveResult, error) {
+	return ExecuteSaveContext(context.Background(), saveParam, repo)
+}
+
+func ExecuteSaveContext(ctx context.Context, saveParam *SaveParam, repo DataRepository) (*SaveResult, error) {
+	tx, err := repo.BeginContext(ctx)
+	if err != nil {
+		slog.Error("ExecuteSave begin transaction failed", "error", err)
+		return nil, err
+	}
+
+	result, err := SaveWithTxContext(ctx, saveParam, repo, tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		slog.Error("ExecuteSave commit transaction failed", "error", err)
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+)
+
+type SaveFile struct {
+	AppDb   string `json:"appDb"`
+	ModelId string `json:"modelId"`
+}
+
+func (saveFile *SaveFile) getSaveRows(parentId string, rows []map[string]interface{}, fileField *Field) []map[string]interface{} {
+	//文件表通过model_id,field_id,row_id三个字段关联到本表的数据行
+	saveRows := make([]map[string]interface{}, len(rows))
+	for index, row := range rows {
+		saveRow := make(map[string]interface{}, len(row)+3)
+		for key, value := range row {
+			saveRow[key] = value
+		}
+
+		saveType, _ := row[SAVE_TYPE_COLUMN].(string)
+		if saveType == SAVE_CREATE || saveType == SAVE_UPDATE {
+			saveRow["model_id"] = saveFile.ModelId
+			saveRow["field_id"] = fileField.Field
+			saveRow["row_id"] = parentId
+		}
+		saveRows[index] = saveRow
+	}
+	return saveRows
+}
+
+// 删除本表数据时，同时删除本表数据对应的文件记录
+func (saveFile *SaveFile) deleteParentFiles(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, fileField *Field) (*SaveResult, error) {
+	dialect := getDialectOrDefault(repo.GetDialect())
+	sql := "delete from " + dialect.GetTableName(saveFile.AppDb, FILE_MODEL_ID) +
+		" where " + dialect.QuoteIdentifier("model_id") + "=? and " + dialect.QuoteIdentifier("field_id") + "=? and " + dialect.QuoteIdentifier("row_id") + "=?"
+	_, count, err := repo.ExecWithTxContext(ctx, sql, tx, saveFile.ModelId, fileField.Field, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SaveResult{
+		ModelId: FILE_MODEL_ID,
+		Total:   count,
+		List:    []SaveRowResult{},
+	}, nil
+}
+
+func (saveFile *SaveFile) Save(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, parentSaveType string, value interface{}, refField *Field) (*SaveResult, error) {
+	if parentSaveType == SAVE_DELETE {
+		return saveFile.deleteParentFiles(ctx, repo, tx, parentId, refField)
+	}
+
+	rows, err := GetRelatedSaveRows(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	saveParam := &SaveParam{
+		AppDb:   saveFile.AppDb,
+		ModelId: FILE_MODEL_ID,
+		List:    saveFile.getSaveRows(parentId, rows, refField),
+		//只能更新和删除本表数据行对应字段的文件记录，避免修改其它模型、字段或数据行的文件
+		parentScope: []saveScope{
+			{Field: "model_id", Value: saveFile.ModelId},
+			{Field: "field_id", Value: refField.Field},
+			{Field: "row_id", Value: parentId},
+		},
+	}
+	return SaveWithTxContext(ctx, saveParam, repo, tx)
+}
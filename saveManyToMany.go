@@ -0,0 +1,123 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+type SaveManyToMany struct {
+	AppDb   string `json:"appDb"`
+	ModelId string `json:"modelId"`
+}
+
+// 多对多字段的保存仅维护中间表中的关联关系，关联表本身的数据不做修改
+// create表示增加关联，delete表示删除关联，update不做处理
+func (saveManyToMany *SaveManyToMany) getAssociationSQL(
+	associationModelId string,
+	relatedModelId string,
+	saveType string,
+	dialect Dialect) string {
+	dialect = getDialectOrDefault(dialect)
+	localIdField := dialect.QuoteIdentifier(saveManyToMany.ModelId + "_id")
+	relatedIdField := dialect.QuoteIdentifier(relatedModelId + "_id")
+	table := dialect.GetTableName(saveManyToMany.AppDb, associationModelId)
+
+	switch saveType {
+	case SAVE_CREATE:
+		return "insert into " + table + " (" + localIdField + "," + relatedIdField + ") values (?,?)"
+	case SAVE_DELETE:
+		return "delete from " + table + " where " + localIdField + "=? and " + relatedIdField + "=?"
+	}
+	return ""
+}
+
+// 删除本表数据时，同时删除中间表中本表数据的所有关联关系
+func (saveManyToMany *SaveManyToMany) deleteParentAssociation(
+	ctx context.Context,
+	repo DataRepository,
+	tx *sql.Tx,
+	parentId string,
+	associationModelId string) (*SaveResult, error) {
+	dialect := getDialectOrDefault(repo.GetDialect())
+	sql := "delete from " + dialect.GetTableName(saveManyToMany.AppDb, associationModelId) +
+		" where " + dialect.QuoteIdentifier(saveManyToMany.ModelId+"_id") + "=?"
+	_, count, err := repo.ExecWithTxContext(ctx, sql, tx, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SaveResult{
+		ModelId: associationModelId,
+		Total:   count,
+		List:    []SaveRowResult{},
+	}, nil
+}
+
+func (saveManyToMany *SaveManyToMany) Save(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, parentSaveType string, value interface{}, refField *Field) (*SaveResult, error) {
+	if refField.RelatedModelId == nil {
+		slog.Error("Many2many field must have relatedModelId", "field", refField.Field, "model", saveManyToMany.ModelId)
+		return nil, errors.New("Many2many field must have relatedModelId, field:" + refField.Field + " model:" + saveManyToMany.ModelId)
+	}
+
+	relatedModelId := *(refField.RelatedModelId)
+	if err := CheckIdentifier(INVALID_KIND_MODELID, relatedModelId); err != nil {
+		return nil, err
+	}
+
+	associationModelId := GetRelatedModelId(saveManyToMany.ModelId, relatedModelId, refField.AssociationModelId)
+	if err := CheckIdentifier(INVALID_KIND_MODELID, associationModelId); err != nil {
+		return nil, err
+	}
+
+	if parentSaveType == SAVE_DELETE {
+		return saveManyToMany.deleteParentAssociation(ctx, repo, tx, parentId, associationModelId)
+	}
+
+	rows, err := GetRelatedSaveRows(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	result := &SaveResult{
+		ModelId: associationModelId,
+		Total:   0,
+		List:    []SaveRowResult{},
+	}
+
+	for _, row := range rows {
+		saveType, _ := row[SAVE_TYPE_COLUMN].(string)
+		rowResult := SaveRowResult{
+			Id:       getRowId(row),
+			SaveType: saveType,
+		}
+
+		if saveType != SAVE_CREATE && saveType != SAVE_UPDATE && saveType != SAVE_DELETE {
+			slog.Error("Many2many not supported save type", "saveType", saveType, "field", refField.Field, "model", saveManyToMany.ModelId)
+			return nil, errors.New("not supported save type " + saveType + ", field:" + refField.Field + " model:" + saveManyToMany.ModelId)
+		}
+
+		if len(rowResult.Id) == 0 {
+			slog.Error("Many2many save row must have id", "field", refField.Field, "model", saveManyToMany.ModelId)
+			return nil, errors.New("Many2many save row must have id, field:" + refField.Field + " model:" + saveManyToMany.ModelId)
+		}
+
+		sql := saveManyToMany.getAssociationSQL(associationModelId, relatedModelId, saveType, repo.GetDialect())
+		if len(sql) > 0 {
+			_, count, err := repo.ExecWithTxContext(ctx, sql, tx, parentId, rowResult.Id)
+			if err != nil {
+				return nil, err
+			}
+			rowResult.Count = count
+			result.Total += count
+		}
+		result.List = append(result.List, rowResult)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,85 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+)
+
+type SaveOneToMany struct {
+	AppDb   string `json:"appDb"`
+	ModelId string `json:"modelId"`
+}
+
+func (saveOneToMany *SaveOneToMany) getSaveRows(parentId string, rows []map[string]interface{}, refField *Field) []map[string]interface{} {
+	//一对多字段，关联表的关联字段存储了本表的ID，新建和更新时将关联字段设置为本表的ID
+	relatedField := *(refField.RelatedField)
+	saveRows := make([]map[string]interface{}, len(rows))
+	for index, row := range rows {
+		saveRow := make(map[string]interface{}, len(row)+1)
+		for key, value := range row {
+			saveRow[key] = value
+		}
+
+		saveType, _ := row[SAVE_TYPE_COLUMN].(string)
+		if saveType == SAVE_CREATE || saveType == SAVE_UPDATE {
+			saveRow[relatedField] = parentId
+		}
+		saveRows[index] = saveRow
+	}
+	return saveRows
+}
+
+// 删除本表数据对应的子表数据，子表数据自身的一对多、多对多和文件字段不做级联处理，需要时由数据库的外键处理
+func (saveOneToMany *SaveOneToMany) deleteParentRows(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, refField *Field) (*SaveResult, error) {
+	dialect := getDialectOrDefault(repo.GetDialect())
+	sql := "delete from " + dialect.GetTableName(saveOneToMany.AppDb, *(refField.RelatedModelId)) +
+		" where " + dialect.QuoteIdentifier(*(refField.RelatedField)) + "=?"
+	_, count, err := repo.ExecWithTxContext(ctx, sql, tx, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SaveResult{
+		ModelId: *(refField.RelatedModelId),
+		Total:   count,
+		List:    []SaveRowResult{},
+	}, nil
+}
+
+func (saveOneToMany *SaveOneToMany) Save(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, parentSaveType string, value interface{}, refField *Field) (*SaveResult, error) {
+	if refField.RelatedModelId == nil {
+		slog.Error("One2many field must have relatedModelId", "field", refField.Field, "model", saveOneToMany.ModelId)
+		return nil, errors.New("One2many field must have relatedModelId, field:" + refField.Field + " model:" + saveOneToMany.ModelId)
+	}
+
+	if refField.RelatedField == nil {
+		slog.Error("One2many field must have RelatedField", "field", refField.Field, "model", saveOneToMany.ModelId)
+		return nil, errors.New("One2many field must have RelatedField, field:" + refField.Field + " model:" + saveOneToMany.ModelId)
+	}
+
+	if parentSaveType == SAVE_DELETE {
+		return saveOneToMany.deleteParentRows(ctx, repo, tx, parentId, refField)
+	}
+
+	rows, err := GetRelatedSaveRows(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	//子表数据本身也可以包含关联字段，这里构造一个新的SaveParam递归保存子表数据
+	saveParam := &SaveParam{
+		AppDb:   saveOneToMany.AppDb,
+		ModelId: *(refField.RelatedModelId),
+		Fields:  refField.Fields,
+		List:    saveOneToMany.getSaveRows(parentId, rows, refField),
+		//子表数据只能更新和删除关联到本表数据的记录，避免修改其它父记录的子表数据
+		parentScope: []saveScope{{Field: *(refField.RelatedField), Value: parentId}},
+	}
+	return SaveWithTxContext(ctx, saveParam, repo, tx)
+}
@@ -0,0 +1,61 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"reflect"
+)
+
+type SaveRelatedModel interface {
+	Save(ctx context.Context, repo DataRepository, tx *sql.Tx, parentId string, parentSaveType string, value interface{}, refField *Field) (*SaveResult, error)
+}
+
+func GetRelatedModelSaver(appDb string, modelId string, fieldType string) SaveRelatedModel {
+	if fieldType == FIELDTYPE_MANY2MANY {
+		return &SaveManyToMany{
+			AppDb:   appDb,
+			ModelId: modelId,
+		}
+	} else if fieldType == FIELDTYPE_ONE2MANY {
+		return &SaveOneToMany{
+			AppDb:   appDb,
+			ModelId: modelId,
+		}
+	} else if fieldType == FIELDTYPE_FILE {
+		return &SaveFile{
+			AppDb:   appDb,
+			ModelId: modelId,
+		}
+	}
+	return nil
+}
+
+// 获取关联字段中需要保存的数据行，关联字段的值可以是{"list":[...]}或直接是数据行的数组
+func GetRelatedSaveRows(value interface{}) ([]map[string]interface{}, error) {
+	switch value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return GetRelatedSaveRows(value.(map[string]interface{})["list"])
+	case *SaveParam:
+		return value.(*SaveParam).List, nil
+	case []map[string]interface{}:
+		return value.([]map[string]interface{}), nil
+	case []interface{}:
+		var rows []map[string]interface{}
+		for _, item := range value.([]interface{}) {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				slog.Error("GetRelatedSaveRows the row of list is not a map[string]interface{}", "row", item)
+				return nil, errors.New("related save row is not an object")
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	default:
+		slog.Error("GetRelatedSaveRows not supported value type", "val type", reflect.TypeOf(value))
+		return nil, errors.New("not supported related save value type " + reflect.TypeOf(value).String())
+	}
+}
@@ -0,0 +1,180 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestGetSaveSQL(t *testing.T) {
+	row := map[string]interface{}{
+		"id":             "1",
+		"name":           "it's",
+		"age":            float64(20),
+		SAVE_TYPE_COLUMN: SAVE_CREATE,
+	}
+
+	sql, args, err := GetInsertSQL("app", "user", row, nil)
+	if err != nil {
+		t.Fatalf("GetInsertSQL failed: %v", err)
+	}
+	if sql != "insert into `app`.`user` (`age`,`id`,`name`) values (?,?,?)" {
+		t.Errorf("unexpected insert sql: %q", sql)
+	}
+	if fmt.Sprint(args) != "[20 1 it's]" {
+		t.Errorf("unexpected insert args: %v", args)
+	}
+
+	sql, args, err = GetUpdateSQL("app", "user", row, nil)
+	if err != nil {
+		t.Fatalf("GetUpdateSQL failed: %v", err)
+	}
+	if sql != "update `app`.`user` set `age`=?,`name`=? where `id`=?" {
+		t.Errorf("unexpected update sql: %q", sql)
+	}
+	if fmt.Sprint(args) != "[20 it's 1]" {
+		t.Errorf("unexpected update args: %v", args)
+	}
+
+	sql, args, err = GetDeleteSQL("app", "user", row, nil)
+	if err != nil {
+		t.Fatalf("GetDeleteSQL failed: %v", err)
+	}
+	if sql != "delete from `app`.`user` where `id`=?" {
+		t.Errorf("unexpected delete sql: %q", sql)
+	}
+	if fmt.Sprint(args) != "[1]" {
+		t.Errorf("unexpected delete args: %v", args)
+	}
+
+	_, _, err = GetUpdateSQL("app", "user", map[string]interface{}{"name": "x"}, nil)
+	if err == nil {
+		t.Errorf("GetUpdateSQL without id should fail")
+	}
+
+	_, _, err = GetInsertSQL("app", "user", map[string]interface{}{"name;drop": "x"}, nil)
+	if err == nil {
+		t.Errorf("GetInsertSQL with invalid column should fail")
+	}
+}
+
+// 记录执行的SQL语句，用于检查级联保存生成的语句
+type recordDataRepository struct {
+	statements []string
+	args       [][]interface{}
+	lastId     int64
+	dialect    Dialect
+}
+
+func (repo *recordDataRepository) GetDialect() Dialect {
+	return getDialectOrDefault(repo.dialect)
+}
+
+func (repo *recordDataRepository) Begin() (*sql.Tx, error) {
+	return nil, nil
+}
+
+func (repo *recordDataRepository) BeginContext(ctx context.Context) (*sql.Tx, error) {
+	return nil, nil
+}
+
+func (repo *recordDataRepository) Query(sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (repo *recordDataRepository) QueryContext(ctx context.Context, sql string, args ...interface{}) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (repo *recordDataRepository) ExecWithTx(sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	return repo.ExecWithTxContext(context.Background(), sql, tx, args...)
+}
+
+func (repo *recordDataRepository) ExecWithTxContext(ctx context.Context, sql string, tx *sql.Tx, args ...interface{}) (int64, int64, error) {
+	repo.statements = append(repo.statements, sql)
+	repo.args = append(repo.args, args)
+	repo.lastId++
+	return repo.lastId, 1, nil
+}
+
+func TestSaveWithTxCascade(t *testing.T) {
+	one2many := FIELDTYPE_ONE2MANY
+	many2many := FIELDTYPE_MANY2MANY
+	file := FIELDTYPE_FILE
+	lineModelId := "order_line"
+	orderId := "order_id"
+	tagModelId := "tag"
+
+	saveParam := &SaveParam{
+		AppDb:   "app",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "lines", FieldType: &one2many, RelatedModelId: &lineModelId, RelatedField: &orderId},
+			{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId},
+			{Field: "attachments", FieldType: &file},
+		},
+		List: []map[string]interface{}{
+			{
+				SAVE_TYPE_COLUMN: SAVE_CREATE,
+				"name":           "order1",
+				"lines": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "product": "p1"},
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "8", "product": "p2"},
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_DELETE, "id": "9"},
+					},
+				},
+				"tags": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t1"},
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_DELETE, "id": "t2"},
+					},
+				},
+				"attachments": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "name": "a.txt"},
+					},
+				},
+			},
+			{
+				SAVE_TYPE_COLUMN: SAVE_DELETE,
+				"id":             "5",
+			},
+		},
+	}
+
+	repo := &recordDataRepository{}
+	result, err := SaveWithTx(saveParam, repo, nil)
+	if err != nil {
+		t.Fatalf("SaveWithTx failed: %v", err)
+	}
+
+	expected := []string{
+		"insert into `app`.`order` (`name`) values (?) [order1]",
+		"insert into `app`.`order_line` (`order_id`,`product`) values (?,?) [1 p1]",
+		"update `app`.`order_line` set `order_id`=?,`product`=? where `id`=? and `order_id`=? [1 p2 8 1]",
+		"delete from `app`.`order_line` where `id`=? and `order_id`=? [9 1]",
+		"insert into `app`.`order_tag` (`order_id`,`tag_id`) values (?,?) [1 t1]",
+		"delete from `app`.`order_tag` where `order_id`=? and `tag_id`=? [1 t2]",
+		"insert into `app`.`core_file` (`field_id`,`model_id`,`name`,`row_id`) values (?,?,?,?) [attachments order a.txt 1]",
+		"delete from `app`.`order_line` where `order_id`=? [5]",
+		"delete from `app`.`order_tag` where `order_id`=? [5]",
+		"delete from `app`.`core_file` where `model_id`=? and `field_id`=? and `row_id`=? [order attachments 5]",
+		"delete from `app`.`order` where `id`=? [5]",
+	}
+
+	if len(repo.statements) != len(expected) {
+		t.Fatalf("unexpected statements: %v", repo.statements)
+	}
+	for index, statement := range repo.statements {
+		actual := statement + " " + fmt.Sprint(repo.args[index])
+		if actual != expected[index] {
+			t.Errorf("statement %d: expected %q, got %q", index, expected[index], actual)
+		}
+	}
+
+	if result.List[0].Id != "1" || result.List[0].Related["lines"].List[0].Id != "2" {
+		t.Errorf("unexpected result: %+v", result.List[0])
+	}
+}
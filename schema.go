@@ -0,0 +1,510 @@
+package crvorm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+/*
+从数据库的表结构生成模型定义
+mysql和postgres读取information_schema中的列、主键和外键，sqlite读取sqlite_master和pragma
+关联关系按照以下规则推断，外键只支持单列并且引用关联表的id字段
+1、外键字段为多对一字段，字段名就是外键列名
+2、被引用的表上增加一对多字段，字段名为引用表的表名，同一个表有多个外键引用时为表名_外键列名
+3、表名为两个模型ID按照GetRelatedModelId规则拼接，并且包含指向两个模型的外键模型ID_id时作为多对多的中间表，
+两个模型上分别增加多对多字段，字段名为对方的模型ID，与已有字段重名时使用中间表的表名
+*/
+
+// 数据库中表的列
+type SchemaColumn struct {
+	Name     string
+	DataType string
+	Nullable bool
+}
+
+// 单列外键，引用ReferencedTable的ReferencedColumn
+type SchemaForeignKey struct {
+	Column           string
+	ReferencedTable  string
+	ReferencedColumn string
+}
+
+type SchemaTable struct {
+	Name        string
+	Columns     []SchemaColumn
+	PrimaryKey  []string
+	ForeignKeys []SchemaForeignKey
+}
+
+// 获取列定义，列不存在时返回nil
+func (table *SchemaTable) GetColumn(name string) *SchemaColumn {
+	for index := range table.Columns {
+		if table.Columns[index].Name == name {
+			return &table.Columns[index]
+		}
+	}
+	return nil
+}
+
+// 数据库的表结构，表按照表名排序
+type DatabaseSchema struct {
+	Tables []*SchemaTable
+}
+
+func (schema *DatabaseSchema) GetTable(name string) *SchemaTable {
+	for _, table := range schema.Tables {
+		if table.Name == name {
+			return table
+		}
+	}
+	return nil
+}
+
+// 读取appDb中所有表的结构，appDb为空时读取当前连接的数据库
+func ReadSchema(ctx context.Context, repo DataRepository, appDb string) (*DatabaseSchema, error) {
+	if len(appDb) > 0 {
+		if err := CheckIdentifier(INVALID_KIND_APPDB, appDb); err != nil {
+			return nil, err
+		}
+	}
+
+	var reader schemaReader
+	switch repo.GetDialect().Name() {
+	case DIALECT_MYSQL:
+		reader = &mysqlSchemaReader{repo: repo, appDb: appDb}
+	case DIALECT_POSTGRES:
+		reader = &postgresSchemaReader{repo: repo, appDb: appDb}
+	case DIALECT_SQLITE:
+		reader = &sqliteSchemaReader{repo: repo, appDb: appDb}
+	default:
+		return nil, fmt.Errorf("not supported dialect %s for schema introspection", repo.GetDialect().Name())
+	}
+	return reader.read(ctx)
+}
+
+// 读取appDb的表结构并生成模型定义
+func LoadModelDefinitions(ctx context.Context, repo DataRepository, appDb string) ([]*ModelDefinition, error) {
+	schema, err := ReadSchema(ctx, repo, appDb)
+	if err != nil {
+		return nil, err
+	}
+	return SchemaToModelDefinitions(schema), nil
+}
+
+func (repo *DefatultDataRepository) LoadModelDefinitions(ctx context.Context, appDb string) ([]*ModelDefinition, error) {
+	return LoadModelDefinitions(ctx, repo, appDb)
+}
+
+// 从数据库的表结构加载模型定义，已经注册的同名模型会被替换
+func (registry *ModelRegistry) LoadFromSchema(ctx context.Context, repo DataRepository, appDb string) error {
+	models, err := LoadModelDefinitions(ctx, repo, appDb)
+	if err != nil {
+		return err
+	}
+	return registry.Register(models...)
+}
+
+// 根据表结构生成模型定义，没有id字段并且没有单列主键的表无法作为模型查询，不生成模型定义
+func SchemaToModelDefinitions(schema *DatabaseSchema) []*ModelDefinition {
+	models := map[string]*ModelDefinition{}
+	var modelIds []string
+	for _, table := range schema.Tables {
+		model := tableToModelDefinition(table)
+		if model == nil {
+			continue
+		}
+		models[table.Name] = model
+		modelIds = append(modelIds, table.Name)
+	}
+
+	//没有id字段的表不生成模型定义，但是仍然可以作为多对多的中间表
+	for _, table := range schema.Tables {
+		modelId := table.Name
+		association := getAssociationModels(table, models)
+		if model, ok := models[modelId]; ok {
+			addForeignKeyFields(table, model, models, association != nil)
+		}
+		if association != nil {
+			addManyToManyField(association[0], association[1], modelId)
+			addManyToManyField(association[1], association[0], modelId)
+		}
+	}
+
+	result := make([]*ModelDefinition, 0, len(modelIds))
+	for _, modelId := range modelIds {
+		result = append(result, models[modelId])
+	}
+	return result
+}
+
+// 外键字段作为多对一字段，并在关联模型上添加一对多字段，中间表的外键已经作为多对多字段，不再生成一对多字段
+func addForeignKeyFields(table *SchemaTable, model *ModelDefinition, models map[string]*ModelDefinition, isAssociation bool) {
+	for _, foreignKey := range table.ForeignKeys {
+		related, ok := models[foreignKey.ReferencedTable]
+		//关联字段按照id关联数据，主键上的外键是一对一的扩展表，不作为关联字段
+		if !ok || foreignKey.ReferencedColumn != CC_ID || related.GetPrimaryKey() != CC_ID || foreignKey.Column == model.GetPrimaryKey() {
+			continue
+		}
+		field := model.GetField(foreignKey.Column)
+		field.FieldType = FIELDTYPE_MANY2ONE
+		field.RelatedModelId = related.ModelId
+		if isAssociation {
+			continue
+		}
+		fieldName := model.ModelId
+		if countReferences(table, foreignKey.ReferencedTable) > 1 {
+			fieldName = model.ModelId + "_" + foreignKey.Column
+		}
+		addInferredField(related, ModelField{
+			Field:          fieldName,
+			FieldType:      FIELDTYPE_ONE2MANY,
+			RelatedModelId: model.ModelId,
+			RelatedField:   foreignKey.Column,
+		})
+	}
+}
+
+func tableToModelDefinition(table *SchemaTable) *ModelDefinition {
+	model := &ModelDefinition{ModelId: table.Name}
+	if len(table.PrimaryKey) == 1 && table.PrimaryKey[0] != CC_ID {
+		model.PrimaryKey = table.PrimaryKey[0]
+	}
+	for _, column := range table.Columns {
+		model.Fields = append(model.Fields, ModelField{
+			Field:    column.Name,
+			DataType: column.DataType,
+			Nullable: column.Nullable,
+		})
+	}
+	if model.GetField(CC_ID) == nil && len(model.PrimaryKey) == 0 {
+		slog.Warn("SchemaToModelDefinitions table has no id column or single column primary key, skipped", "table", table.Name)
+		return nil
+	}
+	if err := model.Validate(); err != nil {
+		slog.Warn("SchemaToModelDefinitions table can not be converted to model definition, skipped", "table", table.Name, "error", err)
+		return nil
+	}
+	return model
+}
+
+// 判断表是否为多对多的中间表，是则返回关联的两个模型
+func getAssociationModels(table *SchemaTable, models map[string]*ModelDefinition) []*ModelDefinition {
+	for _, foreignKey := range table.ForeignKeys {
+		modelId := foreignKey.ReferencedTable
+		if foreignKey.Column != modelId+"_id" || !strings.HasPrefix(table.Name, modelId+"_") {
+			continue
+		}
+		relatedModelId := strings.TrimPrefix(table.Name, modelId+"_")
+		if modelId == relatedModelId || GetRelatedModelId(modelId, relatedModelId, nil) != table.Name {
+			continue
+		}
+		for _, relatedForeignKey := range table.ForeignKeys {
+			if relatedForeignKey.Column == relatedModelId+"_id" &&
+				relatedForeignKey.ReferencedTable == relatedModelId &&
+				relatedForeignKey.ReferencedColumn == CC_ID &&
+				foreignKey.ReferencedColumn == CC_ID &&
+				models[modelId] != nil && models[relatedModelId] != nil &&
+				models[modelId].GetPrimaryKey() == CC_ID && models[relatedModelId].GetPrimaryKey() == CC_ID {
+				return []*ModelDefinition{models[modelId], models[relatedModelId]}
+			}
+		}
+	}
+	return nil
+}
+
+func countReferences(table *SchemaTable, referencedTable string) int {
+	count := 0
+	for _, foreignKey := range table.ForeignKeys {
+		if foreignKey.ReferencedTable == referencedTable && foreignKey.ReferencedColumn == CC_ID {
+			count++
+		}
+	}
+	return count
+}
+
+func addManyToManyField(model *ModelDefinition, related *ModelDefinition, associationModelId string) {
+	fieldName := related.ModelId
+	if model.GetField(fieldName) != nil {
+		fieldName = associationModelId
+	}
+	addInferredField(model, ModelField{
+		Field:          fieldName,
+		FieldType:      FIELDTYPE_MANY2MANY,
+		RelatedModelId: related.ModelId,
+	})
+}
+
+// 推断的关联字段与已有字段重名时不添加
+func addInferredField(model *ModelDefinition, field ModelField) {
+	if model.GetField(field.Field) != nil {
+		slog.Warn("SchemaToModelDefinitions inferred field conflicts with existing field, skipped", "modelId", model.ModelId, "field", field.Field, "fieldType", field.FieldType)
+		return
+	}
+	model.Fields = append(model.Fields, field)
+}
+
+type schemaReader interface {
+	read(ctx context.Context) (*DatabaseSchema, error)
+}
+
+// 按照查询结果中的表名、列名组织表结构，各个查询的结果需要按照表名和列的顺序排序
+type schemaBuilder struct {
+	tables map[string]*SchemaTable
+}
+
+func (builder *schemaBuilder) getTable(name string) *SchemaTable {
+	if builder.tables == nil {
+		builder.tables = map[string]*SchemaTable{}
+	}
+	table, ok := builder.tables[name]
+	if !ok {
+		table = &SchemaTable{Name: name}
+		builder.tables[name] = table
+	}
+	return table
+}
+
+func (builder *schemaBuilder) addColumn(tableName string, column SchemaColumn) {
+	table := builder.getTable(tableName)
+	table.Columns = append(table.Columns, column)
+}
+
+func (builder *schemaBuilder) addPrimaryKey(tableName string, column string) {
+	if table, ok := builder.tables[tableName]; ok {
+		table.PrimaryKey = append(table.PrimaryKey, column)
+	}
+}
+
+// 外键按照约束名称分组，多列的外键不支持关联查询，不做处理
+func (builder *schemaBuilder) addForeignKeys(rows []map[string]interface{}) {
+	constraintColumns := map[string]int{}
+	for _, row := range rows {
+		constraintColumns[getSchemaString(row["table_name"])+"."+getSchemaString(row["constraint_name"])]++
+	}
+	for _, row := range rows {
+		tableName := getSchemaString(row["table_name"])
+		if constraintColumns[tableName+"."+getSchemaString(row["constraint_name"])] > 1 {
+			continue
+		}
+		if table, ok := builder.tables[tableName]; ok {
+			table.ForeignKeys = append(table.ForeignKeys, SchemaForeignKey{
+				Column:           getSchemaString(row["column_name"]),
+				ReferencedTable:  getSchemaString(row["referenced_table_name"]),
+				ReferencedColumn: getSchemaString(row["referenced_column_name"]),
+			})
+		}
+	}
+}
+
+// 外键按照列的顺序排序，保证生成的关联字段顺序稳定
+func (builder *schemaBuilder) schema() *DatabaseSchema {
+	schema := &DatabaseSchema{}
+	for _, table := range builder.tables {
+		positions := map[string]int{}
+		for index, column := range table.Columns {
+			positions[column.Name] = index
+		}
+		sort.SliceStable(table.ForeignKeys, func(i, j int) bool {
+			return positions[table.ForeignKeys[i].Column] < positions[table.ForeignKeys[j].Column]
+		})
+		schema.Tables = append(schema.Tables, table)
+	}
+	sort.Slice(schema.Tables, func(i, j int) bool { return schema.Tables[i].Name < schema.Tables[j].Name })
+	return schema
+}
+
+func getSchemaString(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value.(string)
+	case []byte:
+		return string(value.([]byte))
+	}
+	return fmt.Sprint(value)
+}
+
+type mysqlSchemaReader struct {
+	repo  DataRepository
+	appDb string
+}
+
+// appDb为空时使用当前连接的数据库
+func (reader *mysqlSchemaReader) schemaCondition(alias string) (string, []interface{}) {
+	if len(reader.appDb) == 0 {
+		return alias + ".TABLE_SCHEMA=database()", nil
+	}
+	return alias + ".TABLE_SCHEMA=?", []interface{}{reader.appDb}
+}
+
+func (reader *mysqlSchemaReader) read(ctx context.Context) (*DatabaseSchema, error) {
+	builder := &schemaBuilder{}
+	condition, args := reader.schemaCondition("c")
+	columns, err := reader.repo.QueryContext(ctx,
+		"select c.TABLE_NAME as table_name,c.COLUMN_NAME as column_name,c.COLUMN_TYPE as data_type,c.IS_NULLABLE as is_nullable"+
+			" from information_schema.COLUMNS c join information_schema.TABLES t on t.TABLE_SCHEMA=c.TABLE_SCHEMA and t.TABLE_NAME=c.TABLE_NAME"+
+			" where "+condition+" and t.TABLE_TYPE='BASE TABLE' order by c.TABLE_NAME,c.ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range columns {
+		builder.addColumn(getSchemaString(row["table_name"]), SchemaColumn{
+			Name:     getSchemaString(row["column_name"]),
+			DataType: getSchemaString(row["data_type"]),
+			Nullable: getSchemaString(row["is_nullable"]) == "YES",
+		})
+	}
+
+	condition, args = reader.schemaCondition("k")
+	keys, err := reader.repo.QueryContext(ctx,
+		"select k.TABLE_NAME as table_name,k.COLUMN_NAME as column_name from information_schema.KEY_COLUMN_USAGE k"+
+			" where "+condition+" and k.CONSTRAINT_NAME='PRIMARY' order by k.TABLE_NAME,k.ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range keys {
+		builder.addPrimaryKey(getSchemaString(row["table_name"]), getSchemaString(row["column_name"]))
+	}
+
+	//只处理引用同一个库中的表的外键
+	foreignKeys, err := reader.repo.QueryContext(ctx,
+		"select k.CONSTRAINT_NAME as constraint_name,k.TABLE_NAME as table_name,k.COLUMN_NAME as column_name,"+
+			"k.REFERENCED_TABLE_NAME as referenced_table_name,k.REFERENCED_COLUMN_NAME as referenced_column_name"+
+			" from information_schema.KEY_COLUMN_USAGE k where "+condition+
+			" and k.REFERENCED_TABLE_SCHEMA=k.TABLE_SCHEMA and k.REFERENCED_TABLE_NAME is not null"+
+			" order by k.TABLE_NAME,k.CONSTRAINT_NAME,k.ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, err
+	}
+	builder.addForeignKeys(foreignKeys)
+	return builder.schema(), nil
+}
+
+// postgres中appDb对应schema，为空时使用public
+type postgresSchemaReader struct {
+	repo  DataRepository
+	appDb string
+}
+
+func (reader *postgresSchemaReader) read(ctx context.Context) (*DatabaseSchema, error) {
+	schemaName := reader.appDb
+	if len(schemaName) == 0 {
+		schemaName = "public"
+	}
+
+	builder := &schemaBuilder{}
+	columns, err := reader.repo.QueryContext(ctx,
+		"select c.table_name,c.column_name,c.data_type,c.is_nullable"+
+			" from information_schema.columns c join information_schema.tables t on t.table_schema=c.table_schema and t.table_name=c.table_name"+
+			" where c.table_schema=? and t.table_type='BASE TABLE' order by c.table_name,c.ordinal_position", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range columns {
+		builder.addColumn(getSchemaString(row["table_name"]), SchemaColumn{
+			Name:     getSchemaString(row["column_name"]),
+			DataType: getSchemaString(row["data_type"]),
+			Nullable: getSchemaString(row["is_nullable"]) == "YES",
+		})
+	}
+
+	keys, err := reader.repo.QueryContext(ctx,
+		"select k.table_name,k.column_name from information_schema.table_constraints tc"+
+			" join information_schema.key_column_usage k on k.constraint_schema=tc.constraint_schema and k.constraint_name=tc.constraint_name"+
+			" where tc.table_schema=? and tc.constraint_type='PRIMARY KEY' order by k.table_name,k.ordinal_position", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range keys {
+		builder.addPrimaryKey(getSchemaString(row["table_name"]), getSchemaString(row["column_name"]))
+	}
+
+	foreignKeys, err := reader.repo.QueryContext(ctx,
+		"select k.constraint_name,k.table_name,k.column_name,u.table_name as referenced_table_name,u.column_name as referenced_column_name"+
+			" from information_schema.table_constraints tc"+
+			" join information_schema.key_column_usage k on k.constraint_schema=tc.constraint_schema and k.constraint_name=tc.constraint_name"+
+			" join information_schema.constraint_column_usage u on u.constraint_schema=tc.constraint_schema and u.constraint_name=tc.constraint_name"+
+			" where tc.table_schema=? and tc.constraint_type='FOREIGN KEY' and u.table_schema=tc.table_schema"+
+			" order by k.table_name,k.constraint_name,k.ordinal_position", schemaName)
+	if err != nil {
+		return nil, err
+	}
+	builder.addForeignKeys(foreignKeys)
+	return builder.schema(), nil
+}
+
+// sqlite中appDb对应attach的数据库名称，为空时使用main
+type sqliteSchemaReader struct {
+	repo  DataRepository
+	appDb string
+}
+
+func (reader *sqliteSchemaReader) read(ctx context.Context) (*DatabaseSchema, error) {
+	appDb := reader.appDb
+	if len(appDb) == 0 {
+		appDb = "main"
+	}
+	dialect := reader.repo.GetDialect()
+
+	tables, err := reader.repo.QueryContext(ctx,
+		"select name from "+dialect.GetTableName(appDb, "sqlite_master")+
+			" where type='table' and name not like 'sqlite\\_%' escape '\\' order by name")
+	if err != nil {
+		return nil, err
+	}
+
+	builder := &schemaBuilder{}
+	for _, row := range tables {
+		tableName := getSchemaString(row["name"])
+		builder.getTable(tableName)
+		columns, err := reader.repo.QueryContext(ctx, "pragma "+dialect.QuoteIdentifier(appDb)+".table_info("+dialect.QuoteIdentifier(tableName)+")")
+		if err != nil {
+			return nil, err
+		}
+		//pk为列在主键中的位置，从1开始
+		primaryKey := map[int64]string{}
+		for _, column := range columns {
+			name := getSchemaString(column["name"])
+			builder.addColumn(tableName, SchemaColumn{
+				Name:     name,
+				DataType: getSchemaString(column["type"]),
+				Nullable: fmt.Sprint(column["notnull"]) == "0" && fmt.Sprint(column["pk"]) == "0",
+			})
+			if position, ok := column["pk"].(int64); ok && position > 0 {
+				primaryKey[position] = name
+			}
+		}
+		for position := int64(1); position <= int64(len(primaryKey)); position++ {
+			builder.addPrimaryKey(tableName, primaryKey[position])
+		}
+
+		foreignKeys, err := reader.repo.QueryContext(ctx, "pragma "+dialect.QuoteIdentifier(appDb)+".foreign_key_list("+dialect.QuoteIdentifier(tableName)+")")
+		if err != nil {
+			return nil, err
+		}
+		for _, foreignKey := range foreignKeys {
+			foreignKey["table_name"] = tableName
+			foreignKey["constraint_name"] = foreignKey["id"]
+			foreignKey["column_name"] = foreignKey["from"]
+			foreignKey["referenced_table_name"] = foreignKey["table"]
+			foreignKey["referenced_column_name"] = foreignKey["to"]
+		}
+		builder.addForeignKeys(foreignKeys)
+	}
+
+	//外键没有给出引用的列时引用的是主键
+	schema := builder.schema()
+	for _, table := range schema.Tables {
+		for index, foreignKey := range table.ForeignKeys {
+			referenced := schema.GetTable(foreignKey.ReferencedTable)
+			if len(foreignKey.ReferencedColumn) == 0 && referenced != nil && len(referenced.PrimaryKey) == 1 {
+				table.ForeignKeys[index].ReferencedColumn = referenced.PrimaryKey[0]
+			}
+		}
+	}
+	return schema, nil
+}
@@ -0,0 +1,135 @@
+package crvorm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSQLiteLoadModelDefinitions(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`create table author (id text primary key, name text not null)`,
+		`create table book (id text primary key, title text, author_id text references author(id), editor_id text references author(id))`,
+		`create table genre (id text primary key, name text)`,
+		`create table book_genre (id integer primary key autoincrement, book_id text references book(id), genre_id text references genre)`,
+		`create table book_detail (id text primary key references book(id), summary text)`,
+		`create table book_stat (book_id text, day text, primary key (book_id, day))`,
+		`create table currency (code text not null primary key, name text)`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("create table failed: %v", err)
+		}
+	}
+
+	registry := NewModelRegistry()
+	if err := registry.LoadFromSchema(context.Background(), repo, "main"); err != nil {
+		t.Fatalf("LoadFromSchema failed: %v", err)
+	}
+	if _, ok := registry.GetModel("book_stat"); ok {
+		t.Errorf("table without id column should be skipped")
+	}
+	if err := registry.Validate(); err != nil {
+		t.Errorf("inferred models should be valid: %v", err)
+	}
+
+	expected := map[string]string{
+		"author": `{"modelId":"author","fields":[{"field":"id","dataType":"TEXT"},{"field":"name","dataType":"TEXT"},` +
+			`{"field":"book_author_id","fieldType":"one2many","relatedModelId":"book","relatedField":"author_id"},` +
+			`{"field":"book_editor_id","fieldType":"one2many","relatedModelId":"book","relatedField":"editor_id"}]}`,
+		"book": `{"modelId":"book","fields":[{"field":"id","dataType":"TEXT"},{"field":"title","dataType":"TEXT","nullable":true},` +
+			`{"field":"author_id","dataType":"TEXT","fieldType":"many2one","relatedModelId":"author","nullable":true},` +
+			`{"field":"editor_id","dataType":"TEXT","fieldType":"many2one","relatedModelId":"author","nullable":true},` +
+			`{"field":"genre","fieldType":"many2many","relatedModelId":"genre"}]}`,
+		"genre": `{"modelId":"genre","fields":[{"field":"id","dataType":"TEXT"},{"field":"name","dataType":"TEXT","nullable":true},` +
+			`{"field":"book","fieldType":"many2many","relatedModelId":"book"}]}`,
+		"book_genre": `{"modelId":"book_genre","fields":[{"field":"id","dataType":"INTEGER"},` +
+			`{"field":"book_id","dataType":"TEXT","fieldType":"many2one","relatedModelId":"book","nullable":true},` +
+			`{"field":"genre_id","dataType":"TEXT","fieldType":"many2one","relatedModelId":"genre","nullable":true}]}`,
+		"currency":    `{"modelId":"currency","primaryKey":"code","fields":[{"field":"code","dataType":"TEXT"},{"field":"name","dataType":"TEXT","nullable":true}]}`,
+		"book_detail": `{"modelId":"book_detail","fields":[{"field":"id","dataType":"TEXT"},{"field":"summary","dataType":"TEXT","nullable":true}]}`,
+	}
+	for modelId, expectedJSON := range expected {
+		model, ok := registry.GetModel(modelId)
+		if !ok {
+			t.Errorf("model %s is not loaded", modelId)
+			continue
+		}
+		data, _ := json.Marshal(model)
+		if string(data) != expectedJSON {
+			t.Errorf("unexpected model %s: %s", modelId, data)
+		}
+	}
+
+	if _, err := repo.DB.Exec(`insert into author (id, name) values ('a1','author1');
+		insert into book (id, title, author_id) values ('b1','book1','a1');
+		insert into genre (id, name) values ('g1','genre1');
+		insert into book_genre (book_id, genre_id) values ('b1','g1')`); err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+	orm := &CrvOrm{Repo: repo, Registry: registry}
+	res, err := orm.ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "book",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "title"},
+			{Field: "author_id", Fields: &[]Field{{Field: "name"}}},
+			{Field: "genre", Fields: &[]Field{{Field: "name"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	author := res.List[0]["author_id"].(*QueryResult)
+	genre := res.List[0]["genre"].(*QueryResult)
+	if author.List[0]["name"] != "author1" || genre.Total != 1 || genre.List[0]["name"] != "genre1" {
+		t.Errorf("unexpected book %v", res.List[0])
+	}
+}
+
+func TestSQLiteLoadModelDefinitionsCompositeAssociation(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`create table book (id text primary key, title text)`,
+		`create table genre (id text primary key, name text)`,
+		`create table book_genre (book_id text references book(id), genre_id text references genre(id), primary key (book_id, genre_id))`,
+		`insert into book (id, title) values ('b1','book1')`,
+		`insert into genre (id, name) values ('g1','genre1'),('g2','genre2')`,
+		`insert into book_genre (book_id, genre_id) values ('b1','g1'),('b1','g2')`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("prepare failed: %v", err)
+		}
+	}
+
+	registry := NewModelRegistry()
+	if err := registry.LoadFromSchema(context.Background(), repo, "main"); err != nil {
+		t.Fatalf("LoadFromSchema failed: %v", err)
+	}
+	//复合主键的中间表不生成模型定义，但仍然生成多对多字段
+	if _, ok := registry.GetModel("book_genre"); ok {
+		t.Errorf("association table without id column should be skipped")
+	}
+	book, _ := registry.GetModel("book")
+	genre, _ := registry.GetModel("genre")
+	if field := book.GetField("genre"); field == nil || field.FieldType != FIELDTYPE_MANY2MANY || field.RelatedModelId != "genre" {
+		t.Errorf("unexpected book genre field %v", field)
+	}
+	if field := genre.GetField("book"); field == nil || field.FieldType != FIELDTYPE_MANY2MANY || field.RelatedModelId != "book" {
+		t.Errorf("unexpected genre book field %v", field)
+	}
+
+	orm := &CrvOrm{Repo: repo, Registry: registry}
+	res, err := orm.ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "book",
+		Fields:  &[]Field{{Field: "id"}, {Field: "genre", Fields: &[]Field{{Field: "name"}}}},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if genres := res.List[0]["genre"].(*QueryResult); genres.Total != 2 {
+		t.Errorf("unexpected genres %v", genres.List)
+	}
+}
@@ -0,0 +1,901 @@
+package crvorm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteTestSchema = []string{
+	`create table customer (id text primary key, name text, region text)`,
+	`create table "order" (id text primary key, name text, customer text, amount real, create_time text, attrs text)`,
+	`create table order_line (id text primary key, order_id text, product text, quantity integer)`,
+	`create table tag (id text primary key, name text)`,
+	`create table region (id text primary key, name text)`,
+	`create table order_tag (id integer primary key autoincrement, order_id text, tag_id text)`,
+	`create table category (id integer primary key, name text)`,
+	`create table product (id integer primary key, category_id integer, price decimal(10,2), active boolean, created datetime, attrs json, flag tinyint)`,
+	`create table core_file (id integer primary key autoincrement, model_id text, field_id text, row_id text, path text, name text, ext text, create_time text, create_user text, update_time text, update_user text, version integer)`,
+}
+
+// 创建一个使用sqlite内存数据库的DataRepository，用于测试不同数据库下生成的SQL语句
+func newSQLiteTestRepository(t testing.TB) *DefatultDataRepository {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite failed: %v", err)
+	}
+	//内存数据库的每个连接都是独立的数据库，这里只使用一个连接
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	for _, statement := range sqliteTestSchema {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("create table failed: %v", err)
+		}
+	}
+
+	return &DefatultDataRepository{
+		DB:      db,
+		Dialect: &SQLiteDialect{},
+	}
+}
+
+func TestSQLiteSaveAndQuery(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	one2many := FIELDTYPE_ONE2MANY
+	many2one := FIELDTYPE_MANY2ONE
+	many2many := FIELDTYPE_MANY2MANY
+	file := FIELDTYPE_FILE
+	lineModelId := "order_line"
+	orderId := "order_id"
+	tagModelId := "tag"
+	customerModelId := "customer"
+
+	_, err := ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "customer",
+		List: []map[string]interface{}{
+			{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "c1", "name": "it's a \\ customer", "region": "east"},
+		},
+	}, repo)
+	if err != nil {
+		t.Fatalf("save customer failed: %v", err)
+	}
+
+	_, err = ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "tag",
+		List: []map[string]interface{}{
+			{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t1", "name": "tag1"},
+			{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t2", "name": "tag2"},
+		},
+	}, repo)
+	if err != nil {
+		t.Fatalf("save tag failed: %v", err)
+	}
+
+	saveFields := &[]Field{
+		{Field: "lines", FieldType: &one2many, RelatedModelId: &lineModelId, RelatedField: &orderId},
+		{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId},
+		{Field: "attachments", FieldType: &file},
+	}
+	saveResult, err := ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  saveFields,
+		List: []map[string]interface{}{
+			{
+				SAVE_TYPE_COLUMN: SAVE_CREATE,
+				"id":             "o1",
+				"name":           "order1",
+				"customer":       "c1",
+				"amount":         10.5,
+				"lines": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "l1", "product": "p1", "quantity": 1},
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "l2", "product": "p2", "quantity": 2},
+					},
+				},
+				"tags": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t1"},
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "id": "t2"},
+					},
+				},
+				"attachments": map[string]interface{}{
+					"list": []interface{}{
+						map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_CREATE, "name": "a.txt", "path": "/a.txt"},
+					},
+				},
+			},
+		},
+	}, repo)
+	if err != nil {
+		t.Fatalf("save order failed: %v", err)
+	}
+	if saveResult.List[0].Id != "o1" || len(saveResult.List[0].Related["lines"].List) != 2 {
+		t.Errorf("unexpected save result: %+v", saveResult.List[0])
+	}
+
+	query := &QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{Field: "name"},
+			{Field: "customer", FieldType: &many2one, RelatedModelId: &customerModelId, Fields: &[]Field{{Field: "id"}, {Field: "name"}}},
+			{Field: "lines", FieldType: &one2many, RelatedModelId: &lineModelId, RelatedField: &orderId, Fields: &[]Field{{Field: "id"}, {Field: "order_id"}, {Field: "product"}}},
+			{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId, Fields: &[]Field{{Field: "id"}, {Field: "name"}}},
+			{Field: "attachments", FieldType: &file},
+		},
+		Filter: &map[string]interface{}{
+			"name": "order",
+		},
+	}
+
+	res, err := ExecuteQuery(query, repo, true)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if res.Total != 1 || len(res.List) != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	row := res.List[0]
+	if customer, ok := row["customer"].(*QueryResult); !ok || customer.Total != 1 || customer.List[0]["name"] != "it's a \\ customer" {
+		t.Errorf("unexpected customer: %+v", row["customer"])
+	}
+	if lines, ok := row["lines"].(*QueryResult); !ok || lines.Total != 2 {
+		t.Errorf("unexpected lines: %+v", row["lines"])
+	}
+	if tags, ok := row["tags"].(*QueryResult); !ok || tags.Total != 2 {
+		t.Errorf("unexpected tags: %+v", row["tags"])
+	}
+	if attachments, ok := row["attachments"].(*QueryResult); !ok || attachments.Total != 1 {
+		t.Errorf("unexpected attachments: %+v", row["attachments"])
+	}
+
+	//不能通过o1更新或删除其它订单的明细
+	if _, err := repo.DB.Exec(`insert into order_line (id, order_id, product) values ('l9','o2','p9')`); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  saveFields,
+		List: []map[string]interface{}{{
+			SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "o1",
+			"lines": map[string]interface{}{"list": []interface{}{
+				map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "l9", "product": "changed"},
+				map[string]interface{}{SAVE_TYPE_COLUMN: SAVE_DELETE, "id": "l9"},
+			}},
+		}},
+	}, repo)
+	if err != nil {
+		t.Fatalf("save lines failed: %v", err)
+	}
+	if rows, _ := repo.Query(`select order_id, product from order_line where id='l9'`); len(rows) != 1 || rows[0]["order_id"] != "o2" || rows[0]["product"] != "p9" {
+		t.Errorf("line of other order should not be changed, got %v", rows)
+	}
+
+	//不能通过o1的文件字段更新或删除其它订单的文件
+	if _, err := repo.DB.Exec(`insert into core_file (id, model_id, field_id, row_id, name) values (99,'order','attachments','o2','other.txt')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, saveType := range []string{SAVE_UPDATE, SAVE_DELETE} {
+		result, err := ExecuteSave(&SaveParam{
+			AppDb:   "main",
+			ModelId: "order",
+			Fields:  saveFields,
+			List: []map[string]interface{}{{
+				SAVE_TYPE_COLUMN: SAVE_UPDATE, "id": "o1",
+				"attachments": map[string]interface{}{"list": []interface{}{
+					map[string]interface{}{SAVE_TYPE_COLUMN: saveType, "id": 99, "name": "changed.txt"},
+				}},
+			}},
+		}, repo)
+		if err != nil {
+			t.Fatalf("save attachments failed: %v", err)
+		}
+		if count := result.List[0].Related["attachments"].List[0].Count; count != 0 {
+			t.Errorf("%s file of other order should be refused, count %d", saveType, count)
+		}
+	}
+	if rows, _ := repo.Query(`select row_id, name from core_file where id=99`); len(rows) != 1 || rows[0]["row_id"] != "o2" || rows[0]["name"] != "other.txt" {
+		t.Errorf("file of other order should not be changed, got %v", rows)
+	}
+
+	_, err = ExecuteSave(&SaveParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  saveFields,
+		List: []map[string]interface{}{
+			{SAVE_TYPE_COLUMN: SAVE_DELETE, "id": "o1"},
+		},
+	}, repo)
+	if err != nil {
+		t.Fatalf("delete order failed: %v", err)
+	}
+
+	for _, table := range []string{"order", "order_tag", "core_file", "order_line"} {
+		rows, err := repo.Query(`select count(*) as c from "` + table + `" where id not in ('l9',99)`)
+		if err != nil {
+			t.Fatalf("count %s failed: %v", table, err)
+		}
+		if rows[0]["c"] != int64(0) {
+			t.Errorf("%s should be empty after delete, got %v", table, rows[0]["c"])
+		}
+	}
+}
+
+func TestSQLiteQueryPerParentPagination(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	one2many := FIELDTYPE_ONE2MANY
+	many2many := FIELDTYPE_MANY2MANY
+	lineModelId := "order_line"
+	orderId := "order_id"
+	tagModelId := "tag"
+
+	statements := []string{
+		`insert into "order" (id, name) values ('o1','order1'),('o2','order2'),('o3','order3')`,
+		`insert into order_line (id, order_id, product) values ('l1','o1','p1'),('l2','o1','p2'),('l3','o1','p3'),('l4','o2','p4')`,
+		`insert into tag (id, name) values ('t1','a'),('t2','b'),('t3','c')`,
+		`insert into order_tag (order_id, tag_id) values ('o1','t1'),('o1','t2'),('o1','t3'),('o2','t2')`,
+	}
+	for _, statement := range statements {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	query := &QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields: &[]Field{
+			{Field: "id"},
+			{
+				Field:          "lines",
+				FieldType:      &one2many,
+				RelatedModelId: &lineModelId,
+				RelatedField:   &orderId,
+				Fields:         &[]Field{{Field: "id"}, {Field: "order_id"}},
+				Sorter:         &[]Sorter{{Field: "id", Order: "desc"}},
+				Pagination:     &Pagination{Current: 1, PageSize: 2, PerParent: true},
+			},
+			{
+				Field:          "tags",
+				FieldType:      &many2many,
+				RelatedModelId: &tagModelId,
+				Fields:         &[]Field{{Field: "id"}, {Field: "name"}},
+				Filter:         &map[string]interface{}{"name": map[string]interface{}{Op_ne: "a"}},
+				Pagination:     &Pagination{Current: 1, PageSize: 1, PerParent: true},
+			},
+		},
+	}
+
+	res, err := ExecuteQuery(query, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if len(res.List) != 3 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	expectedLines := map[string][]string{"o1": {"l3", "l2"}, "o2": {"l4"}}
+	expectedLineTotals := map[string]int{"o1": 3, "o2": 1}
+	expectedTagTotals := map[string]int{"o1": 2, "o2": 1}
+	for _, row := range res.List {
+		id := row["id"].(string)
+		lines, ok := row["lines"].(*QueryResult)
+		if !ok {
+			t.Fatalf("%s: lines missing", id)
+		}
+		if lines.Total != expectedLineTotals[id] || len(lines.List) != len(expectedLines[id]) {
+			t.Errorf("%s: unexpected lines: %+v", id, lines)
+			continue
+		}
+		for index, line := range lines.List {
+			if line["id"] != expectedLines[id][index] {
+				t.Errorf("%s: unexpected line %d: %v", id, index, line)
+			}
+			if _, ok := line[PARTITION_ROW_NUMBER]; ok {
+				t.Errorf("%s: row number should be removed: %v", id, line)
+			}
+		}
+
+		tags, _ := row["tags"].(*QueryResult)
+		if expectedTagTotals[id] == 0 {
+			continue
+		}
+		if tags == nil || tags.Total != expectedTagTotals[id] || len(tags.List) != 1 || tags.List[0]["name"] == "a" {
+			t.Errorf("%s: unexpected tags: %+v", id, tags)
+		}
+	}
+
+	//第二页只有o1还有数据，其它记录也需要返回空的列表和总数
+	(*query.Fields)[1].Pagination = &Pagination{Current: 2, PageSize: 2, PerParent: true}
+	(*query.Fields)[2].Pagination = &Pagination{Current: 3, PageSize: 1, PerParent: true}
+	res, err = ExecuteQuery(query, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	expectedLines = map[string][]string{"o1": {"l1"}}
+	for _, row := range res.List {
+		id := row["id"].(string)
+		lines, ok := row["lines"].(*QueryResult)
+		if !ok || lines.Total != expectedLineTotals[id] || len(lines.List) != len(expectedLines[id]) {
+			t.Errorf("%s: unexpected lines on second page: %+v", id, row["lines"])
+		}
+		tags, ok := row["tags"].(*QueryResult)
+		if !ok || tags.Total != expectedTagTotals[id] || len(tags.List) != 0 {
+			t.Errorf("%s: unexpected tags on third page: %+v", id, row["tags"])
+		}
+	}
+}
+
+func TestSQLiteQueryCursor(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	_, err := repo.DB.Exec(`insert into "order" (id, name, amount) values ('o1','a',10),('o2','b',30),('o3','c',20),('o4','d',30),('o5','e',5)`)
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	cursor := ""
+	var ids []interface{}
+	for page := 0; page < 5; page++ {
+		query := &QueryParam{
+			AppDb:      "main",
+			ModelId:    "order",
+			Fields:     &[]Field{{Field: "id"}, {Field: "amount"}},
+			Sorter:     &[]Sorter{{Field: "amount", Order: "desc"}},
+			Pagination: &Pagination{PageSize: 2},
+			Filter:     &map[string]interface{}{"amount": map[string]interface{}{Op_gt: 5}},
+			Cursor:     &cursor,
+		}
+		res, err := ExecuteQuery(query, repo, true)
+		if err != nil {
+			t.Fatalf("ExecuteQuery failed: %v", err)
+		}
+		if res.Total != 4 {
+			t.Errorf("total should not be affected by cursor, got %d", res.Total)
+		}
+		for _, row := range res.List {
+			ids = append(ids, row["id"])
+		}
+		if res.NextCursor == nil {
+			break
+		}
+		cursor = *res.NextCursor
+	}
+
+	if fmt.Sprint(ids) != "[o2 o4 o3 o1]" {
+		t.Errorf("unexpected cursor pages: %v", ids)
+	}
+
+	bad := "not a cursor"
+	_, err = ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: &[]Field{{Field: "id"}}, Cursor: &bad}, repo, false)
+	var validationErr *QueryValidationError
+	if !errors.As(err, &validationErr) || validationErr.Kind != INVALID_KIND_CURSOR {
+		t.Errorf("expected cursor validation error, got %v", err)
+	}
+}
+
+func TestSQLiteQueryCursorNullAndTime(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+	for index, created := range []interface{}{t3, nil, t1, t2, nil, t1} {
+		if _, err := repo.DB.Exec(`insert into product (id, created) values (?,?)`, index+1, created); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	//sqlite中空值排在升序的最前面、降序的最后面
+	expected := map[string]string{"asc": "[2 5 3 6 4 1]", "desc": "[1 4 3 6 2 5]"}
+	for order, expectedIds := range expected {
+		cursor := ""
+		var ids []interface{}
+		for page := 0; page < 5; page++ {
+			res, err := ExecuteQuery(&QueryParam{
+				AppDb:      "main",
+				ModelId:    "product",
+				Fields:     &[]Field{{Field: "id"}, {Field: "created"}},
+				Sorter:     &[]Sorter{{Field: "created", Order: order}},
+				Pagination: &Pagination{PageSize: 2},
+				Cursor:     &cursor,
+			}, repo, false)
+			if err != nil {
+				t.Fatalf("ExecuteQuery failed: %v", err)
+			}
+			for _, row := range res.List {
+				ids = append(ids, row["id"])
+			}
+			if res.NextCursor == nil {
+				break
+			}
+			cursor = *res.NextCursor
+		}
+		if fmt.Sprint(ids) != expectedIds {
+			t.Errorf("%s: expected cursor pages %s, got %v", order, expectedIds, ids)
+		}
+	}
+}
+
+func TestSQLiteGroupQuery(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	_, err := repo.DB.Exec(`insert into "order" (id, customer, amount, create_time) values
+		('o1','c1',10,'2024-01-05 10:00:00'),('o2','c1',20,'2024-01-20 10:00:00'),
+		('o3','c2',5,'2024-02-01 10:00:00'),('o4','c3',30,'2024-02-03 10:00:00'),('o5','c3',1,'2024-03-03 10:00:00')`)
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	query := &QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		GroupBy: &[]GroupField{{Field: "customer"}},
+		Aggregates: &[]Aggregate{
+			{Field: "total", Summarize: "sum(amount)"},
+			{Field: "count", Summarize: "count(*)"},
+		},
+		Having: &map[string]interface{}{"total": map[string]interface{}{Op_gt: 15}},
+		Sorter: &[]Sorter{{Field: "total", Order: "desc"}},
+	}
+	res, err := ExecuteQuery(query, repo, true)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if res.Total != 2 || len(res.List) != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.List[0]["customer"] != "c3" || res.List[0]["total"] != float64(31) || res.List[1]["count"] != int64(2) {
+		t.Errorf("unexpected groups: %v", res.List)
+	}
+
+	query = &QueryParam{
+		AppDb:      "main",
+		ModelId:    "order",
+		GroupBy:    &[]GroupField{{Field: "create_time", Period: DATE_PERIOD_MONTH}},
+		Aggregates: &[]Aggregate{{Field: "count", Summarize: "count(*)"}},
+	}
+	res, err = ExecuteQuery(query, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	var months []string
+	for _, row := range res.List {
+		months = append(months, fmt.Sprint(row["create_time"], ":", row["count"]))
+	}
+	if fmt.Sprint(months) != "[2024-01:2 2024-02:2 2024-03:1]" {
+		t.Errorf("unexpected month groups: %v", months)
+	}
+
+	//只有聚合字段时整个表作为一个分组，总数为分组的个数而不是数据的条数
+	aggregateTests := []struct {
+		having   map[string]interface{}
+		current  int
+		total    int
+		expected string
+	}{
+		{nil, 1, 1, "[66]"},
+		{nil, 2, 1, "[]"},
+		{map[string]interface{}{"total": map[string]interface{}{Op_gt: 100}}, 1, 0, "[]"},
+	}
+	for _, test := range aggregateTests {
+		query = &QueryParam{
+			AppDb:      "main",
+			ModelId:    "order",
+			Aggregates: &[]Aggregate{{Field: "total", Summarize: "sum(amount)"}},
+			Pagination: &Pagination{Current: test.current, PageSize: 2},
+		}
+		if test.having != nil {
+			query.Having = &test.having
+		}
+		res, err = ExecuteQuery(query, repo, true)
+		if err != nil {
+			t.Fatalf("ExecuteQuery failed: %v", err)
+		}
+		var totals []interface{}
+		for _, row := range res.List {
+			totals = append(totals, row["total"])
+		}
+		if res.Total != test.total || fmt.Sprint(totals) != test.expected {
+			t.Errorf("page %d having %v: expected %d %s, got %d %v", test.current, test.having, test.total, test.expected, res.Total, totals)
+		}
+	}
+}
+
+func TestSQLiteRelationPathFilter(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	statements := []string{
+		`insert into region (id, name) values ('r1','east'),('r2','west')`,
+		`insert into customer (id, name, region) values ('c1','customer1','r1'),('c2','customer2','r2')`,
+		`insert into "order" (id, name, customer) values ('o1','order1','c1'),('o2','order2','c2'),('o3','order3','c1')`,
+		`insert into order_line (id, order_id, product) values ('l1','o1','p1'),('l2','o2','p2'),('l3','o3','p2')`,
+		`insert into tag (id, name) values ('t1','urgent'),('t2','normal')`,
+		`insert into order_tag (order_id, tag_id) values ('o1','t1'),('o2','t1'),('o3','t2')`,
+	}
+	for _, statement := range statements {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	many2one := FIELDTYPE_MANY2ONE
+	one2many := FIELDTYPE_ONE2MANY
+	many2many := FIELDTYPE_MANY2MANY
+	customerModelId := "customer"
+	regionModelId := "region"
+	lineModelId := "order_line"
+	orderId := "order_id"
+	tagModelId := "tag"
+	fields := &[]Field{
+		{Field: "id"},
+		{
+			Field:          "customer",
+			FieldType:      &many2one,
+			RelatedModelId: &customerModelId,
+			Fields: &[]Field{
+				{Field: "id"},
+				{Field: "region", FieldType: &many2one, RelatedModelId: &regionModelId, Fields: &[]Field{{Field: "id"}}},
+			},
+		},
+		{Field: "lines", FieldType: &one2many, RelatedModelId: &lineModelId, RelatedField: &orderId, Fields: &[]Field{{Field: "id"}}},
+		{Field: "tags", FieldType: &many2many, RelatedModelId: &tagModelId, Fields: &[]Field{{Field: "id"}}},
+	}
+
+	tests := []struct {
+		filter   map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"customer.region.name": map[string]interface{}{Op_eq: "east"}}, "[o1 o3]"},
+		{map[string]interface{}{"customer": map[string]interface{}{Op_exists: map[string]interface{}{"region.name": map[string]interface{}{Op_eq: "east"}}}}, "[o1 o3]"},
+		{map[string]interface{}{Op_not: map[string]interface{}{"lines.product": map[string]interface{}{Op_eq: "p2"}}}, "[o1]"},
+		{map[string]interface{}{"lines.product": map[string]interface{}{Op_eq: "p2"}}, "[o2 o3]"},
+		{map[string]interface{}{"tags.name": map[string]interface{}{Op_eq: "urgent"}, "customer.region.name": map[string]interface{}{Op_eq: "west"}}, "[o2]"},
+		{map[string]interface{}{Op_search: map[string]interface{}{MATCH_QUERY: "der 3", MATCH_FIELDS: []interface{}{"id", "name"}}, "customer.region.name": map[string]interface{}{Op_eq: "east"}}, "[o3]"},
+	}
+	for _, test := range tests {
+		filter := test.filter
+		res, err := ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: fields, Filter: &filter}, repo, true)
+		if err != nil {
+			t.Fatalf("ExecuteQuery failed: %v", err)
+		}
+		var ids []interface{}
+		for _, row := range res.List {
+			ids = append(ids, row["id"])
+		}
+		if fmt.Sprint(ids) != test.expected || res.Total != len(ids) {
+			t.Errorf("filter %v: expected %s, got %v total %d", test.filter, test.expected, ids, res.Total)
+		}
+	}
+
+	filter := map[string]interface{}{"customer.unknown.name": "x"}
+	_, err := ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: fields, Filter: &filter}, repo, false)
+	var validationErr *QueryValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("expected validation error for unknown relation, got %v", err)
+	}
+}
+
+func TestSQLiteRelationPathSorter(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	statements := []string{
+		`insert into region (id, name) values ('r1','north'),('r2','east')`,
+		`insert into customer (id, name, region) values ('c1','beta','r1'),('c2','alpha','r2')`,
+		`insert into "order" (id, name, customer) values ('o1','order1','c1'),('o2','order2','c2'),('o3','order3','c1'),('o4','order4',null)`,
+	}
+	for _, statement := range statements {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	many2one := FIELDTYPE_MANY2ONE
+	customerModelId := "customer"
+	regionModelId := "region"
+	fields := &[]Field{
+		{Field: "id"},
+		{
+			Field:          "customer",
+			FieldType:      &many2one,
+			RelatedModelId: &customerModelId,
+			Fields: &[]Field{
+				{Field: "id"},
+				{Field: "name"},
+				{Field: "region", FieldType: &many2one, RelatedModelId: &regionModelId, Fields: &[]Field{{Field: "id"}, {Field: "name"}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		sorters  []Sorter
+		expected string
+	}{
+		{[]Sorter{{Field: "customer.name"}, {Field: "id", Order: "desc"}}, "[o4 o2 o3 o1]"},
+		{[]Sorter{{Field: "customer.region.name", Order: "desc"}, {Field: "id"}}, "[o1 o3 o2 o4]"},
+	}
+	for _, test := range tests {
+		sorters := test.sorters
+		res, err := ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: fields, Sorter: &sorters}, repo, false)
+		if err != nil {
+			t.Fatalf("ExecuteQuery failed: %v", err)
+		}
+		var ids []interface{}
+		for _, row := range res.List {
+			ids = append(ids, row["id"])
+		}
+		if fmt.Sprint(ids) != test.expected {
+			t.Errorf("sorter %v: expected %s, got %v", test.sorters, test.expected, ids)
+		}
+		//关联字段仍然按照原来的方式加载
+		if customer, ok := res.List[0]["customer"].(*QueryResult); ok && customer.List[0]["region"] == nil {
+			t.Errorf("related field should still be loaded: %+v", customer)
+		}
+	}
+}
+
+func TestSQLiteJSONPath(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	_, err := repo.DB.Exec(`insert into "order" (id, attrs) values
+		('o1','{"color":"red","rank":2,"sizes":[38,39],"tag":{"name":"a"}}'),
+		('o2','{"color":"blue","rank":3,"sizes":[40]}'),
+		('o3','{"color":"red","rank":5,"sizes":[]}'),
+		('o4',null)`)
+	if err != nil {
+		t.Fatalf("insert failed: %v", err)
+	}
+
+	filter := map[string]interface{}{"attrs->$.color": map[string]interface{}{Op_eq: "red"}}
+	res, err := ExecuteQuery(&QueryParam{
+		AppDb:   "main",
+		ModelId: "order",
+		Fields:  &[]Field{{Field: "id"}, {Field: "attrs->$.rank"}, {Field: "attrs->$.sizes"}, {Field: "attrs->$.sizes[0]"}, {Field: "attrs->$.tag"}},
+		Filter:  &filter,
+		Sorter:  &[]Sorter{{Field: "attrs->$.rank", Order: "desc"}},
+	}, repo, true)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if res.Total != 2 || len(res.List) != 2 {
+		t.Fatalf("unexpected result %v", res.List)
+	}
+	if res.List[0]["id"] != "o3" || res.List[0]["attrs->$.rank"] != float64(5) || res.List[0]["attrs->$.sizes[0]"] != nil {
+		t.Errorf("unexpected first row %v", res.List[0])
+	}
+	second := res.List[1]
+	if fmt.Sprint(second["attrs->$.sizes"]) != "[38 39]" || second["attrs->$.sizes[0]"] != float64(38) ||
+		fmt.Sprint(second["attrs->$.tag"]) != "map[name:a]" {
+		t.Errorf("unexpected second row %v", second)
+	}
+
+	filter = map[string]interface{}{"attrs->$.color'": "red"}
+	_, err = ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: &[]Field{{Field: "id"}}, Filter: &filter}, repo, false)
+	var validationErr *QueryValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("expected validation error for invalid json path, got %v", err)
+	}
+}
+
+type testRegion struct {
+	Id   string `crvorm:"id"`
+	Name string `crvorm:"name"`
+}
+
+type testCustomer struct {
+	Id     string      `crvorm:"id"`
+	Name   *string     `crvorm:"name"`
+	Region *testRegion `crvorm:"region,many2one,model=region"`
+}
+
+type testOrderLine struct {
+	Id       string `crvorm:"id"`
+	Product  string `crvorm:"product"`
+	Quantity int    `crvorm:"quantity"`
+}
+
+type testEntity struct {
+	Id string `crvorm:"id"`
+}
+
+type testOrder struct {
+	testEntity
+	Amount   float64         `crvorm:"amount"`
+	Color    string          `crvorm:"attrs->$.color"`
+	Customer testCustomer    `crvorm:"customer,many2one,model=customer"`
+	Lines    []testOrderLine `crvorm:"lines,one2many,model=order_line,relatedField=order_id"`
+	Tags     []*testRegion   `crvorm:"tags,many2many,model=tag"`
+	Raw      *QueryResult    `crvorm:"-"`
+	internal string
+}
+
+func TestSQLiteScanStruct(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`insert into region (id, name) values ('r1','east')`,
+		`insert into customer (id, name, region) values ('c1','customer1','r1'),('c2',null,null)`,
+		`insert into "order" (id, customer, amount, attrs) values ('o1','c1',12.5,'{"color":"red"}'),('o2','c2',3,null)`,
+		`insert into order_line (id, order_id, product, quantity) values ('l1','o1','p1',2),('l2','o1','p2',5)`,
+		`insert into tag (id, name) values ('t1','urgent')`,
+		`insert into order_tag (order_id, tag_id) values ('o1','t1')`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	fields, err := GetStructFields[testOrder]()
+	if err != nil {
+		t.Fatalf("GetStructFields failed: %v", err)
+	}
+	fieldsJSON, _ := json.Marshal(fields)
+	expectedFields := `[{"field":"id"},{"field":"amount"},{"field":"attrs-\u003e$.color"},` +
+		`{"field":"customer","fieldType":"many2one","relatedModelId":"customer","fields":[{"field":"id"},{"field":"name"},{"field":"region","fieldType":"many2one","relatedModelId":"region","fields":[{"field":"id"},{"field":"name"}]}]},` +
+		`{"field":"lines","fieldType":"one2many","relatedModelId":"order_line","relatedField":"order_id","fields":[{"field":"id"},{"field":"product"},{"field":"quantity"},{"field":"order_id"}]},` +
+		`{"field":"tags","fieldType":"many2many","relatedModelId":"tag","fields":[{"field":"id"},{"field":"name"}]}]`
+	if string(fieldsJSON) != expectedFields {
+		t.Fatalf("unexpected fields %s", fieldsJSON)
+	}
+
+	res, err := ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "order", Fields: fields}, repo, true)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	orders, err := ScanResult[testOrder](res)
+	if err != nil {
+		t.Fatalf("ScanResult failed: %v", err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("unexpected orders %v", orders)
+	}
+
+	first := orders[0]
+	if first.Id != "o1" || first.Amount != 12.5 || first.Color != "red" ||
+		first.Customer.Id != "c1" || first.Customer.Name == nil || *first.Customer.Name != "customer1" ||
+		first.Customer.Region == nil || first.Customer.Region.Name != "east" {
+		t.Errorf("unexpected first order %+v", first)
+	}
+	if fmt.Sprint(first.Lines) != "[{l1 p1 2} {l2 p2 5}]" || len(first.Tags) != 1 || first.Tags[0].Name != "urgent" {
+		t.Errorf("unexpected first order relations %+v %+v", first.Lines, first.Tags)
+	}
+
+	second := orders[1]
+	if second.Id != "o2" || second.Color != "" || second.Customer.Name != nil || second.Customer.Region != nil ||
+		len(second.Lines) != 0 || len(second.Tags) != 0 {
+		t.Errorf("unexpected second order %+v", second)
+	}
+
+	type badOrder struct {
+		Amount []int `crvorm:"amount"`
+	}
+	if _, err := ScanResult[badOrder](res); err == nil {
+		t.Errorf("expected error when scanning number into slice")
+	}
+	if _, ok := structFieldsCache.Load(reflect.TypeOf(testOrder{})); !ok {
+		t.Errorf("struct fields should be cached by type")
+	}
+
+	type numbers struct {
+		Small    int8    `crvorm:"small"`
+		Count    int     `crvorm:"count"`
+		Unsigned uint    `crvorm:"unsigned"`
+		Ratio    float32 `crvorm:"ratio"`
+	}
+	for _, row := range []map[string]interface{}{
+		{"small": int64(300)},
+		{"small": "-129"},
+		{"count": 12.5},
+		{"count": float64(1 << 63)},
+		{"unsigned": int64(-1)},
+		{"ratio": 1e39},
+	} {
+		var value numbers
+		if err := ScanRow(row, &value); err == nil {
+			t.Errorf("expected error when scanning %v, got %+v", row, value)
+		}
+	}
+	var value numbers
+	if err := ScanRow(map[string]interface{}{"small": int64(-128), "count": float64(12), "unsigned": int64(7), "ratio": 0.5}, &value); err != nil ||
+		value.Small != -128 || value.Count != 12 || value.Unsigned != 7 || value.Ratio != 0.5 {
+		t.Errorf("unexpected numbers %+v %v", value, err)
+	}
+	type badTag struct {
+		Id string `crvorm:"id,unknown"`
+	}
+	if _, err := GetStructFields[badTag](); err == nil {
+		t.Errorf("expected error for unknown tag option")
+	}
+}
+
+func TestSQLiteValueConversion(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	for _, statement := range []string{
+		`insert into category (id, name) values (1,'book'),(2,'food')`,
+		`insert into product (id, category_id, price, active, created, attrs, flag) values
+			(10,1,'12.50',1,'2024-03-01 08:30:00','{"color":"red"}',1),
+			(11,1,null,0,null,null,0),
+			(12,2,3,1,'2024-03-02 00:00:00','[1,2]',1)`,
+	} {
+		if _, err := repo.DB.Exec(statement); err != nil {
+			t.Fatalf("insert failed: %v", err)
+		}
+	}
+
+	many2one := FIELDTYPE_MANY2ONE
+	one2many := FIELDTYPE_ONE2MANY
+	categoryModelId := "category"
+	productModelId := "product"
+	categoryId := "category_id"
+	fields := &[]Field{
+		{Field: "id"},
+		{Field: "name"},
+		{Field: "products", FieldType: &one2many, RelatedModelId: &productModelId, RelatedField: &categoryId, Fields: &[]Field{
+			{Field: "id"}, {Field: "category_id"}, {Field: "price"}, {Field: "active"}, {Field: "created"}, {Field: "attrs"}, {Field: "flag"},
+			{Field: "category_id", FieldType: &many2one, RelatedModelId: &categoryModelId, Fields: &[]Field{{Field: "id"}, {Field: "name"}}},
+		}},
+	}
+	res, err := ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "category", Fields: fields}, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+
+	products := res.List[0]["products"].(*QueryResult)
+	if res.List[0]["id"] != int64(1) || products.Total != 2 {
+		t.Fatalf("unexpected category %v", res.List[0])
+	}
+	product := products.List[0]
+	created := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	if product["price"] != 12.5 || product["active"] != true || product["flag"] != int64(1) ||
+		!created.Equal(product["created"].(time.Time)) || fmt.Sprint(product["attrs"]) != "map[color:red]" {
+		t.Errorf("unexpected product %v", product)
+	}
+	if category := product["category_id"].(*QueryResult); category.Total != 1 || category.List[0]["name"] != "book" {
+		t.Errorf("unexpected product category %+v", category)
+	}
+	if empty := products.List[1]; empty["price"] != nil || empty["active"] != false || empty["attrs"] != nil {
+		t.Errorf("unexpected empty product %v", empty)
+	}
+
+	repo.ValueConversion = &ValueConversion{TinyintAsBool: true, TimeFormat: "2006-01-02", JSONAsString: true}
+	res, err = ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "product", Fields: &[]Field{{Field: "id"}, {Field: "created"}, {Field: "attrs"}, {Field: "flag"}}}, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if row := res.List[2]; row["created"] != "2024-03-02" || row["attrs"] != "[1,2]" || row["flag"] != true {
+		t.Errorf("unexpected converted product %v", row)
+	}
+
+	repo.ValueConversion = &ValueConversion{Raw: true}
+	res, err = ExecuteQuery(&QueryParam{AppDb: "main", ModelId: "product", Fields: &[]Field{{Field: "id"}, {Field: "attrs"}}}, repo, false)
+	if err != nil {
+		t.Fatalf("ExecuteQuery failed: %v", err)
+	}
+	if row := res.List[0]; row["attrs"] != `{"color":"red"}` {
+		t.Errorf("raw conversion should keep json text, got %v", row)
+	}
+}
+
+func TestSQLiteRowsToMapCanceled(t *testing.T) {
+	repo := newSQLiteTestRepository(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rows, err := repo.DB.QueryContext(ctx, `with recursive n(i) as (select 1 union all select i+1 from n where i<100000) select i from n`)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	defer rows.Close()
+	//读取过程中取消查询，不能返回部分数据
+	cancel()
+	list, err := repo.rowsToMap(rows)
+	if err == nil {
+		t.Errorf("expected error after context canceled, got %d rows", len(list))
+	}
+}
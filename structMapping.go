@@ -0,0 +1,543 @@
+package crvorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+查询结果与结构体的映射，结构体字段通过crvorm标签指定对应的字段名和关联信息，没有标签的字段不做映射
+crvorm:"name"                                                  //普通字段
+crvorm:"customer,many2one,model=customer"                      //多对一字段，字段类型为结构体或结构体指针，也可以是string类型的ID
+crvorm:"lines,one2many,model=order_line,relatedField=order_id" //一对多字段，字段类型为结构体切片
+crvorm:"tags,many2many,model=tag,association=order_tag"        //多对多字段，字段类型为结构体切片
+crvorm:"files,file"                                            //文件字段
+crvorm:"-"                                                     //忽略该字段
+关联字段的类型也可以是*QueryResult，此时直接使用查询结果，无法推导关联模型的字段
+*/
+
+const STRUCT_TAG_NAME = "crvorm"
+
+// 结构体字段标签中的选项
+const (
+	TAG_OPTION_MODEL         = "model"
+	TAG_OPTION_RELATED_FIELD = "relatedField"
+	TAG_OPTION_ASSOCIATION   = "association"
+	TAG_OPTION_DATA_TYPE     = "dataType"
+)
+
+// 字符串转换为时间时依次尝试的格式
+var structTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+var queryResultType = reflect.TypeOf(&QueryResult{})
+var timeType = reflect.TypeOf(time.Time{})
+
+type structField struct {
+	index []int
+	field Field
+}
+
+// 解析crvorm标签，返回nil表示忽略该字段
+func parseStructTag(tag string) (*Field, error) {
+	if len(tag) == 0 || tag == "-" {
+		return nil, nil
+	}
+
+	options := strings.Split(tag, ",")
+	field := &Field{Field: strings.TrimSpace(options[0])}
+	if err := CheckFieldIdentifier(field.Field); err != nil {
+		return nil, err
+	}
+	for _, option := range options[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+		switch key {
+		case FIELDTYPE_MANY2ONE, FIELDTYPE_ONE2MANY, FIELDTYPE_MANY2MANY, FIELDTYPE_FILE:
+			fieldType := key
+			field.FieldType = &fieldType
+		case TAG_OPTION_MODEL:
+			field.RelatedModelId = &value
+		case TAG_OPTION_RELATED_FIELD:
+			field.RelatedField = &value
+		case TAG_OPTION_ASSOCIATION:
+			field.AssociationModelId = &value
+		case TAG_OPTION_DATA_TYPE:
+			field.DataType = &value
+		default:
+			slog.Error("parseStructTag not supported tag option", "tag", tag, "option", option)
+			return nil, fmt.Errorf("not supported crvorm tag option %s in tag %s", option, tag)
+		}
+	}
+	return field, nil
+}
+
+// 结构体的字段按照类型缓存，每次映射时不再重复解析标签，缓存的内容不能被修改
+var structFieldsCache sync.Map
+
+// 获取结构体中带有crvorm标签的字段，匿名嵌入的结构体没有标签时展开其中的字段
+func getStructFields(structType reflect.Type) ([]structField, error) {
+	if cached, ok := structFieldsCache.Load(structType); ok {
+		return cached.([]structField), nil
+	}
+	fields, err := parseStructFields(structType)
+	if err != nil {
+		return nil, err
+	}
+	structFieldsCache.Store(structType, fields)
+	return fields, nil
+}
+
+func parseStructFields(structType reflect.Type) ([]structField, error) {
+	var fields []structField
+	for i := 0; i < structType.NumField(); i++ {
+		item := structType.Field(i)
+		tag, ok := item.Tag.Lookup(STRUCT_TAG_NAME)
+		if !ok && item.Anonymous {
+			embeddedType := item.Type
+			if embeddedType.Kind() == reflect.Pointer {
+				embeddedType = embeddedType.Elem()
+			}
+			if embeddedType.Kind() == reflect.Struct {
+				embeddedFields, err := getStructFields(embeddedType)
+				if err != nil {
+					return nil, err
+				}
+				for _, embeddedField := range embeddedFields {
+					embeddedField.index = append([]int{i}, embeddedField.index...)
+					fields = append(fields, embeddedField)
+				}
+				continue
+			}
+		}
+		if !ok || !item.IsExported() {
+			continue
+		}
+
+		field, err := parseStructTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if field == nil {
+			continue
+		}
+		fields = append(fields, structField{index: []int{i}, field: *field})
+	}
+	return fields, nil
+}
+
+// 获取切片和指针的元素类型
+func getStructElemType(fieldType reflect.Type) reflect.Type {
+	for fieldType.Kind() == reflect.Pointer || fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	return fieldType
+}
+
+// 根据结构体的crvorm标签生成查询字段，关联字段按照关联结构体生成关联模型的查询字段
+func GetStructFields[T any]() (*[]Field, error) {
+	return getFieldsFromType(reflect.TypeOf((*T)(nil)).Elem(), nil)
+}
+
+func getFieldsFromType(structType reflect.Type, parents []reflect.Type) (*[]Field, error) {
+	structType = getStructElemType(structType)
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("type %v is not a struct", structType)
+	}
+	for _, parent := range parents {
+		if parent == structType {
+			slog.Error("getFieldsFromType struct has circular relation", "type", structType)
+			return nil, fmt.Errorf("struct %v has circular relation", structType)
+		}
+	}
+	parents = append(parents, structType)
+
+	items, err := getStructFields(structType)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []Field{}
+	for _, item := range items {
+		field := item.field
+		if field.FieldType != nil {
+			relatedType := getStructElemType(structType.FieldByIndex(item.index).Type)
+			if relatedType.Kind() == reflect.Struct && relatedType != timeType && relatedType != queryResultType.Elem() {
+				field.Fields, err = getFieldsFromType(relatedType, parents)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				field.Fields = &[]Field{{Field: CC_ID}}
+			}
+			//关联数据按照ID或一对多的关联字段合并到父记录上，查询字段中必须包含这些字段
+			field.Fields = appendMissingField(field.Fields, CC_ID)
+			if *field.FieldType == FIELDTYPE_ONE2MANY && field.RelatedField != nil {
+				field.Fields = appendMissingField(field.Fields, *field.RelatedField)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return &fields, nil
+}
+
+func appendMissingField(fields *[]Field, fieldName string) *[]Field {
+	for _, field := range *fields {
+		if field.Field == fieldName {
+			return fields
+		}
+	}
+	list := append(*fields, Field{Field: fieldName})
+	return &list
+}
+
+// 将查询结果转换为结构体列表
+func ScanResult[T any](result *QueryResult) ([]T, error) {
+	if result == nil {
+		return nil, nil
+	}
+	return ScanRows[T](result.List)
+}
+
+// 将查询结果中的数据行转换为结构体列表
+func ScanRows[T any](rows []map[string]interface{}) ([]T, error) {
+	list := make([]T, len(rows))
+	for index, row := range rows {
+		if err := ScanRow(row, &list[index]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// 将一行数据转换到结构体中，dest必须是结构体指针
+func ScanRow(row map[string]interface{}, dest interface{}) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Pointer || destValue.IsNil() || destValue.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan destination must be a non-nil struct pointer, got %v", reflect.TypeOf(dest))
+	}
+	return scanRowToStruct(row, destValue.Elem())
+}
+
+func scanRowToStruct(row map[string]interface{}, structValue reflect.Value) error {
+	items, err := getStructFields(structValue.Type())
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		value, ok := row[item.field.Field]
+		if !ok {
+			continue
+		}
+		fieldValue, err := structValue.FieldByIndexErr(item.index)
+		if err != nil {
+			//匿名嵌入的结构体指针为nil时先创建
+			fieldValue = getEmbeddedFieldValue(structValue, item.index)
+		}
+		if err := assignStructValue(fieldValue, value); err != nil {
+			slog.Error("scanRowToStruct assign field failed", "field", item.field.Field, "error", err)
+			return fmt.Errorf("scan field %s failed: %w", item.field.Field, err)
+		}
+	}
+	return nil
+}
+
+func getEmbeddedFieldValue(structValue reflect.Value, index []int) reflect.Value {
+	value := structValue
+	for _, i := range index {
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(i)
+	}
+	return value
+}
+
+// 将查询结果中的值赋给结构体字段
+func assignStructValue(dest reflect.Value, value interface{}) error {
+	if value == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+
+	if result, ok := value.(*QueryResult); ok {
+		return assignRelatedResult(dest, result)
+	}
+
+	switch dest.Kind() {
+	case reflect.Pointer:
+		if dest.Type() == queryResultType {
+			break
+		}
+		elem := reflect.New(dest.Type().Elem())
+		if err := assignStructValue(elem.Elem(), value); err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	case reflect.Interface:
+		dest.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	source := reflect.ValueOf(value)
+	if source.Type().AssignableTo(dest.Type()) {
+		dest.Set(source)
+		return nil
+	}
+	if bytes, ok := value.([]byte); ok {
+		return assignStructValue(dest, string(bytes))
+	}
+
+	if sVal, ok := value.(string); ok {
+		return assignStringValue(dest, sVal)
+	}
+
+	if isNumberKind(source.Kind()) && isNumberKind(dest.Kind()) {
+		return assignNumberValue(dest, source)
+	}
+	//sqlite等数据库中布尔值保存为整数
+	if dest.Kind() == reflect.Bool && isNumberKind(source.Kind()) {
+		dest.SetBool(!source.IsZero())
+		return nil
+	}
+	if dest.Kind() == reflect.String && isNumberKind(source.Kind()) {
+		dest.SetString(fmt.Sprint(value))
+		return nil
+	}
+
+	//解码后的JSON值等其它类型，通过JSON转换到目标类型
+	return assignByJSON(dest, value)
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// 数值之间的转换，超出目标类型的范围或者将小数赋给整数字段时返回错误
+func assignNumberValue(dest reflect.Value, source reflect.Value) error {
+	switch {
+	case isIntKind(dest.Kind()):
+		var iVal int64
+		switch {
+		case isIntKind(source.Kind()):
+			iVal = source.Int()
+		case isUintKind(source.Kind()):
+			if source.Uint() > math.MaxInt64 {
+				return fmt.Errorf("value %v overflows %v", source, dest.Type())
+			}
+			iVal = int64(source.Uint())
+		default:
+			fVal := source.Float()
+			if fVal != math.Trunc(fVal) {
+				return fmt.Errorf("can not assign fractional value %v to %v", fVal, dest.Type())
+			}
+			if fVal < math.MinInt64 || fVal >= math.MaxInt64 {
+				return fmt.Errorf("value %v overflows %v", fVal, dest.Type())
+			}
+			iVal = int64(fVal)
+		}
+		return setIntValue(dest, iVal)
+	case isUintKind(dest.Kind()):
+		var uVal uint64
+		switch {
+		case isIntKind(source.Kind()):
+			if source.Int() < 0 {
+				return fmt.Errorf("value %v overflows %v", source, dest.Type())
+			}
+			uVal = uint64(source.Int())
+		case isUintKind(source.Kind()):
+			uVal = source.Uint()
+		default:
+			fVal := source.Float()
+			if fVal != math.Trunc(fVal) {
+				return fmt.Errorf("can not assign fractional value %v to %v", fVal, dest.Type())
+			}
+			if fVal < 0 || fVal >= math.MaxUint64 {
+				return fmt.Errorf("value %v overflows %v", fVal, dest.Type())
+			}
+			uVal = uint64(fVal)
+		}
+		return setUintValue(dest, uVal)
+	}
+
+	var fVal float64
+	switch {
+	case isIntKind(source.Kind()):
+		fVal = float64(source.Int())
+	case isUintKind(source.Kind()):
+		fVal = float64(source.Uint())
+	default:
+		fVal = source.Float()
+	}
+	return setFloatValue(dest, fVal)
+}
+
+func setIntValue(dest reflect.Value, value int64) error {
+	if dest.OverflowInt(value) {
+		return fmt.Errorf("value %d overflows %v", value, dest.Type())
+	}
+	dest.SetInt(value)
+	return nil
+}
+
+func setUintValue(dest reflect.Value, value uint64) error {
+	if dest.OverflowUint(value) {
+		return fmt.Errorf("value %d overflows %v", value, dest.Type())
+	}
+	dest.SetUint(value)
+	return nil
+}
+
+func setFloatValue(dest reflect.Value, value float64) error {
+	if dest.OverflowFloat(value) {
+		return fmt.Errorf("value %v overflows %v", value, dest.Type())
+	}
+	dest.SetFloat(value)
+	return nil
+}
+
+// 数据库驱动返回的字符串转换为目标类型，如decimal、时间等
+func assignStringValue(dest reflect.Value, value string) error {
+	switch dest.Kind() {
+	case reflect.String:
+		dest.SetString(value)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		iVal, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		return setIntValue(dest, iVal)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uVal, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		return setUintValue(dest, uVal)
+	case reflect.Float32, reflect.Float64:
+		fVal, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		return setFloatValue(dest, fVal)
+	case reflect.Bool:
+		bVal, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		dest.SetBool(bVal)
+		return nil
+	}
+
+	if dest.Type() == timeType {
+		for _, layout := range structTimeLayouts {
+			if tVal, err := time.Parse(layout, value); err == nil {
+				dest.Set(reflect.ValueOf(tVal))
+				return nil
+			}
+		}
+		return fmt.Errorf("can not parse time %s", value)
+	}
+
+	//JSON字段的原始文本
+	if err := json.Unmarshal([]byte(value), dest.Addr().Interface()); err != nil {
+		return fmt.Errorf("can not assign string to %v: %w", dest.Type(), err)
+	}
+	return nil
+}
+
+func assignByJSON(dest reflect.Value, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dest.Addr().Interface()); err != nil {
+		return fmt.Errorf("can not assign %v to %v: %w", reflect.TypeOf(value), dest.Type(), err)
+	}
+	return nil
+}
+
+// 关联字段的查询结果，结构体类型取第一行数据，切片类型取全部数据，字符串类型取多对一字段的ID
+func assignRelatedResult(dest reflect.Value, result *QueryResult) error {
+	if dest.Type() == queryResultType {
+		dest.Set(reflect.ValueOf(result))
+		return nil
+	}
+
+	switch dest.Kind() {
+	case reflect.Interface:
+		dest.Set(reflect.ValueOf(result))
+		return nil
+	case reflect.Slice:
+		list := reflect.MakeSlice(dest.Type(), len(result.List), len(result.List))
+		for index, row := range result.List {
+			if err := assignRelatedRow(list.Index(index), row); err != nil {
+				return err
+			}
+		}
+		dest.Set(list)
+		return nil
+	case reflect.Struct, reflect.Pointer:
+		if len(result.List) == 0 {
+			dest.Set(reflect.Zero(dest.Type()))
+			return nil
+		}
+		return assignRelatedRow(dest, result.List[0])
+	case reflect.String:
+		if result.Value != nil {
+			dest.SetString(*result.Value)
+			return nil
+		}
+		if len(result.List) > 0 {
+			return assignStructValue(dest, result.List[0][CC_ID])
+		}
+		return nil
+	}
+	return fmt.Errorf("can not assign related result to %v", dest.Type())
+}
+
+func assignRelatedRow(dest reflect.Value, row map[string]interface{}) error {
+	if dest.Kind() == reflect.Pointer {
+		elem := reflect.New(dest.Type().Elem())
+		if err := assignRelatedRow(elem.Elem(), row); err != nil {
+			return err
+		}
+		dest.Set(elem)
+		return nil
+	}
+	if dest.Kind() != reflect.Struct {
+		return fmt.Errorf("related row destination must be a struct, got %v", dest.Type())
+	}
+	return scanRowToStruct(row, dest)
+}
@@ -0,0 +1,205 @@
+package crvorm
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 查询结果中数据库列类型到Go类型的转换方式，零值表示按照列类型转换为以下类型
+// 整数为int64，浮点数为float64，DECIMAL为字符串，日期时间为time.Time，布尔为bool，JSON为解码后的值
+// 转换失败时保留字符串形式的值
+type ValueConversion struct {
+	//不根据列类型转换，[]byte转换为字符串，其它值保持驱动返回的类型
+	Raw bool `json:"raw" mapstructure:"raw"`
+	//DECIMAL转换为float64，默认使用字符串避免精度丢失
+	DecimalAsFloat bool `json:"decimalAsFloat" mapstructure:"decimalAsFloat"`
+	//TINYINT转换为bool，mysql的BOOLEAN实际是TINYINT(1)，驱动无法区分TINYINT(1)和其它长度的TINYINT
+	TinyintAsBool bool `json:"tinyintAsBool" mapstructure:"tinyintAsBool"`
+	//日期时间按照该格式转换为字符串，为空时转换为time.Time
+	TimeFormat string `json:"timeFormat" mapstructure:"timeFormat"`
+	//JSON保留原始的JSON文本
+	JSONAsString bool `json:"jsonAsString" mapstructure:"jsonAsString"`
+}
+
+var DefaultValueConversion = &ValueConversion{}
+
+// 数据库返回的日期时间字符串的格式，没有时区的按照UTC处理，与mysql驱动parseTime的默认设置一致
+var columnTimeLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02"}
+
+func getValueConversionOrDefault(conversion *ValueConversion) *ValueConversion {
+	if conversion == nil {
+		return DefaultValueConversion
+	}
+	return conversion
+}
+
+// 获取列类型名称，去掉无符号和长度等修饰，如UNSIGNED INT返回INT，VARCHAR(20)返回VARCHAR
+func getColumnTypeName(columnType *sql.ColumnType) string {
+	if columnType == nil {
+		return ""
+	}
+	typeName := strings.ToUpper(strings.TrimSpace(columnType.DatabaseTypeName()))
+	typeName = strings.TrimPrefix(typeName, "UNSIGNED ")
+	if index := strings.IndexByte(typeName, '('); index >= 0 {
+		typeName = strings.TrimSpace(typeName[:index])
+	}
+	return typeName
+}
+
+// 按照列类型转换驱动返回的值
+func (conversion *ValueConversion) ConvertValue(typeName string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if conversion.Raw {
+		if bytes, ok := value.([]byte); ok {
+			return string(bytes)
+		}
+		return value
+	}
+
+	switch typeName {
+	case "TINYINT":
+		if conversion.TinyintAsBool {
+			return conversion.convertBool(value)
+		}
+		return conversion.convertInteger(value)
+	case "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT", "YEAR", "INT2", "INT4", "INT8", "SERIAL", "BIGSERIAL":
+		return conversion.convertInteger(value)
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8", "DOUBLE PRECISION":
+		return conversion.convertFloat(value)
+	case "DECIMAL", "NUMERIC":
+		if conversion.DecimalAsFloat {
+			return conversion.convertFloat(value)
+		}
+		return conversion.convertString(value)
+	case "BOOL", "BOOLEAN":
+		return conversion.convertBool(value)
+	case "DATE", "DATETIME", "TIMESTAMP", "TIMESTAMPTZ":
+		return conversion.convertTime(value)
+	case "JSON", "JSONB":
+		return conversion.convertJSON(value)
+	case "BIT":
+		if bytes, ok := value.([]byte); ok && len(bytes) <= 8 {
+			padded := make([]byte, 8)
+			copy(padded[8-len(bytes):], bytes)
+			return int64(binary.BigEndian.Uint64(padded))
+		}
+		return value
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BYTEA":
+		return value
+	}
+	return conversion.convertString(value)
+}
+
+// 非字节类型的值保持驱动返回的类型
+func (conversion *ValueConversion) convertString(value interface{}) interface{} {
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return value
+}
+
+func (conversion *ValueConversion) convertInteger(value interface{}) interface{} {
+	switch value.(type) {
+	case int64:
+		return value
+	case []byte, string:
+		sVal := conversion.convertString(value).(string)
+		if iVal, err := strconv.ParseInt(sVal, 10, 64); err == nil {
+			return iVal
+		}
+		//超出int64范围的无符号整数
+		if uVal, err := strconv.ParseUint(sVal, 10, 64); err == nil {
+			return uVal
+		}
+		return sVal
+	}
+	return value
+}
+
+func (conversion *ValueConversion) convertFloat(value interface{}) interface{} {
+	switch value.(type) {
+	case float32:
+		return float64(value.(float32))
+	case []byte, string:
+		sVal := conversion.convertString(value).(string)
+		if fVal, err := strconv.ParseFloat(sVal, 64); err == nil {
+			return fVal
+		}
+		return sVal
+	}
+	return value
+}
+
+func (conversion *ValueConversion) convertBool(value interface{}) interface{} {
+	switch value.(type) {
+	case bool:
+		return value
+	case int64:
+		return value.(int64) != 0
+	case []byte, string:
+		sVal := conversion.convertString(value).(string)
+		if bVal, err := strconv.ParseBool(sVal); err == nil {
+			return bVal
+		}
+		return sVal
+	}
+	return value
+}
+
+func (conversion *ValueConversion) convertTime(value interface{}) interface{} {
+	var tVal time.Time
+	switch value.(type) {
+	case time.Time:
+		tVal = value.(time.Time)
+	case []byte, string:
+		sVal := conversion.convertString(value).(string)
+		parsed := false
+		for _, layout := range columnTimeLayouts {
+			if t, err := time.ParseInLocation(layout, sVal, time.UTC); err == nil {
+				tVal = t
+				parsed = true
+				break
+			}
+		}
+		//mysql的0000-00-00等无法解析的值保留原始的字符串
+		if !parsed {
+			return sVal
+		}
+	default:
+		return value
+	}
+
+	if len(conversion.TimeFormat) > 0 {
+		return tVal.Format(conversion.TimeFormat)
+	}
+	return tVal
+}
+
+func (conversion *ValueConversion) convertJSON(value interface{}) interface{} {
+	var data []byte
+	switch value.(type) {
+	case []byte:
+		data = value.([]byte)
+	case string:
+		data = []byte(value.(string))
+	default:
+		return value
+	}
+
+	if conversion.JSONAsString {
+		return string(data)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		slog.Debug("convertJSON value is not valid json, keep the original value", "error", err)
+		return string(data)
+	}
+	return decoded
+}